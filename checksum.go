@@ -0,0 +1,75 @@
+package drfs
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// ErrChecksum is returned when the data of a reply does not match its checksum.
+var ErrChecksum = errors.New("checksum mismatch")
+
+// ChecksumError reports a reply of which the data does not match its checksum.
+type ChecksumError struct {
+	Thread int
+	Reply  string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("thread %d: reply %s: %s", e.Thread, e.Reply, ErrChecksum)
+}
+
+func (e *ChecksumError) Unwrap() error {
+	return ErrChecksum
+}
+
+// checksumLen is the number of characters of the checksum preceding the encoded data of a reply.
+const checksumLen = 2 * crc32.Size
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumCodec prefixes the encoding of the wrapped codec with the CRC-32C of the data in hex, for files created with
+// FileOptions.Checksums. The ID remains that of the wrapped codec.
+type checksumCodec struct {
+	Codec
+}
+
+func withChecksum(c Codec) Codec {
+	return checksumCodec{c}
+}
+
+func (c checksumCodec) Class() Class {
+	return classOf(c.Codec)
+}
+
+func (c checksumCodec) EncodedLen(n int) int {
+	return checksumLen + c.Codec.EncodedLen(n)
+}
+
+func (c checksumCodec) Encode(p []byte) string {
+	var sum [crc32.Size]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.Checksum(p, castagnoli))
+	return hex.EncodeToString(sum[:]) + c.Codec.Encode(p)
+}
+
+func (c checksumCodec) Decode(s string) ([]byte, error) {
+	if len(s) < checksumLen {
+		return nil, ErrChecksum
+	}
+	sum, err := hex.DecodeString(s[:checksumLen])
+	if err != nil {
+		return nil, ErrChecksum
+	}
+
+	p, err := c.Codec.Decode(s[checksumLen:])
+	if err != nil {
+		return nil, err
+	}
+
+	if crc32.Checksum(p, castagnoli) != binary.BigEndian.Uint32(sum) {
+		return nil, ErrChecksum
+	}
+	return p, nil
+}
@@ -0,0 +1,75 @@
+package drfs_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+	"github.com/kaiserkarel/drfs/fake"
+)
+
+func TestChecksums(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	for _, codec := range []string{drfs.Raw, drfs.Base85, drfs.Densest} {
+		options := drfs.FileOptions{NumThreads: 3, Codec: codec, Checksums: true}
+		payload := binaryPayload(5*drfs.EffectiveReplySize + 11)
+		if codec == drfs.Raw {
+			payload = []byte(strings.Repeat("lorem ipsum ", len(payload)/12))
+		}
+
+		file := writeFile(t, server, options, payload)
+		assert.True(t, file.Index().Header.Checksums)
+		assertConsistent(t, file, len(payload))
+
+		got, err := ioutil.ReadAll(file)
+		require.NoError(t, err)
+		assert.Equal(t, payload, got, "%s: data should roundtrip", codec)
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	payload := binaryPayload(4 * drfs.EffectiveReplySize)
+	file := writeFile(t, server, drfs.FileOptions{NumThreads: 2, Codec: drfs.Base64, Checksums: true}, payload)
+
+	plain := writeFile(t, server, drfs.FileOptions{NumThreads: 2, Codec: drfs.Base64}, payload)
+	assert.Less(t, file.ReplySize(), plain.ReplySize())
+
+	// alter the data of the first reply of the second thread, keeping a valid encoding.
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+	client, err := service.Take(context.Background(), 1)
+	require.NoError(t, err)
+	thread := file.Index().Buckets[1]
+	list, err := client.RepliesService().List(thread.FileID, thread.CommentID).Fields("*").Do()
+	require.NoError(t, err)
+	reply := list.Replies[0]
+	content := []byte(reply.Content)
+	content[len(content)-2] = 'A'
+	if string(content) == reply.Content {
+		content[len(content)-2] = 'B'
+	}
+	_, err = client.RepliesService().Update(thread.FileID, thread.CommentID, reply.Id, &drive.Reply{Content: string(content)}).Do()
+	require.NoError(t, err)
+
+	_, err = io.Copy(ioutil.Discard, file)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, drfs.ErrChecksum))
+
+	var mismatch *drfs.ChecksumError
+	require.True(t, errors.As(err, &mismatch))
+	assert.Equal(t, 1, mismatch.Thread)
+	assert.Equal(t, reply.Id, mismatch.Reply)
+}
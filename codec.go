@@ -0,0 +1,206 @@
+package drfs
+
+import (
+	"encoding/ascii85"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Codec encodes the data stored in a reply into text which Drive stores without alteration. Drive stores reply
+// content as UTF-8, mangling invalid UTF-8 and control characters in binary data.
+type Codec interface {
+	// ID identifies the codec in the FileHeader.
+	ID() string
+
+	// EncodedLen returns the maximum number of characters in the encoding of n bytes.
+	EncodedLen(n int) int
+
+	Encode(p []byte) string
+	Decode(s string) ([]byte, error)
+}
+
+const (
+	// Raw stores data as is. Only use it for valid UTF-8 without control characters. Raw is the codec of files
+	// which do not record a codec.
+	Raw = "raw"
+
+	// Base64 stores data using standard base64 encoding, storing 3 bytes per 4 characters.
+	Base64 = "base64"
+
+	// Base85 stores data using ascii85 encoding, storing 4 bytes per 5 characters.
+	Base85 = "base85"
+)
+
+var codecs = struct {
+	sync.RWMutex
+	m map[string]Codec
+}{m: map[string]Codec{
+	Raw:    rawCodec{},
+	Base64: base64Codec{},
+	Base85: base85Codec{},
+}}
+
+// RegisterCodec makes a codec available to files by its ID. Registering a codec under an existing ID replaces it.
+func RegisterCodec(c Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.m[c.ID()] = c
+}
+
+// CodecByID returns the codec registered under the ID. The empty ID refers to the Raw codec.
+func CodecByID(id string) (Codec, error) {
+	if id == "" {
+		id = Raw
+	}
+
+	codecs.RLock()
+	defer codecs.RUnlock()
+	c, ok := codecs.m[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec: %s", id)
+	}
+	return c, nil
+}
+
+// Class is a class of characters, by the number of bytes and UTF-16 code units used to encode them. Drive might limit
+// the size of a reply in any of these.
+type Class int
+
+const (
+	// ASCII characters are encoded in a single byte.
+	ASCII Class = iota
+	// BMP characters from the Basic Multilingual Plane beyond U+0800 are encoded in 3 bytes, or 1 UTF-16 code unit.
+	BMP
+	// Supplementary characters beyond U+FFFF are encoded in 4 bytes, or 2 UTF-16 code units.
+	Supplementary
+)
+
+// ClassCodec is implemented by codecs encoding into characters of a Class other than ASCII. Codecs which do not
+// implement it are assumed to encode into ASCII.
+type ClassCodec interface {
+	Codec
+	Class() Class
+}
+
+// classOf returns the class of the characters a codec encodes into.
+func classOf(c Codec) Class {
+	if cc, ok := c.(ClassCodec); ok {
+		return cc.Class()
+	}
+	return ASCII
+}
+
+// Limits are the maximum number of characters of each class Drive accepts in a reply.
+type Limits map[Class]int
+
+// DefaultLimits assumes Drive limits replies to MaxReplySize bytes of UTF-8.
+var DefaultLimits = Limits{
+	ASCII:         MaxReplySize,
+	BMP:           MaxReplySize / 3,
+	Supplementary: MaxReplySize / 4,
+}
+
+// Capacity returns the number of bytes a codec stores per reply under the limits; the largest amount of data whose
+// encoding fits in a reply alongside the padding. The limits of a reply mixing classes are assumed to be linear.
+func (l Limits) Capacity(c Codec) int {
+	ascii, limit := l[ASCII], l[classOf(c)]
+	if ascii <= 2*len(padding) || limit <= 0 {
+		return 0
+	}
+
+	// 2 ASCII characters of padding and k characters of the codec fit if 2/ascii + k/limit <= 1.
+	k := limit * (ascii - 2*len(padding)) / ascii
+	return fit(c, k)
+}
+
+// fit returns the largest number of bytes of which the encoding takes at most k characters.
+func fit(c Codec, k int) int {
+	lower, upper := 0, 4*k
+	for lower < upper {
+		n := (lower + upper + 1) / 2
+		if c.EncodedLen(n) <= k {
+			lower = n
+		} else {
+			upper = n - 1
+		}
+	}
+	return lower
+}
+
+// replySize returns the number of bytes stored per reply, for files which do not record it.
+func replySize(c Codec) int {
+	return DefaultLimits.Capacity(c)
+}
+
+// ErrPaddingDamaged is returned when decoding a reply of which the padding is missing, as the content might have been
+// altered by Drive.
+var ErrPaddingDamaged = errors.New("reply padding damaged")
+
+// encodeReply frames the encoding of p in padding, as Drive removes leading and trailing spaces.
+func encodeReply(c Codec, p []byte) string {
+	return padding + c.Encode(p) + padding
+}
+
+// decodeReply returns the data framed in the content of a reply.
+func decodeReply(c Codec, content string) ([]byte, error) {
+	if len(content) < 2*len(padding) {
+		return nil, fmt.Errorf("reply content too short: %d", len(content))
+	}
+	if !strings.HasPrefix(content, padding) || !strings.HasSuffix(content, padding) {
+		return nil, ErrPaddingDamaged
+	}
+	return c.Decode(content[len(padding) : len(content)-len(padding)])
+}
+
+type rawCodec struct{}
+
+func (rawCodec) ID() string                      { return Raw }
+func (rawCodec) EncodedLen(n int) int            { return n }
+func (rawCodec) Encode(p []byte) string          { return string(p) }
+func (rawCodec) Decode(s string) ([]byte, error) { return []byte(s), nil }
+
+type base64Codec struct{}
+
+func (base64Codec) ID() string { return Base64 }
+
+func (base64Codec) EncodedLen(n int) int {
+	return base64.StdEncoding.EncodedLen(n)
+}
+
+func (base64Codec) Encode(p []byte) string {
+	return base64.StdEncoding.EncodeToString(p)
+}
+
+func (base64Codec) Decode(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(s)
+}
+
+type base85Codec struct{}
+
+func (base85Codec) ID() string { return Base85 }
+
+// EncodedLen is exact for data without groups of 4 zero bytes, which are shortened to a single character.
+func (base85Codec) EncodedLen(n int) int {
+	if n%4 == 0 {
+		return n / 4 * 5
+	}
+	return n/4*5 + n%4 + 1
+}
+
+func (base85Codec) Encode(p []byte) string {
+	dst := make([]byte, ascii85.MaxEncodedLen(len(p)))
+	return string(dst[:ascii85.Encode(dst, p)])
+}
+
+func (base85Codec) Decode(s string) ([]byte, error) {
+	// a group of 4 zero bytes is encoded as a single 'z', so the output may be 4 times larger than the input.
+	dst := make([]byte, 4*len(s))
+	n, _, err := ascii85.Decode(dst, []byte(s), true)
+	if err != nil {
+		return nil, err
+	}
+	return dst[:n], nil
+}
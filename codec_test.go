@@ -0,0 +1,156 @@
+package drfs_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+	"github.com/kaiserkarel/drfs/fake"
+)
+
+// binaryPayload returns data containing every byte value, and runs of zero bytes.
+func binaryPayload(n int) []byte {
+	payload := make([]byte, n)
+	for i := range payload {
+		if i%1000 > 8 {
+			payload[i] = byte(i * 7)
+		}
+	}
+	return payload
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, id := range []string{drfs.Raw, drfs.Base64, drfs.Base85, drfs.Base32768, drfs.Base131072} {
+		codec, err := drfs.CodecByID(id)
+		require.NoError(t, err)
+
+		for _, n := range []int{0, 1, 2, 3, 4, 5, 4094} {
+			p := binaryPayload(n)
+			encoded := codec.Encode(p)
+			if id != drfs.Raw {
+				assert.True(t, utf8.ValidString(encoded), "%s: encoding should be valid UTF-8", id)
+				assert.LessOrEqual(t, utf8.RuneCountInString(encoded), codec.EncodedLen(n), "%s: encoding of %d bytes exceeds EncodedLen", id, n)
+			}
+
+			decoded, err := codec.Decode(encoded)
+			require.NoError(t, err)
+			assert.Equal(t, p, append([]byte{}, decoded...), "%s: roundtrip of %d bytes", id, n)
+		}
+	}
+
+	_, err := drfs.CodecByID("unknown")
+	assert.Error(t, err)
+}
+
+func TestCodecBinaryData(t *testing.T) {
+	for id, replySize := range map[string]int{drfs.Base64: 3069, drfs.Base85: 3275} {
+		server := fake.NewServer()
+		service, err := fake.NewService(context.Background(), server)
+		require.NoError(t, err)
+
+		options := drfs.FileOptions{NumThreads: 3, Codec: id}
+		file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), options)
+		require.NoError(t, err)
+		assert.Equal(t, replySize, file.ReplySize(), "%s: reply size", id)
+
+		payload := binaryPayload(5*replySize + 17)
+		_, err = file.WriteCtx(context.Background(), payload[:100])
+		require.NoError(t, err)
+		_, err = file.WriteCtx(context.Background(), payload[100:])
+		require.NoError(t, err)
+
+		stat, err := file.Fstat()
+		require.NoError(t, err)
+
+		reopened, err := drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+		require.NoError(t, err)
+		assert.Equal(t, id, reopened.Index().Header.Codec)
+		assertConsistent(t, reopened, len(payload))
+
+		buf := make([]byte, len(payload))
+		_, err = io.ReadFull(reopened, buf)
+		require.NoError(t, err)
+		assert.Equal(t, payload, buf, "%s: binary data should roundtrip", id)
+
+		server.Close()
+	}
+}
+
+func TestCodecDensest(t *testing.T) {
+	utf16Count := func(s string) int { return len(utf16.Encode([]rune(s))) }
+
+	cases := []struct {
+		name      string
+		count     func(string) int
+		codec     string
+		replySize int
+	}{
+		{"bytes", nil, drfs.Base85, 3275},
+		{"utf16", utf16Count, drfs.Base32768, 7674},
+		{"runes", utf8.RuneCountInString, drfs.Base131072, 8697},
+	}
+
+	for _, c := range cases {
+		server := fake.NewServer()
+		server.Count = c.count
+		service, err := fake.NewService(context.Background(), server)
+		require.NoError(t, err)
+
+		options := drfs.FileOptions{NumThreads: 2, Codec: drfs.Densest}
+		file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), options)
+		require.NoError(t, err)
+		assert.Equal(t, c.codec, file.Index().Header.Codec, "%s: codec", c.name)
+		assert.Equal(t, c.replySize, file.ReplySize(), "%s: reply size", c.name)
+
+		payload := binaryPayload(3*file.ReplySize() + 17)
+		_, err = file.WriteCtx(context.Background(), payload[:100])
+		require.NoError(t, err)
+		_, err = file.WriteCtx(context.Background(), payload[100:])
+		require.NoError(t, err)
+
+		stat, err := file.Fstat()
+		require.NoError(t, err)
+
+		reopened, err := drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+		require.NoError(t, err)
+		assert.Equal(t, file.Index().Header, reopened.Index().Header, "%s: header", c.name)
+		assertConsistent(t, reopened, len(payload))
+
+		buf := make([]byte, len(payload))
+		_, err = io.ReadFull(reopened, buf)
+		require.NoError(t, err)
+		assert.Equal(t, payload, buf, "%s: data should roundtrip", c.name)
+
+		server.Close()
+	}
+}
+
+func TestCodecDensestProbeFails(t *testing.T) {
+	// the probe comment is the first comment created.
+	server := fake.NewServer()
+	defer server.Close()
+	injector := fake.NewInjector(server.Client().Transport, &fake.Rule{
+		Match: fake.Match(http.MethodPost, "comments"),
+		Fault: fake.BadRequest,
+		Calls: []int{1},
+	})
+	service, err := fake.NewServiceWithTransport(context.Background(), server, injector)
+	require.NoError(t, err)
+
+	_, err = drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{Codec: drfs.Densest})
+	require.Error(t, err)
+
+	client, err := service.Take(context.Background(), 1)
+	require.NoError(t, err)
+	list, err := client.FilesService().List().Do()
+	require.NoError(t, err)
+	assert.Empty(t, list.Files, "the Drive file of a failed create should be deleted")
+}
@@ -0,0 +1,263 @@
+package drfs
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressions drfs includes.
+const (
+	// Gzip compresses the content of files using gzip at the default level.
+	Gzip = "gzip"
+	// Zstd compresses the content of files using zstd at the default level.
+	Zstd = "zstd"
+)
+
+// Compression compresses the content of a file as a stream. Each Sync ends the stream, so files appended to after a
+// Sync hold a concatenation of streams, which the reader must read as one.
+type Compression interface {
+	// ID identifies the compression in the FileOptions.
+	ID() string
+
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.Reader, error)
+}
+
+var compressions = struct {
+	sync.RWMutex
+	m map[string]Compression
+}{m: map[string]Compression{
+	Gzip: gzipCompression{},
+	Zstd: zstdCompression{},
+}}
+
+// RegisterCompression makes a compression available to files by its ID, in addition to Gzip and Zstd.
+// Registering a compression under an existing ID replaces it.
+func RegisterCompression(c Compression) {
+	compressions.Lock()
+	defer compressions.Unlock()
+	compressions.m[c.ID()] = c
+}
+
+// CompressionByID returns the compression registered under the ID. The empty ID refers to no compression, for which
+// nil is returned.
+func CompressionByID(id string) (Compression, error) {
+	if id == "" {
+		return nil, nil
+	}
+
+	compressions.RLock()
+	defer compressions.RUnlock()
+	c, ok := compressions.m[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression: %s", id)
+	}
+	return c, nil
+}
+
+type gzipCompression struct{}
+
+func (gzipCompression) ID() string { return Gzip }
+
+func (gzipCompression) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipCompression) NewReader(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+type zstdCompression struct{}
+
+func (zstdCompression) ID() string { return Zstd }
+
+func (zstdCompression) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+}
+
+// NewReader decodes synchronously, so the decoder holds no goroutines, as readers are dropped without closing them.
+func (zstdCompression) NewReader(r io.Reader) (io.Reader, error) {
+	return zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+}
+
+// Sizes records the size of the content of a compressed file, and the size stored after compression, as of the last
+// Sync. If the stored size differs, data was written without a Sync, and the size of the content is unknown.
+type Sizes struct {
+	Stored  int64 `json:"s"`
+	Content int64 `json:"c"`
+}
+
+// storedWriter writes compressed data to the file, using the context of the write or Sync flushing it.
+type storedWriter struct {
+	f   *File
+	ctx context.Context
+}
+
+func (w *storedWriter) Write(p []byte) (int, error) {
+	n, err := w.f.writeStored(w.ctx, p)
+	w.f.contentAt = w.f.size()
+	return n, err
+}
+
+// storedReader reads the compressed data of the file sequentially, verifying its hash at the end. It uses the context
+// of the read decompressing it.
+type storedReader struct {
+	f   *File
+	ctx context.Context
+	off int64
+}
+
+func (r *storedReader) Read(p []byte) (int, error) {
+	if r.off >= r.f.size() {
+		return 0, r.f.verifyRead()
+	}
+	n, err := r.f.readBatchAt(r.ctx, p, r.off)
+	r.f.hashRead(p[:n], r.off)
+	r.off += int64(n)
+	return n, err
+}
+
+// decompressor reads the content of a compressed file sequentially from offset pos.
+type decompressor struct {
+	stored *storedReader
+	r      io.Reader
+	pos    int64
+}
+
+// writeCompressed compresses p. Compressed data is written once the compression flushes it, and by Sync.
+func (f *File) writeCompressed(ctx context.Context, p []byte) (int, error) {
+	if _, err := f.length(ctx); err != nil {
+		return 0, err
+	}
+	if f.compressor == nil {
+		f.stored = &storedWriter{f: f}
+		w, err := f.compression.NewWriter(f.stored)
+		if err != nil {
+			return 0, err
+		}
+		f.compressor = w
+	}
+	f.stored.ctx = ctx
+	n, err := f.compressor.Write(p)
+	f.content += int64(n)
+	f.decompressor = nil // the stored data changed.
+	return n, err
+}
+
+// flushCompressed ends the compressed stream, writing the remaining compressed data.
+func (f *File) flushCompressed(ctx context.Context) error {
+	if f.compressor == nil {
+		return nil
+	}
+	f.stored.ctx = ctx
+	err := f.compressor.Close()
+	f.compressor = nil
+	f.stored = nil
+	f.decompressor = nil
+	if err != nil {
+		return fmt.Errorf("compress: %w", err)
+	}
+	return nil
+}
+
+// readCompressedAt reads the content of a compressed file at offset off. Reads continue decompressing where the
+// previous read ended; reading any other offset decompresses the file from the start.
+func (f *File) readCompressedAt(ctx context.Context, p []byte, off int64) (int, error) {
+	if err := f.flushCompressed(ctx); err != nil {
+		return 0, err
+	}
+
+	f.checkContent()
+	if f.decompressor == nil || f.decompressor.pos > off {
+		if f.size() == 0 {
+			return 0, io.EOF
+		}
+		stored := &storedReader{f: f, ctx: ctx}
+		r, err := f.compression.NewReader(stored)
+		if err != nil {
+			return 0, fmt.Errorf("decompress: %w", err)
+		}
+		f.decompressor = &decompressor{stored: stored, r: r}
+	}
+
+	d := f.decompressor
+	d.stored.ctx = ctx
+	if d.pos < off {
+		skipped, err := io.CopyN(ioutil.Discard, d.r, off-d.pos)
+		d.pos += skipped
+		if err != nil {
+			return 0, decompressErr(err)
+		}
+	}
+
+	var n int
+	var err error
+	for n < len(p) && err == nil {
+		var m int
+		m, err = d.r.Read(p[n:])
+		n += m
+	}
+	d.pos += int64(n)
+	return n, decompressErr(err)
+}
+
+func decompressErr(err error) error {
+	if err == nil || err == io.EOF || errors.Is(err, ErrHashMismatch) {
+		return err
+	}
+	return fmt.Errorf("decompress: %w", err)
+}
+
+// length returns the size of the content of the file. The content of a compressed file which was written without a
+// Sync is decompressed to count its size.
+func (f *File) length(ctx context.Context) (int64, error) {
+	if f.compression == nil {
+		return f.size(), nil
+	}
+	f.checkContent()
+	if f.content >= 0 {
+		return f.content, nil
+	}
+
+	var count int64
+	if f.size() > 0 {
+		r, err := f.compression.NewReader(&storedReader{f: f, ctx: ctx})
+		if err != nil {
+			return 0, fmt.Errorf("decompress: %w", err)
+		}
+		count, err = io.Copy(ioutil.Discard, r)
+		if err != nil {
+			return 0, decompressErr(err)
+		}
+	}
+	f.content, f.contentAt = count, f.size()
+	return count, nil
+}
+
+// checkContent drops the size of the content and the stream of the last read if the stored size changed other than by
+// writing compressed data, such as by recovery.Trim rewriting the state of the threads.
+func (f *File) checkContent() {
+	if f.contentAt == f.size() {
+		return
+	}
+	f.content, f.contentAt = contentOf(f.index.Header, f.size()), f.size()
+	f.decompressor = nil
+}
+
+// contentOf returns the size of the content of a compressed file as recorded in the header, or -1 if unknown.
+func contentOf(header FileHeader, stored int64) int64 {
+	if stored == 0 {
+		return 0
+	}
+	if header.Sizes == nil || header.Sizes.Stored != stored {
+		return -1
+	}
+	return header.Sizes.Content
+}
@@ -0,0 +1,120 @@
+package drfs_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+	"github.com/kaiserkarel/drfs/fake"
+)
+
+func TestCompression(t *testing.T) {
+	for _, compression := range []string{drfs.Gzip, drfs.Zstd} {
+		t.Run(compression, func(t *testing.T) {
+			testCompression(t, compression)
+		})
+	}
+}
+
+func testCompression(t *testing.T, compression string) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+
+	file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: 2, Codec: drfs.Base85, Compression: compression})
+	require.NoError(t, err)
+
+	var payload = []byte(strings.Repeat("lorem ipsum dolor sit amet ", 2000))
+	for off := 0; off < len(payload); off += 5000 {
+		end := off + 5000
+		if end > len(payload) {
+			end = len(payload)
+		}
+		_, err = file.WriteCtx(context.Background(), payload[off:end])
+		require.NoError(t, err)
+	}
+	require.NoError(t, file.Close())
+
+	stat, err := file.Fstat()
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(payload)), stat.Size())
+	assert.Less(t, stat.StoredSize(), stat.Size())
+
+	reopened, err := drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+	require.NoError(t, err)
+	assert.Equal(t, compression, reopened.Index().Header.Compression)
+	got, err := ioutil.ReadAll(reopened)
+	require.NoError(t, err)
+	assert.Equal(t, payload, got)
+
+	buf := make([]byte, 40)
+	_, err = reopened.ReadAt(buf, 30000)
+	require.NoError(t, err)
+	assert.Equal(t, payload[30000:30040], buf)
+
+	end, err := reopened.Seek(0, io.SeekEnd)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(payload)), end)
+
+	// appending after a Sync starts a new stream, which is read as a continuation of the content.
+	_, err = reopened.WriteCtx(context.Background(), []byte("consectetur adipiscing elit"))
+	require.NoError(t, err)
+	require.NoError(t, reopened.Close())
+
+	reopened, err = drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+	require.NoError(t, err)
+	stat, err = reopened.Fstat()
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(payload)+27), stat.Size())
+	got, err = ioutil.ReadAll(reopened)
+	require.NoError(t, err)
+	assert.Equal(t, string(payload)+"consectetur adipiscing elit", string(got))
+}
+
+func TestCompressionContext(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+
+	file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: 2, Codec: drfs.Base85, Compression: drfs.Gzip})
+	require.NoError(t, err)
+
+	_, err = file.WriteCtx(context.Background(), []byte("lorem ipsum dolor sit amet"))
+	require.NoError(t, err)
+
+	// the compressed data is written by Sync, which should use its context.
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.True(t, errors.Is(file.SyncCtx(canceled), context.Canceled))
+
+	require.NoError(t, file.SyncCtx(context.Background()))
+	_, err = file.ReadAtCtx(canceled, make([]byte, 5), 0)
+	assert.True(t, errors.Is(err, context.Canceled), "reads should decompress using their context, got %v", err)
+
+	// writes large enough for the compression to flush write the compressed data using their context.
+	_, err = file.WriteCtx(canceled, randomPayload(1<<18))
+	assert.True(t, errors.Is(err, context.Canceled), "writes should use their context, got %v", err)
+}
+
+func TestCompressionUnknown(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+
+	_, err = drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: 2, Compression: "brotli"})
+	assert.Error(t, err)
+}
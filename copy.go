@@ -23,7 +23,7 @@ func Copy(src, dst string, service Service) error {
 	if err != nil {
 		return fmt.Errorf("unable to copy file to drive: %v", err)
 	}
-	return nil
+	return file.Close()
 }
 
 // // Download a file from drfs to a local file.
@@ -0,0 +1,150 @@
+package drfs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// Base32768 packs 15 bits into each character, using CJK ideographs and Hangul syllables from the Basic
+	// Multilingual Plane. It is experimental, and only denser than Base85 if Drive limits replies in characters
+	// rather than bytes.
+	Base32768 = "base32768"
+
+	// Base131072 packs 17 bits into each character, using CJK ideographs and private use characters outside the
+	// Basic Multilingual Plane. It is experimental, and only denser than Base32768 if Drive limits replies in code
+	// points rather than UTF-16 code units.
+	Base131072 = "base131072"
+)
+
+func init() {
+	RegisterCodec(&unicodeCodec{
+		id:    Base32768,
+		bits:  15,
+		class: BMP,
+		ranges: []runeRange{
+			{0x4E00, 0x9FFF},
+			{0x3400, 0x4DBF},
+			{0xAC00, 0xAC00 + 5183},
+		},
+	})
+	RegisterCodec(&unicodeCodec{
+		id:    Base131072,
+		bits:  17,
+		class: Supplementary,
+		ranges: []runeRange{
+			{0x20000, 0x2A6DF},
+			{0xF0000, 0xFFFFD},
+			{0x100000, 0x100000 + 22817},
+		},
+	})
+}
+
+// runeRange is an inclusive range of code points.
+type runeRange struct {
+	lo, hi rune
+}
+
+// unicodeCodec packs a fixed number of bits into every character, mapping each value onto the ranges of its alphabet.
+// The last group of bits is padded with zeros, followed by a marker 'A'+padding denoting the number of padding bits.
+type unicodeCodec struct {
+	id     string
+	bits   uint
+	class  Class
+	ranges []runeRange
+}
+
+func (c *unicodeCodec) ID() string { return c.id }
+
+func (c *unicodeCodec) Class() Class { return c.class }
+
+// EncodedLen returns the number of characters of the encoding of n bytes; including the marker.
+func (c *unicodeCodec) EncodedLen(n int) int {
+	bits := 8 * n
+	return (bits+int(c.bits)-1)/int(c.bits) + 1
+}
+
+func (c *unicodeCodec) Encode(p []byte) string {
+	var b strings.Builder
+	b.Grow(c.EncodedLen(len(p)) * utf8.UTFMax)
+
+	var acc uint64
+	var n uint
+	for _, x := range p {
+		acc = acc<<8 | uint64(x)
+		n += 8
+		for n >= c.bits {
+			n -= c.bits
+			b.WriteRune(c.rune(uint32(acc >> n & (1<<c.bits - 1))))
+		}
+	}
+
+	var pad uint
+	if n > 0 {
+		pad = c.bits - n
+		b.WriteRune(c.rune(uint32(acc << pad & (1<<c.bits - 1))))
+	}
+	b.WriteByte('A' + byte(pad))
+	return b.String()
+}
+
+func (c *unicodeCodec) Decode(s string) ([]byte, error) {
+	if s == "" {
+		return nil, errors.New("missing padding marker")
+	}
+
+	pad := uint(s[len(s)-1] - 'A')
+	if pad >= c.bits {
+		return nil, fmt.Errorf("invalid padding marker: %q", s[len(s)-1])
+	}
+	s = s[:len(s)-1]
+
+	p := make([]byte, 0, utf8.RuneCountInString(s)*int(c.bits)/8)
+	var acc uint64
+	var n uint
+	var count int
+	for _, r := range s {
+		v, err := c.value(r)
+		if err != nil {
+			return nil, err
+		}
+		acc = acc<<c.bits | uint64(v)
+		n += c.bits
+		count++
+		for n >= 8 {
+			n -= 8
+			p = append(p, byte(acc>>n))
+		}
+	}
+
+	// the padding bits may have completed a byte, which is not part of the data.
+	total := count*int(c.bits) - int(pad)
+	if total < 0 || total%8 != 0 {
+		return nil, fmt.Errorf("invalid padding: %d bits", pad)
+	}
+	return p[:total/8], nil
+}
+
+func (c *unicodeCodec) rune(v uint32) rune {
+	for _, r := range c.ranges {
+		size := uint32(r.hi - r.lo + 1)
+		if v < size {
+			return r.lo + rune(v)
+		}
+		v -= size
+	}
+	panic("value exceeds alphabet") // indicates a programming error within this library
+}
+
+func (c *unicodeCodec) value(x rune) (uint32, error) {
+	var offset uint32
+	for _, r := range c.ranges {
+		if x >= r.lo && x <= r.hi {
+			return offset + uint32(x-r.lo), nil
+		}
+		offset += uint32(r.hi - r.lo + 1)
+	}
+	return 0, fmt.Errorf("character %U is not part of the %s alphabet", x, c.id)
+}
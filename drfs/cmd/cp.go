@@ -0,0 +1,111 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	drfs "github.com/kaiserkarel/drfs/os"
+	"github.com/spf13/cobra"
+)
+
+// copyBufferSize is the number of bytes copied per write, spreading each write over many threads.
+const copyBufferSize = 8 << 20
+
+var copyForce bool
+
+// cpCmd represents the cp command
+var cpCmd = &cobra.Command{
+	Use:   "cp <source> <target>",
+	Short: "Copy a file in DRFS",
+	Long: `Copies a file in DRFS, into the target if that is a directory. Drive does not copy comments along with
+files, so the data is read from the source and written to the target, using the compression of the source. The
+target must not exist, unless --force is given. A target left incomplete by a failed copy is removed.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		cp(cmd, args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cpCmd)
+	cpCmd.Flags().BoolVarP(&copyForce, "force", "f", false, "overwrite the target if it exists")
+}
+
+func cp(cmd *cobra.Command, args []string) {
+	var source, target = args[0], intoDir(args[0], args[1])
+	if err := copyFile(source, target, copyForce); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot copy %s to %s: %s\n", source, target, err)
+		os.Exit(1)
+	}
+}
+
+// copyFile copies the file at source to target, overwriting an existing target if force is set. Copying a file onto
+// itself is refused, as truncating the target would destroy the source. The target is removed if the copy fails.
+func copyFile(source, target string, force bool) error {
+	src, err := drfs.Open(source)
+	if err != nil {
+		return err
+	}
+	info, err := src.Fstat()
+	if err != nil {
+		return err
+	}
+	if existing, err := drfs.Stat(target); err == nil && existing.ID() == info.ID() {
+		return fmt.Errorf("%s and %s are the same file", source, target)
+	}
+
+	drfs.Compression = src.Index().Header.Compression
+	dst, err := drfs.OpenFile(target, copyFlag(force), 0644)
+	if err != nil {
+		return err
+	}
+
+	var p = newProgress(target, info.Size())
+	_, err = io.CopyBuffer(io.MultiWriter(dst, p), src, make([]byte, copyBufferSize))
+	p.finish()
+	if err == nil {
+		err = dst.Close()
+	}
+	if err != nil {
+		if errRemove := drfs.Remove(target); errRemove != nil {
+			return fmt.Errorf("%w; removing %s failed: %s", err, target, errRemove)
+		}
+		return err
+	}
+	return nil
+}
+
+// copyFlag returns the flag cp opens the target with: it must not exist, unless it is overwritten by force.
+func copyFlag(force bool) int {
+	if force {
+		return drfs.O_WRONLY | drfs.O_CREATE | drfs.O_TRUNC
+	}
+	return drfs.O_WRONLY | drfs.O_CREATE | drfs.O_EXCL
+}
+
+// intoDir returns the path of source within target if target is a directory, as mv and cp do. Otherwise it returns
+// target.
+func intoDir(source, target string) string {
+	info, err := drfs.Stat(target)
+	if err != nil || !info.IsDir() {
+		return target
+	}
+	return path.Join(target, path.Base(source))
+}
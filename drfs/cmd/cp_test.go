@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs/fake"
+	drfs "github.com/kaiserkarel/drfs/os"
+)
+
+// useFake serves the namespace of package os from a new folder of an emulated Drive, with faults injected by the
+// rules.
+func useFake(t *testing.T, rules ...*fake.Rule) *fake.Server {
+	server := fake.NewServer()
+
+	injector := fake.NewInjector(server.Client().Transport, rules...)
+	service, err := fake.NewServiceWithTransport(context.Background(), server, injector)
+	require.NoError(t, err)
+	client, err := service.Take(context.Background(), 1)
+	require.NoError(t, err)
+	root, err := client.FilesService().Create(&drive.File{Name: t.Name(), MimeType: drfs.FolderMimeType}).Do()
+	require.NoError(t, err)
+
+	drfs.UseService(service)
+	drfs.Root = root.Id
+	return server
+}
+
+// writeFile creates the file holding the data.
+func writeFile(t *testing.T, name string, data string) {
+	f, err := drfs.Create(name)
+	require.NoError(t, err)
+	_, err = f.WriteString(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func readFile(t *testing.T, name string) string {
+	f, err := drfs.Open(name)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestIntoDir(t *testing.T) {
+	server := useFake(t)
+	defer server.Close()
+
+	require.NoError(t, drfs.Mkdir("dir", 0755))
+	writeFile(t, "file", "data")
+
+	assert.Equal(t, "dir/a", intoDir("a", "dir"))
+	assert.Equal(t, "dir/a", intoDir("sub/a", "dir/"))
+	assert.Equal(t, "file", intoDir("a", "file"), "a file is replaced")
+	assert.Equal(t, "missing", intoDir("a", "missing"))
+}
+
+func TestCopyFlag(t *testing.T) {
+	assert.Equal(t, drfs.O_WRONLY|drfs.O_CREATE|drfs.O_EXCL, copyFlag(false))
+	assert.Equal(t, drfs.O_WRONLY|drfs.O_CREATE|drfs.O_TRUNC, copyFlag(true))
+}
+
+func TestCopyFile(t *testing.T) {
+	var failing bool
+	server := useFake(t, &fake.Rule{
+		Match:       func(r *http.Request) bool { return failing && fake.Match(http.MethodPost, "replies")(r) },
+		Fault:       fake.BadRequest,
+		Probability: 1,
+	})
+	defer server.Close()
+
+	writeFile(t, "a", "hello")
+	require.NoError(t, copyFile("a", "b", false))
+	assert.Equal(t, "hello", readFile(t, "b"))
+
+	// the target must not exist, unless forced.
+	writeFile(t, "a", "bye")
+	assert.True(t, errors.Is(copyFile("a", "b", false), os.ErrExist))
+	assert.Equal(t, "hello", readFile(t, "b"))
+	require.NoError(t, copyFile("a", "b", true))
+	assert.Equal(t, "bye", readFile(t, "b"))
+
+	// copying a file onto itself would truncate it.
+	assert.Error(t, copyFile("a", "a", true))
+	require.NoError(t, drfs.Mkdir("dir", 0755))
+	require.NoError(t, drfs.Rename("a", "dir/a"))
+	assert.Error(t, copyFile("dir/a", intoDir("dir/a", "dir"), true))
+	assert.Equal(t, "bye", readFile(t, "dir/a"))
+
+	// a failed copy leaves no target behind.
+	failing = true
+	assert.Error(t, copyFile("dir/a", "c", false))
+	_, err := drfs.Stat("c")
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
@@ -16,9 +16,14 @@ limitations under the License.
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
+	"path/filepath"
+	"time"
 
 	drfs "github.com/kaiserkarel/drfs/os"
 	"github.com/spf13/cobra"
@@ -26,9 +31,14 @@ import (
 
 // downloadCmd represents the download command
 var downloadCmd = &cobra.Command{
-	Use:   "download",
-	Short: "Download a file from DRFS",
-	Long:  `Downloads a file from DRFS, piping the output to stdout`,
+	Use:   "download <name> [path]",
+	Short: "Download a file or directory from DRFS",
+	Long: `Downloads a file from DRFS, piping the output to stdout, or writing it to the path if given by -o or as
+an argument. Files are written to a temporary file first, which is renamed to the path once complete, so the path never
+holds a partial download. Directories are restored with everything they hold under the path, which defaults to the
+name of the directory. Local files with the same size and hash are skipped as unchanged. Several files are downloaded
+at once, see --jobs.`,
+	Args: cobra.RangeArgs(1, 2),
 	Run: func(cmd *cobra.Command, args []string) {
 		download(cmd, args)
 	},
@@ -36,19 +46,141 @@ var downloadCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(downloadCmd)
+	downloadCmd.Flags().IntVarP(&jobs, "jobs", "j", 4, "number of files to download at once")
+	downloadCmd.Flags().StringVarP(&output, "output", "o", "", "path to write to instead of stdout, or - for stdout")
 }
 
+var output string
+
 func download(cmd *cobra.Command, args []string) {
 	var fileName = args[0]
+	info, err := drfs.Stat(fileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot open %s: %s\n", fileName, err)
+		os.Exit(1)
+	}
+
+	var local = output
+	if local == "" && len(args) > 1 {
+		local = args[1]
+	}
+	switch {
+	case local == "-" && info.IsDir():
+		fmt.Fprintf(os.Stderr, "cannot download directory %s to stdout\n", fileName)
+		os.Exit(1)
+	case local == "-", local == "" && !info.IsDir():
+		toStdout(fileName)
+		return
+	case local == "":
+		local = filepath.FromSlash(path.Base("/" + fileName))
+	}
+
+	var transfers = []transfer{{src: fileName, dst: local}}
+	if stat, err := os.Stat(local); err == nil && stat.IsDir() && !info.IsDir() {
+		transfers[0].dst = filepath.Join(local, path.Base(fileName))
+	}
+	if info.IsDir() {
+		transfers, err = downloads(fileName, local)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cannot download %s: %s\n", fileName, err)
+			os.Exit(1)
+		}
+	}
+	if !transferAll("download", transfers, jobs, fetch) {
+		os.Exit(1)
+	}
+}
+
+func toStdout(fileName string) {
 	file, err := drfs.Open(fileName)
 	if err != nil {
-		fmt.Printf("cannot open %s: %s", fileName, err)
+		fmt.Fprintf(os.Stderr, "cannot open %s: %s\n", fileName, err)
 		os.Exit(1)
 	}
 
 	_, err = io.Copy(os.Stdout, file)
 	if err != nil {
-		fmt.Printf("cannot download %s: %s", fileName, err)
+		fmt.Fprintf(os.Stderr, "cannot download %s: %s\n", fileName, err)
 		os.Exit(1)
 	}
 }
+
+// downloads returns the files to download from the drfs directory remote to the local directory, creating the local
+// directories they are downloaded to.
+func downloads(remote, local string) ([]transfer, error) {
+	if err := os.MkdirAll(local, 0755); err != nil {
+		return nil, err
+	}
+	infos, err := drfs.ReadDir(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	var transfers []transfer
+	for _, info := range infos {
+		var src, dst = path.Join(remote, info.Name()), filepath.Join(local, info.Name())
+		if !info.IsDir() {
+			transfers = append(transfers, transfer{src: src, dst: dst})
+			continue
+		}
+		children, err := downloads(src, dst)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, children...)
+	}
+	return transfers, nil
+}
+
+// fetch downloads the drfs file, unless the local file holds the same content. The file is written to a temporary file
+// in the same directory, which replaces the local file once complete. The modification time of the drfs file is
+// preserved.
+func fetch(t transfer) (int64, bool, error) {
+	src, err := drfs.Open(t.src)
+	if err != nil {
+		return 0, false, err
+	}
+	info, err := src.Stat()
+	if err != nil {
+		return 0, false, err
+	}
+
+	same, err := unchanged(t.dst, src)
+	switch {
+	case same:
+		return 0, true, nil
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return 0, false, err
+	}
+
+	dst, err := ioutil.TempFile(filepath.Dir(t.dst), "."+filepath.Base(t.dst)+".*.tmp")
+	if err != nil {
+		return 0, false, err
+	}
+	n, err := writeTemp(dst, src, info.ModTime())
+	if err == nil {
+		err = os.Rename(dst.Name(), t.dst)
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+	}
+	return n, false, err
+}
+
+// writeTemp copies src to the temporary file, closing it, and sets its modification time and permissions to those of
+// a downloaded file.
+func writeTemp(tmp *os.File, src io.Reader, mtime time.Time) (int64, error) {
+	n, err := io.Copy(tmp, src)
+	if err != nil {
+		tmp.Close()
+		return n, err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return n, err
+	}
+	if err := tmp.Close(); err != nil {
+		return n, err
+	}
+	return n, os.Chtimes(tmp.Name(), mtime, mtime)
+}
@@ -0,0 +1,78 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	drfs "github.com/kaiserkarel/drfs/os"
+	"github.com/kaiserkarel/drfs/recovery"
+	"github.com/spf13/cobra"
+)
+
+var repair bool
+
+// fsckCmd represents the fsck command
+var fsckCmd = &cobra.Command{
+	Use:   "fsck <name>",
+	Short: "Check the integrity of a file in DRFS",
+	Long: `Checks the state of every thread of a file against its replies, printing each inconsistency found. With
+--repair, the state of each thread is rewritten from its replies, and lost or damaged threads of files with parity
+threads are rebuilt.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fsck(cmd, args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fsckCmd)
+	fsckCmd.Flags().BoolVar(&repair, "repair", false, "rewrite the state of each thread from its replies")
+}
+
+func fsck(cmd *cobra.Command, args []string) {
+	var fileName = args[0]
+	file, err := drfs.Lookup(fileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot open %s: %s\n", fileName, err)
+		os.Exit(1)
+	}
+
+	report, err := recovery.Check(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot check %s: %s\n", fileName, err)
+		os.Exit(1)
+	}
+
+	for _, problem := range report.Problems() {
+		fmt.Println(problem)
+	}
+	if report.OK() {
+		fmt.Printf("%s: ok\n", fileName)
+		return
+	}
+	if !repair {
+		os.Exit(1)
+	}
+
+	err = recovery.Repair(file, report)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot repair %s: %s\n", fileName, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s: repaired\n", fileName)
+}
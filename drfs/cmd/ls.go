@@ -0,0 +1,188 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kaiserkarel/drfs"
+	drfsos "github.com/kaiserkarel/drfs/os"
+	"github.com/spf13/cobra"
+)
+
+var listJSON, listLong bool
+
+// lsCmd represents the ls command
+var lsCmd = &cobra.Command{
+	Use:   "ls [pattern]",
+	Short: "List the files in DRFS",
+	Long: `Lists the drfs files of a directory, or those matching the pattern, with their size, number of threads,
+number of replies and modification time. Patterns use the syntax of path.Match, such as backups/*.tar. Drive files
+without a file header are not drfs files, and are left out.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ls(cmd, args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lsCmd)
+	lsCmd.Flags().BoolVar(&listJSON, "json", false, "print the listing as JSON")
+	lsCmd.Flags().BoolVarP(&listLong, "long", "l", false, "include the stats of every thread")
+}
+
+// listing describes a file or directory listed by ls.
+type listing struct {
+	Name    string          `json:"name"`
+	Dir     bool            `json:"dir,omitempty"`
+	Size    int64           `json:"size"`
+	Threads int             `json:"threads"`
+	Replies int64           `json:"replies"`
+	ModTime time.Time       `json:"modTime"`
+	Stats   []threadListing `json:"stats,omitempty"`
+}
+
+// threadListing describes a thread of a listed file, included with --long.
+type threadListing struct {
+	Number    int       `json:"number"`
+	CommentID string    `json:"commentID"`
+	Parity    bool      `json:"parity,omitempty"`
+	Lost      bool      `json:"lost,omitempty"`
+	Replies   int64     `json:"replies"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"modTime"`
+}
+
+func ls(cmd *cobra.Command, args []string) {
+	var pattern string
+	if len(args) > 0 {
+		pattern = args[0]
+	}
+
+	names, err := listNames(pattern)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot list %s: %s\n", pattern, err)
+		os.Exit(1)
+	}
+	if len(names) == 0 && pattern != "" {
+		fmt.Fprintf(os.Stderr, "cannot list %s: no such file or directory\n", pattern)
+		os.Exit(1)
+	}
+
+	var listings = make([]listing, 0, len(names))
+	for _, name := range names {
+		l, err := list(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cannot stat %s: %s\n", name, err)
+			os.Exit(1)
+		}
+		listings = append(listings, l)
+	}
+
+	if listJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(listings); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot encode listing: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SIZE\tTHREADS\tREPLIES\tMODIFIED\tNAME")
+	for _, l := range listings {
+		if l.Dir {
+			fmt.Fprintf(w, "-\t-\t-\t%s\t%s/\n", l.ModTime.Format(time.RFC3339), l.Name)
+			continue
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d\t%s\t%s\n", l.Size, l.Threads, l.Replies, l.ModTime.Format(time.RFC3339), l.Name)
+		for _, s := range l.Stats {
+			var kind = "bucket"
+			if s.Parity {
+				kind = "parity"
+			}
+			if s.Lost {
+				kind = "lost " + kind
+			}
+			fmt.Fprintf(w, "%d\t\t%d\t%s\t  %s %d (%s)\n", s.Size, s.Replies, s.ModTime.Format(time.RFC3339), kind, s.Number, s.CommentID)
+		}
+	}
+	w.Flush()
+}
+
+// listNames returns the paths to list: the entries of the directory named by the pattern, or of Root if it is empty,
+// or else the paths matching the pattern.
+func listNames(pattern string) ([]string, error) {
+	info, err := drfsos.Stat(pattern)
+	if err != nil || !info.IsDir() {
+		return drfsos.Glob(pattern)
+	}
+
+	infos, err := drfsos.ReadDir(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var names = make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = path.Join(pattern, info.Name())
+	}
+	return names, nil
+}
+
+func list(name string) (listing, error) {
+	info, err := drfsos.Stat(name)
+	if err != nil {
+		return listing{}, err
+	}
+	if info.IsDir() {
+		return listing{Name: name, Dir: true, ModTime: info.ModTime()}, nil
+	}
+
+	file, err := drfsos.Lookup(name)
+	if err != nil {
+		return listing{}, err
+	}
+
+	var l = listing{Name: name, Size: info.Size(), ModTime: info.ModTime()}
+	var add = func(thread *drfs.Thread, parity bool) {
+		l.Threads++
+		l.Replies += thread.Replies()
+		if listLong {
+			l.Stats = append(l.Stats, threadListing{
+				Number:    thread.Header.Number,
+				CommentID: thread.CommentID,
+				Parity:    parity,
+				Lost:      thread.Lost(),
+				Replies:   thread.Replies(),
+				Size:      thread.Size(),
+				ModTime:   thread.ModTime(),
+			})
+		}
+	}
+	for _, thread := range file.Index().Buckets {
+		add(thread, false)
+	}
+	for _, thread := range file.Index().Parity {
+		add(thread, true)
+	}
+	return l, nil
+}
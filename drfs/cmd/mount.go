@@ -0,0 +1,87 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/spf13/cobra"
+
+	"github.com/kaiserkarel/drfs/mount"
+)
+
+var readOnly bool
+
+// mountCmd represents the mount command
+var mountCmd = &cobra.Command{
+	Use:   "mount <mountpoint>",
+	Short: "Mount DRFS using FUSE",
+	Long: `Mounts DRFS at the mountpoint until interrupted. Directories are Drive folders and files are drfs
+		files. Writes are append-only: a file is either appended to or rewritten as a whole`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		mountFS(cmd, args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mountCmd)
+	mountCmd.Flags().BoolVar(&readOnly, "read-only", false, "mount the filesystem read-only")
+}
+
+func mountFS(cmd *cobra.Command, args []string) {
+	var mountpoint = args[0]
+	var options = []fuse.MountOption{fuse.FSName("drfs"), fuse.Subtype("drfs")}
+	if readOnly {
+		options = append(options, fuse.ReadOnly())
+	}
+
+	conn, err := fuse.Mount(mountpoint, options...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot mount %s: %s\n", mountpoint, err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		if err := fuse.Unmount(mountpoint); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot unmount %s: %s\n", mountpoint, err)
+		}
+	}()
+
+	err = fs.Serve(conn, mount.FS{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot serve %s: %s\n", mountpoint, err)
+		os.Exit(1)
+	}
+
+	<-conn.Ready
+	if conn.MountError != nil {
+		fmt.Fprintf(os.Stderr, "cannot mount %s: %s\n", mountpoint, conn.MountError)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,48 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	drfs "github.com/kaiserkarel/drfs/os"
+	"github.com/spf13/cobra"
+)
+
+// mvCmd represents the mv command
+var mvCmd = &cobra.Command{
+	Use:   "mv <source> <target>",
+	Short: "Move a file in DRFS",
+	Long: `Renames a file or directory in DRFS, moving it into the target if that is a directory. Only the Drive file
+is updated, so the replies holding the data stay intact. A file at the target is replaced.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		mv(cmd, args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mvCmd)
+}
+
+func mv(cmd *cobra.Command, args []string) {
+	var source, target = args[0], intoDir(args[0], args[1])
+	if err := drfs.Rename(source, target); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot move %s to %s: %s\n", source, target, err)
+		os.Exit(1)
+	}
+}
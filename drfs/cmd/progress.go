@@ -0,0 +1,77 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// progress reports the number of bytes written through it to stderr, at most once per second.
+type progress struct {
+	name  string
+	total int64 // the expected number of bytes, or -1 if unknown.
+	done  int64
+	last  time.Time
+	out   io.Writer
+}
+
+func newProgress(name string, total int64) *progress {
+	return &progress{name: name, total: total, last: time.Now(), out: os.Stderr}
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+	if time.Since(p.last) >= time.Second {
+		p.report()
+	}
+	return len(b), nil
+}
+
+// finish reports the final count, ending the line.
+func (p *progress) finish() {
+	p.report()
+	fmt.Fprintln(p.out)
+}
+
+func (p *progress) report() {
+	p.last = time.Now()
+	if p.total < 0 {
+		fmt.Fprintf(p.out, "\r%s: %s", p.name, byteSize(p.done))
+		return
+	}
+	var percent int64 = 100
+	if p.total > 0 {
+		percent = p.done * 100 / p.total
+	}
+	fmt.Fprintf(p.out, "\r%s: %s of %s (%d%%)", p.name, byteSize(p.done), byteSize(p.total), percent)
+}
+
+// byteSize formats n as a number of bytes using binary prefixes, such as 1.5 MiB.
+func byteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	var div, exp = int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
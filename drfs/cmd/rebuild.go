@@ -0,0 +1,64 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	drfs "github.com/kaiserkarel/drfs/os"
+	"github.com/kaiserkarel/drfs/recovery"
+	"github.com/spf13/cobra"
+)
+
+// rebuildCmd represents the rebuild command
+var rebuildCmd = &cobra.Command{
+	Use:   "rebuild <name> <thread>",
+	Short: "Rebuild a lost or damaged thread of a file in DRFS from parity",
+	Long: `Replaces a thread of a file created with parity threads by a new comment, of which the replies are
+reconstructed from the other threads. Use fsck to find lost or damaged threads; fsck --repair rebuilds every one.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		rebuild(cmd, args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rebuildCmd)
+}
+
+func rebuild(cmd *cobra.Command, args []string) {
+	var fileName = args[0]
+	number, err := strconv.Atoi(args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid thread %s: %s\n", args[1], err)
+		os.Exit(1)
+	}
+
+	file, err := drfs.Lookup(fileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot open %s: %s\n", fileName, err)
+		os.Exit(1)
+	}
+
+	err = recovery.RebuildThread(file, number)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot rebuild %s: %s\n", fileName, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s: rebuilt thread %d\n", fileName, number)
+}
@@ -0,0 +1,89 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	drfs "github.com/kaiserkarel/drfs/os"
+	"github.com/spf13/cobra"
+)
+
+var removeForce, recursive bool
+
+// rmCmd represents the rm command
+var rmCmd = &cobra.Command{
+	Use:   "rm <name>...",
+	Short: "Remove files from DRFS",
+	Long: `Removes files and empty directories from DRFS, after asking for confirmation unless --force is given. With
+--recursive, directories are removed with everything they hold.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		rm(cmd, args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rmCmd)
+	rmCmd.Flags().BoolVarP(&removeForce, "force", "f", false, "remove without asking for confirmation")
+	rmCmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "remove directories and their contents")
+}
+
+func rm(cmd *cobra.Command, args []string) {
+	var stdin = bufio.NewReader(os.Stdin)
+	for _, fileName := range args {
+		info, err := drfs.Stat(fileName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cannot remove %s: %s\n", fileName, err)
+			os.Exit(1)
+		}
+		if !removeForce && !confirm(stdin, fmt.Sprintf("remove %s?", describe(fileName, info.IsDir()))) {
+			continue
+		}
+
+		if info.IsDir() && recursive {
+			err = drfs.RemoveAll(fileName)
+		} else {
+			err = drfs.Remove(fileName)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cannot remove %s: %s\n", fileName, err)
+			os.Exit(1)
+		}
+	}
+}
+
+// confirm asks the question, returning whether it was answered with yes.
+func confirm(stdin *bufio.Reader, question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := stdin.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+func describe(fileName string, dir bool) string {
+	if dir {
+		return "directory " + fileName
+	}
+	return fileName
+}
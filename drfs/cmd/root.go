@@ -17,8 +17,12 @@ package cmd
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 
+	"github.com/kaiserkarel/drfs"
+	drfsos "github.com/kaiserkarel/drfs/os"
 	"github.com/spf13/cobra"
 
 	homedir "github.com/mitchellh/go-homedir"
@@ -26,6 +30,7 @@ import (
 )
 
 var cfgFile string
+var keyFile, ageIdentityFile, passphraseFile string
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -41,19 +46,22 @@ var rootCmd = &cobra.Command{
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
 
 func init() {
-	cobra.OnInitialize(initConfig)
+	cobra.OnInitialize(initConfig, initKey)
 
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.drfs.yaml)")
+	rootCmd.PersistentFlags().StringVar(&keyFile, "key-file", "", "file holding the key encrypting files (default is $"+drfsos.DRFS_KEY_FILE+")")
+	rootCmd.PersistentFlags().StringVar(&ageIdentityFile, "age-identity-file", "", "file holding the age identities encrypting files (default is $"+drfsos.DRFS_AGE_IDENTITY_FILE+")")
+	rootCmd.PersistentFlags().StringVar(&passphraseFile, "passphrase-file", "", "file holding the passphrase encrypting files (default is $"+drfsos.DRFS_PASSPHRASE+")")
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -65,7 +73,7 @@ func initConfig() {
 		// Find home directory.
 		home, err := homedir.Dir()
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
 
@@ -78,6 +86,33 @@ func initConfig() {
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+	}
+}
+
+// initKey sets the key encrypting files from the flags. Without flags, the environment is used.
+func initKey() {
+	switch {
+	case keyFile != "":
+		key, err := drfs.KeyFile(keyFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		drfsos.Key = key
+	case ageIdentityFile != "":
+		key, err := drfs.AgeIdentityFile(ageIdentityFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		drfsos.Key = key
+	case passphraseFile != "":
+		passphrase, err := ioutil.ReadFile(passphraseFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		drfsos.Key = drfs.Passphrase(strings.TrimRight(string(passphrase), "\r\n"))
 	}
 }
@@ -0,0 +1,153 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/kaiserkarel/drfs"
+	drfsos "github.com/kaiserkarel/drfs/os"
+)
+
+var jobs int
+
+// transfer is a file to copy from src to dst, between the local filesystem and DRFS.
+type transfer struct {
+	src, dst string
+}
+
+// transferFunc copies a file, returning the number of bytes copied, or whether the file was skipped as unchanged.
+type transferFunc func(t transfer) (n int64, skipped bool, err error)
+
+// transferAll runs the transfers using up to jobs at once, printing the outcome of each and a summary. Each job takes
+// clients from the same Service, so together they stay within its rate limit. It returns whether every transfer
+// succeeded.
+func transferAll(verb string, transfers []transfer, jobs int, fn transferFunc) bool {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	var (
+		mu                    sync.Mutex
+		wg                    sync.WaitGroup
+		done, skipped, failed int
+		total                 int64
+		queue                 = make(chan transfer)
+	)
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range queue {
+				n, skip, err := fn(t)
+
+				mu.Lock()
+				switch {
+				case err != nil:
+					failed++
+					fmt.Fprintf(os.Stderr, "cannot %s %s: %s\n", verb, t.src, err)
+				case skip:
+					skipped++
+					fmt.Fprintf(os.Stderr, "skipped %s: unchanged\n", t.dst)
+				default:
+					done++
+					total += n
+					fmt.Fprintf(os.Stderr, "%sed %s (%s)\n", verb, t.dst, byteSize(n))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, t := range transfers {
+		queue <- t
+	}
+	close(queue)
+	wg.Wait()
+
+	fmt.Fprintf(os.Stderr, "%sed %d files (%s), skipped %d unchanged, %d failed\n", verb, done, byteSize(total), skipped, failed)
+	return failed == 0
+}
+
+// remoteFile is a drfs file compared against a local file, opened by drfsos.Open or drfsos.Lookup.
+type remoteFile interface {
+	Fstat() (drfs.FileInfo, error)
+	Index() drfs.Index
+	Matches(r io.Reader) (bool, error)
+}
+
+// unchanged reports whether the local file holds the content of the drfs file. Files of a different size differ.
+// Otherwise the local file is compared using File.Matches, which hashes it the way the drfs file hashes its content.
+// Compressed files are read in full to compare, unless their modification time, which uploads preserve, differs.
+func unchanged(local string, file remoteFile) (bool, error) {
+	localInfo, err := os.Stat(local)
+	if err != nil {
+		return false, err
+	}
+	info, err := file.Fstat()
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case localInfo.Size() != info.Size():
+		return false, nil
+	case file.Index().Header.Compression != "" && !localInfo.ModTime().Equal(info.ModTime()):
+		return false, nil
+	}
+
+	f, err := os.Open(local)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return file.Matches(f)
+}
+
+// resumeFlag returns the flag to open the existing drfs file with to upload src. A file holding the start of src is
+// appended to, with src positioned after the part uploaded; other files are truncated, unless --resume refuses to or
+// src cannot be rewound.
+// With --restart, every file is truncated. Compressed files are never resumed, as their content is unreadable past
+// the last Sync, which is where an interrupted upload stopped.
+func resumeFlag(src *os.File, t transfer, existing *drfs.File) (int, error) {
+	if restart {
+		return drfsos.O_WRONLY | drfsos.O_TRUNC, nil
+	}
+
+	var ok bool
+	if existing.Index().Header.Compression == "" {
+		var err error
+		ok, err = existing.PrefixOf(src)
+		if err != nil {
+			return 0, err
+		}
+	}
+	var mismatch = fmt.Errorf("%s does not hold the start of the data uploaded; use --restart to replace it", t.dst)
+	switch {
+	case ok:
+		return drfsos.O_WRONLY | drfsos.O_APPEND, nil
+	case resume:
+		return 0, mismatch
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		// pipes, such as stdin, cannot be read again to replace the file.
+		return 0, mismatch
+	}
+	return drfsos.O_WRONLY | drfsos.O_TRUNC, nil
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kaiserkarel/drfs"
+	drfsos "github.com/kaiserkarel/drfs/os"
+)
+
+func TestUnchanged(t *testing.T) {
+	server := useFake(t)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "drfs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	keyPath := filepath.Join(dir, "key")
+	require.NoError(t, ioutil.WriteFile(keyPath, make([]byte, 32), 0600))
+	key, err := drfs.KeyFile(keyPath)
+	require.NoError(t, err)
+	defer func() { drfsos.Key, drfsos.Compression = nil, "" }()
+
+	for _, c := range []struct {
+		name        string
+		key         drfs.Key
+		compression string
+	}{
+		{name: "plain"},
+		{name: "encrypted", key: key},
+		{name: "compressed", compression: drfs.Gzip},
+	} {
+		drfsos.Key, drfsos.Compression = c.key, c.compression
+		writeFile(t, c.name, "hello world")
+		file, err := drfsos.Lookup(c.name)
+		require.NoError(t, err)
+		info, err := file.Fstat()
+		require.NoError(t, err)
+
+		// uploads preserve the modification time, which compressed files are compared by first.
+		local := filepath.Join(dir, c.name)
+		for content, want := range map[string]bool{"hello world": true, "hello there": false, "hello": false} {
+			require.NoError(t, ioutil.WriteFile(local, []byte(content), 0644))
+			require.NoError(t, os.Chtimes(local, info.ModTime(), info.ModTime()))
+			same, err := unchanged(local, file)
+			require.NoError(t, err)
+			assert.Equal(t, want, same, "%s: %s", c.name, content)
+		}
+	}
+}
+
+func TestResumeFlag(t *testing.T) {
+	server := useFake(t)
+	defer server.Close()
+	defer func() { resume, restart = false, false }()
+
+	dir, err := ioutil.TempDir("", "drfs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeFile(t, "file", "hello")
+	existing, err := drfsos.Lookup("file")
+	require.NoError(t, err)
+	var tr = transfer{src: filepath.Join(dir, "file"), dst: "file"}
+
+	// open returns the local file holding the data, and the offset resumeFlag left it at.
+	var open = func(data string) *os.File {
+		require.NoError(t, ioutil.WriteFile(tr.src, []byte(data), 0644))
+		src, err := os.Open(tr.src)
+		require.NoError(t, err)
+		return src
+	}
+	var offset = func(src *os.File) int64 {
+		off, err := src.Seek(0, io.SeekCurrent)
+		require.NoError(t, err)
+		return off
+	}
+
+	src := open("hello world")
+	flag, err := resumeFlag(src, tr, existing)
+	require.NoError(t, err)
+	assert.Equal(t, drfsos.O_WRONLY|drfsos.O_APPEND, flag, "the upload is resumed")
+	assert.Equal(t, int64(len("hello")), offset(src))
+	src.Close()
+
+	src = open("jello world")
+	flag, err = resumeFlag(src, tr, existing)
+	require.NoError(t, err)
+	assert.Equal(t, drfsos.O_WRONLY|drfsos.O_TRUNC, flag, "a file which differs is replaced")
+	assert.Equal(t, int64(0), offset(src))
+	src.Close()
+
+	resume = true
+	src = open("jello world")
+	_, err = resumeFlag(src, tr, existing)
+	assert.Error(t, err, "--resume refuses to replace a file which differs")
+	src.Close()
+
+	resume, restart = false, true
+	src = open("hello world")
+	flag, err = resumeFlag(src, tr, existing)
+	require.NoError(t, err)
+	assert.Equal(t, drfsos.O_WRONLY|drfsos.O_TRUNC, flag, "--restart replaces every file")
+	assert.Equal(t, int64(0), offset(src))
+	src.Close()
+}
+
+func TestTransferAll(t *testing.T) {
+	var transfers = []transfer{{src: "a", dst: "a"}, {src: "b", dst: "b"}, {src: "c", dst: "c"}, {src: "d", dst: "d"}}
+
+	var mu sync.Mutex
+	var seen = make(map[string]bool)
+	var fn = func(t transfer) (int64, bool, error) {
+		mu.Lock()
+		seen[t.src] = true
+		mu.Unlock()
+		switch t.src {
+		case "b":
+			return 0, true, nil
+		case "c":
+			return 0, false, errors.New("failed")
+		}
+		return 10, false, nil
+	}
+
+	assert.False(t, transferAll("copy", transfers, 3, fn), "a transfer failed")
+	assert.Len(t, seen, len(transfers))
+
+	seen = make(map[string]bool)
+	assert.True(t, transferAll("copy", transfers[:2], 0, fn))
+	assert.Len(t, seen, 2)
+}
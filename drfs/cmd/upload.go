@@ -16,9 +16,13 @@ limitations under the License.
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path"
+	"path/filepath"
+	"syscall"
 
 	drfs "github.com/kaiserkarel/drfs/os"
 
@@ -27,10 +31,15 @@ import (
 
 // backupCmd represents the backup command
 var uploadCmd = &cobra.Command{
-	Use:   "upload",
-	Short: "Upload up a file to DRFS",
-	Long: `Upload files in DRFS. If the file name already exists; backup throws an error (DRFS cannot update 
-		files at the moment`,
+	Use:   "upload <path> [name]",
+	Short: "Upload up a file or directory to DRFS",
+	Long: `Upload files in DRFS, named by their local path unless a name is given. Directories are uploaded with
+everything they hold, keeping the paths relative to the directory. Files which exist in DRFS with the same size and
+hash are skipped as unchanged. Interrupted uploads are resumed: if a file in DRFS holds the start of the local file,
+only the remainder is uploaded. Other files are replaced, unless --resume is given. Several files are uploaded at
+once, see --jobs. A path of - uploads stdin to the file named by --name, such as: tar c dir | drfs upload - --name
+dir.tar`,
+	Args: cobra.RangeArgs(1, 2),
 	Run: func(cmd *cobra.Command, args []string) {
 		backup(cmd, args)
 	},
@@ -38,28 +47,148 @@ var uploadCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(uploadCmd)
+	uploadCmd.Flags().StringVar(&drfs.Compression, "compress", "", "compress the file using the compression, gzip or zstd")
+	uploadCmd.Flags().IntVarP(&jobs, "jobs", "j", 4, "number of files to upload at once")
+	uploadCmd.Flags().BoolVar(&resume, "resume", false, "only resume interrupted uploads, refusing to replace files which differ")
+	uploadCmd.Flags().BoolVar(&restart, "restart", false, "replace files which differ from the start, instead of resuming them")
+	uploadCmd.Flags().StringVar(&uploadName, "name", "", "name of the file in DRFS, required when uploading stdin")
 }
 
+// syncInterval is the number of bytes uploaded between syncs, which record the hash used to resume uploads.
+const syncInterval = 64 << 20
+
+var resume, restart bool
+var uploadName string
+
 func backup(cmd *cobra.Command, args []string) {
-	var fileName = args[0]
-	src, err := os.Open(fileName)
-	if err != nil {
-		fmt.Printf("cannot open local file: %s", err)
+	if resume && restart {
+		fmt.Fprintln(os.Stderr, "--resume and --restart are mutually exclusive")
 		os.Exit(1)
 	}
 
-	fmt.Println("creating drfs file")
-	dst, err := drfs.Open(fileName)
-	if err != nil {
-		fmt.Printf("cannot open drfs file: %s", err)
+	var local = args[0]
+	var remote = filepath.ToSlash(local)
+	if len(args) > 1 {
+		remote = args[1]
+	}
+	if uploadName != "" {
+		remote = uploadName
+	}
+	if remote == "-" {
+		fmt.Fprintln(os.Stderr, "uploading stdin requires --name")
 		os.Exit(1)
 	}
 
-	fmt.Println("starting transfer")
-	_, err = io.Copy(dst, src)
+	transfers, err := uploads(local, remote)
 	if err != nil {
-		fmt.Printf("cannot copy %s to drfs: %s", fileName, err)
+		fmt.Fprintf(os.Stderr, "cannot upload %s: %s\n", local, err)
+		os.Exit(1)
+	}
+	if !transferAll("upload", transfers, jobs, upload) {
 		os.Exit(1)
 	}
-	fmt.Println("upload complete")
+}
+
+// uploads returns the files to upload to the drfs path remote from the local file or directory, creating the drfs
+// directories they are uploaded to. Files which are not regular files, such as symbolic links, are left out. A local
+// path of - is stdin.
+func uploads(local, remote string) ([]transfer, error) {
+	if local == "-" {
+		return []transfer{{src: local, dst: remote}}, drfs.MkdirAll(path.Dir(remote), 0755)
+	}
+	info, err := os.Stat(local)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []transfer{{src: local, dst: remote}}, drfs.MkdirAll(path.Dir(remote), 0755)
+	}
+
+	// directories are created before uploading, as uploading files concurrently would create duplicate folders.
+	var transfers []transfer
+	err = filepath.Walk(local, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(local, name)
+		if err != nil {
+			return err
+		}
+		var dst = path.Join(remote, filepath.ToSlash(rel))
+
+		switch {
+		case info.IsDir():
+			return drfs.MkdirAll(dst, 0755)
+		case info.Mode().IsRegular():
+			transfers = append(transfers, transfer{src: name, dst: dst})
+		}
+		return nil
+	})
+	return transfers, err
+}
+
+// upload uploads the local file, unless the drfs file holds the same content. The modification time of the local file
+// is preserved. Stdin is always uploaded, resuming only if the drfs file holds the start of the data read.
+func upload(t transfer) (int64, bool, error) {
+	var src = os.Stdin
+	if t.src != "-" {
+		f, err := os.Open(t.src)
+		if err != nil {
+			return 0, false, err
+		}
+		defer f.Close()
+		src = f
+	}
+
+	var flag = drfs.O_WRONLY | drfs.O_CREATE | drfs.O_EXCL
+	existing, err := drfs.Lookup(t.dst)
+	switch {
+	case err == nil:
+		if src != os.Stdin {
+			same, err := unchanged(t.src, existing)
+			if err != nil || same {
+				return 0, same, err
+			}
+		}
+		flag, err = resumeFlag(src, t, existing)
+		if err != nil {
+			return 0, false, err
+		}
+	case errors.Is(err, syscall.EISDIR):
+		return 0, false, fmt.Errorf("%s is a directory in drfs", t.dst)
+	case !errors.Is(err, os.ErrNotExist):
+		return 0, false, err
+	}
+
+	dst, err := drfs.OpenFile(t.dst, flag, 0644)
+	if err != nil {
+		return 0, false, err
+	}
+	var n int64
+	for {
+		// syncing regularly records how much was uploaded, should the upload be interrupted.
+		written, err := io.CopyN(dst, src, syncInterval)
+		n += written
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, false, err
+		}
+		if err := dst.Sync(); err != nil {
+			return n, false, err
+		}
+	}
+	if err := dst.Close(); err != nil {
+		return n, false, err
+	}
+	if src == os.Stdin {
+		return n, false, nil
+	}
+
+	info, err := src.Stat()
+	if err != nil {
+		return n, false, err
+	}
+	return n, false, drfs.Chtimes(t.dst, info.ModTime(), info.ModTime())
 }
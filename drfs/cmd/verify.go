@@ -0,0 +1,69 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	drfs "github.com/kaiserkarel/drfs/os"
+	"github.com/spf13/cobra"
+)
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify <name>",
+	Short: "Verify the content of a file in DRFS",
+	Long: `Reads a file from DRFS, verifying its content against the SHA-256 recorded when it was uploaded. The content
+is discarded.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		verify(cmd, args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+}
+
+func verify(cmd *cobra.Command, args []string) {
+	var fileName = args[0]
+	file, err := drfs.Lookup(fileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot open %s: %s\n", fileName, err)
+		os.Exit(1)
+	}
+
+	stat, err := file.Fstat()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot stat %s: %s\n", fileName, err)
+		os.Exit(1)
+	}
+	if stat.Hash() == nil {
+		fmt.Fprintf(os.Stderr, "%s holds no hash of its content\n", fileName)
+		os.Exit(1)
+	}
+
+	_, err = io.Copy(ioutil.Discard, file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot verify %s: %s\n", fileName, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s: ok %s\n", fileName, hex.EncodeToString(stat.Hash()))
+}
@@ -16,7 +16,7 @@ import (
 func TestAppendWrites(t *testing.T) {
 	var fileName = fmt.Sprintf("TestAppendWrites%s", time.Now().String())
 
-	file, err := drfs.Open(fileName)
+	file, err := drfs.OpenFile(fileName, drfs.O_RDWR|drfs.O_CREATE, 0644)
 	require.NoError(t, err, "cannot open drfs file")
 
 	var payload = "hello payload!"
@@ -27,7 +27,9 @@ func TestAppendWrites(t *testing.T) {
 
 	stat, err := file.Stat()
 	require.NoError(t, err, "should stat")
-	rStat, err := recovery.Stats(file)
+	raw, err := drfs.Lookup(fileName)
+	require.NoError(t, err, "should lookup")
+	rStat, err := recovery.Stats(raw)
 	require.NoError(t, err, "should recovery.Stat")
 
 	if !assert.Equal(t, int(rStat.Size()), 10*len(payload)) ||
@@ -14,7 +14,10 @@ import (
 )
 
 func TestBufferedWriter(t *testing.T) {
-	dest, err := dros.Open(fmt.Sprintf("TestBufferedWriter_%s", time.Now().String()))
+	var fileName = fmt.Sprintf("TestBufferedWriter_%s", time.Now().String())
+	_, err := dros.Create(fileName)
+	require.NoError(t, err)
+	dest, err := dros.Lookup(fileName)
 	require.NoError(t, err)
 
 	src, err := os.Open("../testdata/lorem.txt")
@@ -51,7 +51,7 @@ func TestReopeningResultsInSameFileLorem(t *testing.T) {
 func reopenE2E(t *testing.T, src string) {
 	var fileName = fmt.Sprintf("TestReopeningResultsInSameIndex_%s", time.Now().String())
 
-	file, err := drfs.Open(fileName)
+	file, err := drfs.OpenFile(fileName, drfs.O_RDWR|drfs.O_CREATE, 0644)
 	require.NoError(t, err, "cannot open drfs file")
 
 	fstat, err := file.Fstat()
@@ -71,7 +71,7 @@ func reopenE2E(t *testing.T, src string) {
 	require.NoError(t, err, "second fstat should work")
 	assert.LessOrEqual(t, fstat.QuotaBytesUsed(), int64(0), "quota should remain 0 after writes")
 
-	file2, err := drfs.Open(fileName)
+	file2, err := drfs.OpenFile(fileName, drfs.O_RDWR|drfs.O_CREATE, 0644)
 	require.NoError(t, err, "cannot reopen drfs file")
 
 	assert.Equal(t, file2.Index().Header, file.Index().Header, "index headers should be equal")
@@ -92,7 +92,9 @@ func reopenE2E(t *testing.T, src string) {
 	assert.Equal(t, stat2.Size(), stat1.Size(), "file.Size should be equal")
 
 	// check if local size bookkeeping matches recovery
-	rStats, err := recovery.Stats(file)
+	raw, err := drfs.Lookup(fileName)
+	require.NoError(t, err, "cannot lookup drfs file")
+	rStats, err := recovery.Stats(raw)
 	require.NoError(t, err, "recovery stats should work")
 
 	assert.Equal(t, rStats.Size(), stat2.Size(), "index size should match recovery size")
@@ -0,0 +1,337 @@
+package drfs
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"filippo.io/age"
+)
+
+// AES256GCM is the cipher of encrypted files: AES-256 in Galois/Counter Mode.
+const AES256GCM = "aes-256-gcm"
+
+// Methods of deriving the key wrapping the data key of a file.
+const (
+	methodPassphrase = "pbkdf2-sha256"
+	methodKeyFile    = "hmac-sha256"
+	methodAge        = "age"
+)
+
+// DefaultIterations is the number of PBKDF2 iterations used to derive a key from a passphrase.
+const DefaultIterations = 600000
+
+var (
+	// ErrNoKey is returned when opening an encrypted file without a Key.
+	ErrNoKey = errors.New("file is encrypted; a key is required")
+
+	// ErrWrongKey is returned when the Key of an encrypted file does not unwrap its data key.
+	ErrWrongKey = errors.New("key does not match the file")
+
+	// ErrDecrypt is returned when a reply of an encrypted file fails authentication, as it was altered or moved.
+	ErrDecrypt = errors.New("reply cannot be decrypted")
+)
+
+// Encryption describes how the data of a file is encrypted. Each file has a random data key, which is stored wrapped
+// by the Key of the file alongside the parameters used to derive the wrapping key.
+type Encryption struct {
+	Cipher     string `json:"c"`
+	Method     string `json:"m"`           // how the wrapping key is derived from the Key.
+	Salt       []byte `json:"s,omitempty"` // salt of the derivation.
+	Iterations int    `json:"i,omitempty"` // iterations of the derivation, for passphrases.
+	WrappedKey []byte `json:"w"`
+}
+
+// Key protects the data key of encrypted files. Passphrase, KeyFile, Age and AgeIdentityFile return the keys drfs
+// supports; other schemes implement Key using a Method of their own.
+type Key interface {
+	// Wrap seals the data key of a new file, returning the Encryption to store in its FileHeader.
+	Wrap(dataKey []byte) (*Encryption, error)
+
+	// Unwrap returns the data key of a file. It returns ErrWrongKey if the Key does not match the file.
+	Unwrap(e *Encryption) ([]byte, error)
+}
+
+// Passphrase returns a Key deriving the wrapping key from the passphrase using PBKDF2-HMAC-SHA256.
+func Passphrase(passphrase string) Key {
+	return &passphraseKey{passphrase: []byte(passphrase), iterations: DefaultIterations}
+}
+
+// KeyFile returns a Key reading its secret from the file at path, which should hold at least 32 random bytes.
+func KeyFile(path string) (Key, error) {
+	secret, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("key file %s holds %d bytes; at least 32 are required", path, len(secret))
+	}
+	return &keyFileKey{secret: secret}, nil
+}
+
+// Age returns a Key encrypting the data key of new files to the age recipients, and decrypting it using the identities.
+// A Key without recipients only opens files, while one without identities only creates them.
+func Age(identities []age.Identity, recipients []age.Recipient) Key {
+	return &ageKey{identities: identities, recipients: recipients}
+}
+
+// AgeIdentityFile returns a Key reading age identities from the file at path, as generated by age-keygen. New files are
+// encrypted to the recipients of the identities.
+func AgeIdentityFile(path string) (Key, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read age identity file: %w", err)
+	}
+	defer f.Close()
+
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("parse age identity file %s: %w", path, err)
+	}
+	var recipients []age.Recipient
+	for _, identity := range identities {
+		if x, ok := identity.(*age.X25519Identity); ok {
+			recipients = append(recipients, x.Recipient())
+		}
+	}
+	return Age(identities, recipients), nil
+}
+
+type passphraseKey struct {
+	passphrase []byte
+	iterations int
+}
+
+func (k *passphraseKey) Wrap(dataKey []byte) (*Encryption, error) {
+	salt, err := randomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	e := &Encryption{Method: methodPassphrase, Salt: salt, Iterations: k.iterations}
+	e.WrappedKey, err = wrapKey(stretch(k.passphrase, salt, k.iterations), dataKey, e.Method)
+	return e, err
+}
+
+func (k *passphraseKey) Unwrap(e *Encryption) ([]byte, error) {
+	if e.Method != methodPassphrase {
+		return nil, fmt.Errorf("%w: file key is derived using %s", ErrWrongKey, e.Method)
+	}
+	return unwrapKey(stretch(k.passphrase, e.Salt, e.Iterations), e.WrappedKey, e.Method)
+}
+
+type keyFileKey struct {
+	secret []byte
+}
+
+func (k *keyFileKey) Wrap(dataKey []byte) (*Encryption, error) {
+	salt, err := randomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	e := &Encryption{Method: methodKeyFile, Salt: salt}
+	e.WrappedKey, err = wrapKey(derive(salt, k.secret), dataKey, e.Method)
+	return e, err
+}
+
+func (k *keyFileKey) Unwrap(e *Encryption) ([]byte, error) {
+	if e.Method != methodKeyFile {
+		return nil, fmt.Errorf("%w: file key is derived using %s", ErrWrongKey, e.Method)
+	}
+	return unwrapKey(derive(e.Salt, k.secret), e.WrappedKey, e.Method)
+}
+
+type ageKey struct {
+	identities []age.Identity
+	recipients []age.Recipient
+}
+
+func (k *ageKey) Wrap(dataKey []byte) (*Encryption, error) {
+	if len(k.recipients) == 0 {
+		return nil, errors.New("age key has no recipients")
+	}
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, k.recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(dataKey); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return &Encryption{Method: methodAge, WrappedKey: buf.Bytes()}, nil
+}
+
+func (k *ageKey) Unwrap(e *Encryption) ([]byte, error) {
+	if e.Method != methodAge {
+		return nil, fmt.Errorf("%w: file key is derived using %s", ErrWrongKey, e.Method)
+	}
+	if len(k.identities) == 0 {
+		return nil, fmt.Errorf("%w: age key has no identities", ErrWrongKey)
+	}
+	r, err := age.Decrypt(bytes.NewReader(e.WrappedKey), k.identities...)
+	var noMatch *age.NoIdentityMatchError
+	switch {
+	case errors.As(err, &noMatch):
+		return nil, ErrWrongKey
+	case err != nil:
+		return nil, fmt.Errorf("unwrap age data key: %w", err)
+	}
+	return ioutil.ReadAll(r)
+}
+
+func randomBytes(n int) ([]byte, error) {
+	p := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, p); err != nil {
+		return nil, fmt.Errorf("read random: %w", err)
+	}
+	return p, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// wrapKey seals the data key using the wrapping key, prefixed by a random nonce. The method is authenticated as well.
+func wrapKey(kek, dataKey []byte, method string) ([]byte, error) {
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomBytes(aead.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(method)), nil
+}
+
+func unwrapKey(kek, wrapped []byte, method string) ([]byte, error) {
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, ErrWrongKey
+	}
+	dataKey, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(method))
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+	return dataKey, nil
+}
+
+// newEncryption generates the data key of a new file, wrapped by the key.
+func newEncryption(key Key) (*Encryption, []byte, error) {
+	dataKey, err := randomBytes(32)
+	if err != nil {
+		return nil, nil, err
+	}
+	e, err := key.Wrap(dataKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("wrap data key: %w", err)
+	}
+	e.Cipher = AES256GCM
+	return e, dataKey, nil
+}
+
+// dataKey returns the data key of the file, unwrapped by the key.
+func (e *Encryption) dataKey(key Key) ([]byte, error) {
+	if key == nil {
+		return nil, ErrNoKey
+	}
+	if e.Cipher != AES256GCM {
+		return nil, fmt.Errorf("unsupported cipher: %s", e.Cipher)
+	}
+	return key.Unwrap(e)
+}
+
+// sealRandomLen is the number of random bytes in the nonce of a reply, which are stored in front of its ciphertext.
+const sealRandomLen = 6
+
+// sealOverhead is the number of bytes a reply grows by when encrypted.
+const sealOverhead = sealRandomLen + 16
+
+// sealer encrypts the data of replies using the data key of a file. The nonce of a reply consists of the number of the
+// thread, the index of the reply within the thread and random bytes. The position binds a reply to its place in the
+// stripe, so replies cannot be moved unnoticed; the random bytes differ each time a reply is rewritten, as appending to
+// the tail of a thread encrypts it anew.
+type sealer struct {
+	aead cipher.AEAD
+}
+
+func newSealer(dataKey []byte) (*sealer, error) {
+	aead, err := newGCM(derive(dataKey, []byte("drfs reply")))
+	if err != nil {
+		return nil, err
+	}
+	return &sealer{aead: aead}, nil
+}
+
+func (s *sealer) nonce(thread int, i int64, random []byte) []byte {
+	var nonce = make([]byte, 0, s.aead.NonceSize())
+	nonce = append(nonce, byte(thread>>8), byte(thread))
+	nonce = append(nonce, byte(i>>24), byte(i>>16), byte(i>>8), byte(i))
+	return append(nonce, random...)
+}
+
+// seal encrypts the data of reply i of the thread.
+func (s *sealer) seal(thread int, i int64, p []byte) []byte {
+	random, err := randomBytes(sealRandomLen)
+	if err != nil {
+		panic(err) // the system random source failing is not recoverable.
+	}
+	return s.aead.Seal(random, s.nonce(thread, i, random), p, nil)
+}
+
+// open decrypts the data of reply i of the thread.
+func (s *sealer) open(thread int, i int64, c []byte) ([]byte, error) {
+	if len(c) < sealRandomLen {
+		return nil, ErrDecrypt
+	}
+	p, err := s.aead.Open(nil, s.nonce(thread, i, c[:sealRandomLen]), c[sealRandomLen:], nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return p, nil
+}
+
+// sealedCodec encrypts data before encoding it using the wrapped codec, for reply i of a thread of an encrypted file.
+type sealedCodec struct {
+	Codec
+	sealer *sealer
+	thread int
+	index  int64
+}
+
+func (c sealedCodec) EncodedLen(n int) int {
+	return c.Codec.EncodedLen(n + sealOverhead)
+}
+
+func (c sealedCodec) Encode(p []byte) string {
+	return c.Codec.Encode(c.sealer.seal(c.thread, c.index, p))
+}
+
+func (c sealedCodec) Decode(s string) ([]byte, error) {
+	p, err := c.Codec.Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	return c.sealer.open(c.thread, c.index, p)
+}
+
+// hashKey returns the key prefixed to the content of an encrypted file before hashing, so the FileHash stored in the
+// FileHeader reveals nothing of the content.
+func hashKey(dataKey []byte) []byte {
+	return derive(dataKey, []byte("drfs hash"))
+}
@@ -0,0 +1,191 @@
+package drfs_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+	"github.com/kaiserkarel/drfs/fake"
+)
+
+func TestEncryption(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+
+	key := drfs.Passphrase("correct horse battery staple")
+	file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: 3, Codec: drfs.Base85, Checksums: true, Key: key})
+	require.NoError(t, err)
+	require.NotNil(t, file.Index().Header.Encryption)
+	assert.Equal(t, drfs.AES256GCM, file.Index().Header.Encryption.Cipher)
+
+	// appending to partial replies encrypts these anew.
+	payload := binaryPayload(5*file.ReplySize() + 100)
+	_, err = file.WriteCtx(context.Background(), payload[:10])
+	require.NoError(t, err)
+	_, err = file.WriteCtx(context.Background(), payload[10:])
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	stat, err := file.Fstat()
+	require.NoError(t, err)
+	sum := sha256.Sum256(payload)
+	assert.NotEqual(t, sum[:], stat.Hash(), "the hash of encrypted files is keyed")
+
+	_, err = drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+	assert.True(t, errors.Is(err, drfs.ErrNoKey))
+	_, err = drfs.OpenWithKeyCtx(context.Background(), stat.Sys().(*drive.File), service, drfs.Passphrase("wrong"))
+	assert.True(t, errors.Is(err, drfs.ErrWrongKey))
+
+	reopened, err := drfs.OpenWithKeyCtx(context.Background(), stat.Sys().(*drive.File), service, key)
+	require.NoError(t, err)
+	got, err := ioutil.ReadAll(reopened)
+	require.NoError(t, err)
+	assert.Equal(t, payload, got)
+
+	buf := make([]byte, 50)
+	_, err = reopened.ReadAt(buf, int64(3*file.ReplySize()-20))
+	require.NoError(t, err)
+	assert.Equal(t, payload[3*file.ReplySize()-20:3*file.ReplySize()+30], buf)
+
+	// the state of the hash is sealed, as it holds the hash key and the content which does not fill a block.
+	state := reopened.Index().Header.Hash.State
+	assert.False(t, bytes.HasPrefix(state, []byte("sha\x03")), "the state of the hash is stored unsealed")
+
+	// appending continues hashing from the unsealed state.
+	_, err = reopened.WriteCtx(context.Background(), payload[:10])
+	require.NoError(t, err)
+	require.NoError(t, reopened.Close())
+	reopened, err = drfs.OpenWithKeyCtx(context.Background(), stat.Sys().(*drive.File), service, key)
+	require.NoError(t, err)
+	got, err = ioutil.ReadAll(reopened)
+	require.NoError(t, err)
+	assert.Equal(t, append(append([]byte{}, payload...), payload[:10]...), got)
+
+	// the replies hold no plaintext.
+	client, err := service.Take(context.Background(), 1)
+	require.NoError(t, err)
+	thread := reopened.Index().Buckets[0]
+	list, err := client.RepliesService().List(thread.FileID, thread.CommentID).Fields("*").Do()
+	require.NoError(t, err)
+	require.Len(t, list.Replies, 2)
+	base85, err := drfs.CodecByID(drfs.Base85)
+	require.NoError(t, err)
+	for _, reply := range list.Replies {
+		// the content is framed in padding, and the encoding follows the checksum of 8 hex characters.
+		stored, err := base85.Decode(reply.Content[1+8 : len(reply.Content)-1])
+		require.NoError(t, err)
+		assert.False(t, bytes.Contains(stored, payload[:32]))
+	}
+
+	// replies are bound to their position, so swapping these is detected.
+	first, second := list.Replies[0], list.Replies[1]
+	_, err = client.RepliesService().Update(thread.FileID, thread.CommentID, first.Id, &drive.Reply{Content: second.Content}).Do()
+	require.NoError(t, err)
+	reopened, err = drfs.OpenWithKeyCtx(context.Background(), stat.Sys().(*drive.File), service, key)
+	require.NoError(t, err)
+	_, err = io.Copy(ioutil.Discard, reopened)
+	assert.True(t, errors.Is(err, drfs.ErrDecrypt))
+}
+
+func TestEncryptionKeyFile(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "drfs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "key")
+	require.NoError(t, ioutil.WriteFile(path, []byte("short"), 0600))
+	_, err = drfs.KeyFile(path)
+	assert.Error(t, err)
+
+	require.NoError(t, ioutil.WriteFile(path, binaryPayload(32), 0600))
+	key, err := drfs.KeyFile(path)
+	require.NoError(t, err)
+
+	_, err = drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: 2, Key: key})
+	assert.Error(t, err, "raw cannot store encrypted data")
+
+	file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: 2, Codec: drfs.Base64, ParityThreads: 1, Key: key})
+	require.NoError(t, err)
+	payload := binaryPayload(3*file.ReplySize() + 7)
+	_, err = file.WriteCtx(context.Background(), payload)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	stat, err := file.Fstat()
+	require.NoError(t, err)
+	_, err = drfs.OpenWithKeyCtx(context.Background(), stat.Sys().(*drive.File), service, drfs.Passphrase("passphrase"))
+	assert.True(t, errors.Is(err, drfs.ErrWrongKey))
+
+	// the parity of encrypted files reconstructs lost threads.
+	deleteThread(t, service, file.Index().Buckets[0])
+	reopened, err := drfs.OpenWithKeyCtx(context.Background(), stat.Sys().(*drive.File), service, key)
+	require.NoError(t, err)
+	got, err := ioutil.ReadAll(reopened)
+	require.NoError(t, err)
+	assert.Equal(t, payload, got)
+}
+
+func TestEncryptionAge(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	dir, err := ioutil.TempDir("", "drfs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "identity")
+	require.NoError(t, ioutil.WriteFile(path, []byte("# created: today\n"+identity.String()+"\n"), 0600))
+
+	key, err := drfs.AgeIdentityFile(path)
+	require.NoError(t, err)
+	file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: 2, Codec: drfs.Base64, Key: key})
+	require.NoError(t, err)
+	payload := binaryPayload(3*file.ReplySize() + 7)
+	_, err = file.WriteCtx(context.Background(), payload)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	stat, err := file.Fstat()
+	require.NoError(t, err)
+	other, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	_, err = drfs.OpenWithKeyCtx(context.Background(), stat.Sys().(*drive.File), service, drfs.Age([]age.Identity{other}, nil))
+	assert.True(t, errors.Is(err, drfs.ErrWrongKey))
+	_, err = drfs.OpenWithKeyCtx(context.Background(), stat.Sys().(*drive.File), service, drfs.Passphrase("passphrase"))
+	assert.True(t, errors.Is(err, drfs.ErrWrongKey))
+
+	// the identity alone opens the file.
+	reopened, err := drfs.OpenWithKeyCtx(context.Background(), stat.Sys().(*drive.File), service, drfs.Age([]age.Identity{identity}, nil))
+	require.NoError(t, err)
+	got, err := ioutil.ReadAll(reopened)
+	require.NoError(t, err)
+	assert.Equal(t, payload, got)
+
+	_, err = drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: 2, Codec: drfs.Base64, Key: drfs.Age([]age.Identity{identity}, nil)})
+	assert.Error(t, err, "a key without recipients cannot create files")
+}
@@ -0,0 +1,199 @@
+package drfs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Arithmetic in GF(2^8), using the polynomial x^8 + x^4 + x^3 + x^2 + 1 and generator 2.
+var (
+	gfExp [510]byte
+	gfLog [256]int
+	gfMul [256][256]byte
+)
+
+func init() {
+	var x = 1
+	for i := 0; i < 255; i++ {
+		gfExp[i], gfExp[i+255] = byte(x), byte(x)
+		gfLog[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			gfMul[a][b] = gfExp[gfLog[a]+gfLog[b]]
+		}
+	}
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-gfLog[a]]
+}
+
+// gfPow returns a^n.
+func gfPow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]*n%255]
+}
+
+// mulAdd adds c * in to out.
+func mulAdd(c byte, in, out []byte) {
+	if c == 0 {
+		return
+	}
+	row := &gfMul[c]
+	for i, b := range in {
+		out[i] ^= row[b]
+	}
+}
+
+type matrix [][]byte
+
+func newMatrix(rows, cols int) matrix {
+	m := make(matrix, rows)
+	for r := range m {
+		m[r] = make([]byte, cols)
+	}
+	return m
+}
+
+func (m matrix) mul(o matrix) matrix {
+	res := newMatrix(len(m), len(o[0]))
+	for r := range m {
+		for c := range o[0] {
+			var v byte
+			for i := range o {
+				v ^= gfMul[m[r][i]][o[i][c]]
+			}
+			res[r][c] = v
+		}
+	}
+	return res
+}
+
+// rows returns the square matrix of the selected rows.
+func (m matrix) rows(rows []int) matrix {
+	res := make(matrix, len(rows))
+	for i, r := range rows {
+		res[i] = append([]byte{}, m[r]...)
+	}
+	return res
+}
+
+// invert returns the inverse of a square matrix using Gauss-Jordan elimination.
+func (m matrix) invert() (matrix, error) {
+	n := len(m)
+	work := newMatrix(n, 2*n)
+	for r := range m {
+		copy(work[r], m[r])
+		work[r][n+r] = 1
+	}
+
+	for c := 0; c < n; c++ {
+		pivot := c
+		for pivot < n && work[pivot][c] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, errors.New("singular matrix")
+		}
+		work[c], work[pivot] = work[pivot], work[c]
+
+		scale := gfInv(work[c][c])
+		for i := range work[c] {
+			work[c][i] = gfMul[scale][work[c][i]]
+		}
+		for r := 0; r < n; r++ {
+			if r != c && work[r][c] != 0 {
+				mulAdd(work[r][c], work[c], work[r])
+			}
+		}
+	}
+
+	res := make(matrix, n)
+	for r := range work {
+		res[r] = work[r][n:]
+	}
+	return res, nil
+}
+
+// erasureCode is a systematic Reed-Solomon code over GF(2^8) of k data shards and m parity shards. Any k of the k+m
+// shards suffice to reconstruct the others.
+type erasureCode struct {
+	k, m int
+
+	// encoding is the (k+m) x k matrix mapping the data shards onto all shards. The first k rows form the identity.
+	encoding matrix
+}
+
+func newErasureCode(k, m int) (*erasureCode, error) {
+	if k <= 0 || m < 0 || k+m > 256 {
+		return nil, fmt.Errorf("unsupported erasure code of %d data and %d parity shards", k, m)
+	}
+
+	// a Vandermonde matrix of distinct points, of which any k rows are independent, made systematic by multiplying
+	// it with the inverse of its top square.
+	vandermonde := newMatrix(k+m, k)
+	for r := range vandermonde {
+		for c := range vandermonde[r] {
+			vandermonde[r][c] = gfPow(byte(r), c)
+		}
+	}
+	top := make([]int, k)
+	for i := range top {
+		top[i] = i
+	}
+	inv, err := vandermonde.rows(top).invert()
+	if err != nil {
+		return nil, err
+	}
+	return &erasureCode{k: k, m: m, encoding: vandermonde.mul(inv)}, nil
+}
+
+// coefficient returns the coefficient of data shard i in parity shard j.
+func (e *erasureCode) coefficient(j, i int) byte {
+	return e.encoding[e.k+j][i]
+}
+
+// update adds the data written at offset off of data shard i to the parity shards. As the code is linear, writing
+// data over zeros alters the parity by the product of the data and the coefficients.
+func (e *erasureCode) update(parity [][]byte, i int, off int, data []byte) {
+	for j := range parity {
+		mulAdd(e.coefficient(j, i), data, parity[j][off:off+len(data)])
+	}
+}
+
+// reconstruct returns data shard i from the other shards, which are nil if missing. Shards which are present must be
+// of equal size.
+func (e *erasureCode) reconstruct(shards [][]byte, i int) ([]byte, error) {
+	var present []int
+	var size int
+	for r, shard := range shards {
+		if shard != nil && r != i && len(present) < e.k {
+			present = append(present, r)
+			size = len(shard)
+		}
+	}
+	if len(present) < e.k {
+		return nil, fmt.Errorf("%d of %d shards are missing; at most %d can be reconstructed", len(shards)-len(present), len(shards), e.m)
+	}
+
+	inv, err := e.encoding.rows(present).invert()
+	if err != nil {
+		return nil, err
+	}
+
+	var data = make([]byte, size)
+	for c, r := range present {
+		mulAdd(inv[i][c], shards[r], data)
+	}
+	return data, nil
+}
@@ -0,0 +1,54 @@
+package drfs
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErasureCode(t *testing.T) {
+	for _, c := range []struct{ k, m int }{{1, 1}, {4, 2}, {10, 3}, {200, 56}} {
+		code, err := newErasureCode(c.k, c.m)
+		require.NoError(t, err)
+
+		rng := rand.New(rand.NewSource(int64(c.k)))
+		shards := make([][]byte, c.k+c.m)
+		for i := range shards {
+			shards[i] = make([]byte, 64)
+		}
+		for i := 0; i < c.k; i++ {
+			rng.Read(shards[i])
+			code.update(shards[c.k:], i, 0, shards[i])
+		}
+
+		// lose m shards, including data shard 0.
+		lost := rng.Perm(c.k + c.m)[:c.m]
+		lost[0] = 0
+		available := make([][]byte, len(shards))
+		copy(available, shards)
+		for _, r := range lost {
+			available[r] = nil
+		}
+
+		for i := 0; i < c.k; i++ {
+			if available[i] != nil {
+				continue
+			}
+			data, err := code.reconstruct(available, i)
+			require.NoError(t, err)
+			assert.Equal(t, shards[i], data, "%d+%d: shard %d", c.k, c.m, i)
+		}
+
+		for _, r := range rng.Perm(c.k + c.m)[:c.m+1] {
+			available[r] = nil
+		}
+		available[0] = nil
+		_, err = code.reconstruct(available, 0)
+		assert.Error(t, err)
+	}
+
+	_, err := newErasureCode(200, 57)
+	assert.Error(t, err)
+}
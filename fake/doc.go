@@ -0,0 +1,4 @@
+// Package fake implements an in-memory emulation of the subset of the Drive v3 REST API used by drfs. The emulator
+// runs as an httptest.Server, so the regular google.golang.org/api/drive/v3 client is used against it and tests run
+// without credentials.
+package fake
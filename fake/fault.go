@@ -0,0 +1,180 @@
+package fake
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// Fault describes a failure injected into a request. A fault either results in an API error with the given code and
+// reason, or in a timeout.
+type Fault struct {
+	Code    int
+	Reason  string
+	Timeout bool
+
+	// Partial faults are injected after the backend handled the request, so the request takes effect but the caller
+	// observes a failure.
+	Partial bool
+}
+
+var (
+	// InternalError is the 500 Drive returns on backend errors.
+	InternalError = Fault{Code: http.StatusInternalServerError, Reason: "backendError"}
+
+	// NotFound is the 404 Drive returns due to eventual consistency, for resources which do exist.
+	NotFound = Fault{Code: http.StatusNotFound, Reason: "notFound"}
+
+	// RateLimitExceeded is the 403 Drive returns once the rate limit is exceeded.
+	RateLimitExceeded = Fault{Code: http.StatusForbidden, Reason: "rateLimitExceeded"}
+
+	// BadRequest is a permanent failure, which drfs does not retry.
+	BadRequest = Fault{Code: http.StatusBadRequest, Reason: "badRequest"}
+
+	// Timeout fails the request without a response, as if the deadline of the request was exceeded.
+	Timeout = Fault{Timeout: true}
+)
+
+// Partial returns a copy of the fault which is injected after the backend handled the request.
+func Partial(f Fault) Fault {
+	f.Partial = true
+	return f
+}
+
+// Matcher selects the requests a Rule applies to.
+type Matcher func(r *http.Request) bool
+
+// Match matches requests by HTTP method and the kind of resource they operate on; such as "files", "comments",
+// "replies" or "permissions". An empty method or resource matches any.
+//
+//	Match(http.MethodPost, "replies") // replies.create
+//	Match(http.MethodPatch, "comments") // comments.update
+func Match(method string, resource string) Matcher {
+	return func(r *http.Request) bool {
+		return (method == "" || r.Method == method) && (resource == "" || resourceOf(r) == resource)
+	}
+}
+
+// resourceOf returns the kind of resource a request operates on, derived from the path. Collections are at odd
+// positions in the path: files/{fileId}/comments/{commentId}/replies/{replyId}.
+func resourceOf(r *http.Request) string {
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, basePath), "/"), "/")
+	if len(segments)%2 == 1 {
+		return segments[len(segments)-1]
+	}
+	return segments[len(segments)-2]
+}
+
+// Rule injects a Fault into matching requests, either at a given probability or on given calls.
+type Rule struct {
+	// Match selects the requests the rule applies to. Nil matches all requests.
+	Match Matcher
+	Fault Fault
+
+	// Probability of injecting the fault into a matching request.
+	Probability float64
+
+	// Calls are the 1-based numbers of the matching requests the fault is injected into, regardless of Probability.
+	Calls []int
+
+	matched int
+}
+
+func (r *Rule) inject(req *http.Request, rng *rand.Rand) bool {
+	if r.Match != nil && !r.Match(req) {
+		return false
+	}
+
+	r.matched++
+	for _, call := range r.Calls {
+		if call == r.matched {
+			return true
+		}
+	}
+	return r.Probability > 0 && rng.Float64() < r.Probability
+}
+
+// Injector is a http.RoundTripper wrapping the transport of a drfs.Client, injecting faults according to its rules.
+// Every rule is evaluated for every request, so the call counts of a rule do not depend on the rules before it; if
+// several rules inject a fault into a request, the first one wins.
+type Injector struct {
+	transport http.RoundTripper
+
+	mu       sync.Mutex
+	rules    []*Rule
+	rng      *rand.Rand
+	injected int
+}
+
+// NewInjector wraps the transport. Probabilities are drawn from a fixed seed, so a sequential workload is
+// reproducible.
+func NewInjector(transport http.RoundTripper, rules ...*Rule) *Injector {
+	return &Injector{
+		transport: transport,
+		rules:     rules,
+		rng:       rand.New(rand.NewSource(1)),
+	}
+}
+
+// Injected returns the number of faults injected so far.
+func (i *Injector) Injected() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.injected
+}
+
+func (i *Injector) RoundTrip(req *http.Request) (*http.Response, error) {
+	fault, ok := i.fault(req)
+	if !ok {
+		return i.transport.RoundTrip(req)
+	}
+
+	if fault.Partial {
+		resp, err := i.transport.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	} else if req.Body != nil {
+		req.Body.Close()
+	}
+
+	if fault.Timeout {
+		return nil, timeoutError{}
+	}
+
+	rec := httptest.NewRecorder()
+	writeError(rec, &apiError{code: fault.Code, reason: fault.Reason, message: "injected fault: " + fault.Reason})
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+func (i *Injector) fault(req *http.Request) (Fault, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var fault Fault
+	var ok bool
+	for _, rule := range i.rules {
+		if rule.inject(req, i.rng) && !ok {
+			fault, ok = rule.Fault, true
+		}
+	}
+	if ok {
+		i.injected++
+	}
+	return fault, ok
+}
+
+// timeoutError implements net.Error, as returned by a transport once a deadline is exceeded.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "injected fault: timeout awaiting response headers" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
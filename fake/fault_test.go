@@ -0,0 +1,116 @@
+package fake_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+
+	"github.com/kaiserkarel/drfs/fake"
+)
+
+func newInjectedService(t *testing.T, rules ...*fake.Rule) (*fake.Server, *fake.Injector, *fake.Service) {
+	server := fake.NewServer()
+	injector := fake.NewInjector(server.Client().Transport, rules...)
+	service, err := fake.NewServiceWithTransport(context.Background(), server, injector)
+	require.NoError(t, err)
+	return server, injector, service
+}
+
+func TestInjectorCalls(t *testing.T) {
+	server, injector, service := newInjectedService(t, &fake.Rule{
+		Match: fake.Match(http.MethodPost, "files"),
+		Fault: fake.RateLimitExceeded,
+		Calls: []int{2},
+	})
+	defer server.Close()
+
+	client, err := service.Take(context.Background(), 3)
+	require.NoError(t, err)
+
+	_, err = client.FilesService().Create(&drive.File{Name: "first"}).Do()
+	require.NoError(t, err)
+
+	_, err = client.FilesService().Create(&drive.File{Name: "second"}).Do()
+	var apiErr *googleapi.Error
+	require.True(t, errors.As(err, &apiErr), "expected a googleapi.Error, got %v", err)
+	assert.Equal(t, http.StatusForbidden, apiErr.Code)
+	require.Len(t, apiErr.Errors, 1)
+	assert.Equal(t, "rateLimitExceeded", apiErr.Errors[0].Reason)
+
+	_, err = client.FilesService().Create(&drive.File{Name: "third"}).Do()
+	require.NoError(t, err)
+
+	list, err := client.FilesService().List().Do()
+	require.NoError(t, err)
+	assert.Len(t, list.Files, 2, "the failed call should not have been applied")
+	assert.Equal(t, 1, injector.Injected())
+}
+
+func TestInjectorOverlappingRules(t *testing.T) {
+	server, injector, service := newInjectedService(t,
+		&fake.Rule{Match: fake.Match(http.MethodPost, "files"), Fault: fake.BadRequest, Calls: []int{1}},
+		&fake.Rule{Match: fake.Match(http.MethodPost, "files"), Fault: fake.RateLimitExceeded, Calls: []int{2}},
+	)
+	defer server.Close()
+
+	client, err := service.Take(context.Background(), 3)
+	require.NoError(t, err)
+
+	var apiErr *googleapi.Error
+	_, err = client.FilesService().Create(&drive.File{Name: "first"}).Do()
+	require.True(t, errors.As(err, &apiErr), "expected a googleapi.Error, got %v", err)
+	assert.Equal(t, http.StatusBadRequest, apiErr.Code)
+
+	_, err = client.FilesService().Create(&drive.File{Name: "second"}).Do()
+	require.True(t, errors.As(err, &apiErr), "expected a googleapi.Error, got %v", err)
+	assert.Equal(t, http.StatusForbidden, apiErr.Code, "the second rule should count the call the first rule failed")
+
+	_, err = client.FilesService().Create(&drive.File{Name: "third"}).Do()
+	require.NoError(t, err)
+	assert.Equal(t, 2, injector.Injected())
+}
+
+func TestInjectorPartial(t *testing.T) {
+	server, _, service := newInjectedService(t, &fake.Rule{
+		Match:       fake.Match(http.MethodPost, "files"),
+		Fault:       fake.Partial(fake.InternalError),
+		Probability: 1,
+	})
+	defer server.Close()
+
+	client, err := service.Take(context.Background(), 2)
+	require.NoError(t, err)
+
+	_, err = client.FilesService().Create(&drive.File{Name: "partial"}).Do()
+	var apiErr *googleapi.Error
+	require.True(t, errors.As(err, &apiErr), "expected a googleapi.Error, got %v", err)
+	assert.Equal(t, http.StatusInternalServerError, apiErr.Code)
+
+	list, err := client.FilesService().List().Do()
+	require.NoError(t, err)
+	assert.Len(t, list.Files, 1, "the failed call should have been applied")
+}
+
+func TestInjectorTimeout(t *testing.T) {
+	server, _, service := newInjectedService(t, &fake.Rule{
+		Match:       fake.Match(http.MethodGet, ""),
+		Fault:       fake.Timeout,
+		Probability: 1,
+	})
+	defer server.Close()
+
+	client, err := service.Take(context.Background(), 1)
+	require.NoError(t, err)
+
+	_, err = client.FilesService().List().Do()
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr), "expected a net.Error, got %v", err)
+	assert.True(t, netErr.Timeout())
+}
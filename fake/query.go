@@ -0,0 +1,148 @@
+package fake
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// term is a single clause of a files.list query.
+type term func(f *drive.File) bool
+
+// parseQuery parses the subset of the Drive query language used by drfs: clauses on name, mimeType, parents and
+// trashed, joined by 'and'.
+func parseQuery(q string) (term, error) {
+	var terms []term
+	for _, clause := range splitAnd(q) {
+		t, err := parseClause(strings.TrimSpace(clause))
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, t)
+	}
+
+	return func(f *drive.File) bool {
+		for _, t := range terms {
+			if !t(f) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func parseClause(clause string) (term, error) {
+	if clause == "" {
+		return func(*drive.File) bool { return true }, nil
+	}
+
+	// '<id>' in parents
+	if strings.HasPrefix(clause, "'") {
+		value, rest, err := unquote(clause)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(rest) != "in parents" {
+			return nil, fmt.Errorf("unsupported clause: %s", clause)
+		}
+		return func(f *drive.File) bool {
+			for _, p := range f.Parents {
+				if p == value {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+
+	fields := strings.SplitN(clause, " ", 3)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("unsupported clause: %s", clause)
+	}
+	field, op, operand := fields[0], fields[1], strings.TrimSpace(fields[2])
+
+	if field == "trashed" {
+		want := operand == "true"
+		if operand != "true" && operand != "false" {
+			return nil, fmt.Errorf("invalid value for trashed: %s", operand)
+		}
+		if op == "!=" {
+			want = !want
+		} else if op != "=" {
+			return nil, fmt.Errorf("unsupported operator: %s", op)
+		}
+		return func(f *drive.File) bool { return f.Trashed == want }, nil
+	}
+
+	value, rest, err := unquote(operand)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("unexpected trailing input: %s", rest)
+	}
+
+	var get func(f *drive.File) string
+	switch field {
+	case "name":
+		get = func(f *drive.File) string { return f.Name }
+	case "mimeType":
+		get = func(f *drive.File) string { return f.MimeType }
+	default:
+		return nil, fmt.Errorf("unsupported field: %s", field)
+	}
+
+	switch op {
+	case "=":
+		return func(f *drive.File) bool { return get(f) == value }, nil
+	case "!=":
+		return func(f *drive.File) bool { return get(f) != value }, nil
+	case "contains":
+		return func(f *drive.File) bool { return strings.Contains(get(f), value) }, nil
+	}
+	return nil, fmt.Errorf("unsupported operator: %s", op)
+}
+
+// unquote reads a single quoted string literal from the start of s, returning the literal and the remaining input.
+func unquote(s string) (string, string, error) {
+	if !strings.HasPrefix(s, "'") {
+		return "", "", fmt.Errorf("expected string literal: %s", s)
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+			if i < len(s) {
+				b.WriteByte(s[i])
+			}
+		case '\'':
+			return b.String(), strings.TrimSpace(s[i+1:]), nil
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string literal: %s", s)
+}
+
+// splitAnd splits the query on 'and', ignoring occurrences within string literals.
+func splitAnd(q string) []string {
+	var clauses []string
+	var quoted bool
+	var start int
+	for i := 0; i < len(q); i++ {
+		switch {
+		case q[i] == '\\' && quoted:
+			i++
+		case q[i] == '\'':
+			quoted = !quoted
+		case !quoted && strings.HasPrefix(q[i:], " and "):
+			clauses = append(clauses, q[start:i])
+			start = i + len(" and ")
+			i = start - 1
+		}
+	}
+	return append(clauses, q[start:])
+}
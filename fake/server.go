@@ -0,0 +1,677 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+)
+
+// basePath is the path under which the emulated API is served, matching the path of the Drive v3 endpoint.
+const basePath = "/drive/v3/"
+
+const (
+	// defaultPageSize is the page size Drive uses for comments and replies if none is provided.
+	defaultPageSize = 20
+
+	// maxPageSize is the maximum page size Drive allows for comments and replies.
+	maxPageSize = 100
+
+	// defaultFilesPageSize is the page size Drive uses for files if none is provided.
+	defaultFilesPageSize = 100
+
+	// maxFilesPageSize is the maximum page size Drive allows for files.
+	maxFilesPageSize = 1000
+)
+
+// Server is an in-memory Drive backend. Files, comments, replies and permissions only live as long as the server.
+type Server struct {
+	*httptest.Server
+
+	// MaxContentSize is the maximum size of the content of a comment or reply, as measured by Count. Defaults to
+	// drfs.MaxReplySize.
+	MaxContentSize int
+
+	// Count measures the size of content. Defaults to the number of bytes; set it to utf8.RuneCountInString to
+	// emulate a limit in characters.
+	Count func(content string) int
+
+	mu    sync.Mutex
+	seq   int64
+	files map[string]*file
+	order []*file
+}
+
+type file struct {
+	meta        *drive.File
+	comments    []*comment
+	permissions []*drive.Permission
+}
+
+type comment struct {
+	meta    *drive.Comment
+	replies []*drive.Reply
+}
+
+// NewServer starts a new, empty backend. The caller should Close the server when done.
+func NewServer() *Server {
+	s := &Server{
+		MaxContentSize: drfs.MaxReplySize,
+		files:          make(map[string]*file),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// apiError is rendered in the error format of the Google APIs, so the client returns a *googleapi.Error.
+type apiError struct {
+	code    int
+	reason  string
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func notFound(kind, id string) *apiError {
+	return &apiError{http.StatusNotFound, "notFound", fmt.Sprintf("%s not found: %s.", kind, id)}
+}
+
+func invalid(format string, args ...interface{}) *apiError {
+	return &apiError{http.StatusBadRequest, "invalid", fmt.Sprintf(format, args...)}
+}
+
+func writeError(w http.ResponseWriter, err *apiError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"errors": []map[string]string{{
+				"domain":  "global",
+				"reason":  err.reason,
+				"message": err.message,
+			}},
+			"code":    err.code,
+			"message": err.message,
+		},
+	})
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, basePath) {
+		writeError(w, &apiError{http.StatusNotFound, "notFound", "Not Found"})
+		return
+	}
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, basePath), "/"), "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp, err := s.route(r, segments)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) route(r *http.Request, segments []string) (interface{}, *apiError) {
+	if segments[0] != "files" {
+		return nil, &apiError{http.StatusNotFound, "notFound", "Not Found"}
+	}
+
+	switch len(segments) {
+	case 1:
+		switch r.Method {
+		case http.MethodPost:
+			return s.createFile(r)
+		case http.MethodGet:
+			return s.listFiles(r)
+		}
+	case 2:
+		switch r.Method {
+		case http.MethodGet:
+			return s.getFile(segments[1])
+		case http.MethodPatch:
+			return s.updateFile(r, segments[1])
+		case http.MethodDelete:
+			return nil, s.deleteFile(segments[1])
+		}
+	case 3:
+		switch {
+		case segments[2] == "comments" && r.Method == http.MethodPost:
+			return s.createComment(r, segments[1])
+		case segments[2] == "comments" && r.Method == http.MethodGet:
+			return s.listComments(r, segments[1])
+		case segments[2] == "permissions" && r.Method == http.MethodPost:
+			return s.createPermission(r, segments[1])
+		case segments[2] == "permissions" && r.Method == http.MethodGet:
+			return s.listPermissions(r, segments[1])
+		}
+	case 4:
+		if segments[2] != "comments" {
+			break
+		}
+		switch r.Method {
+		case http.MethodGet:
+			return s.getComment(r, segments[1], segments[3])
+		case http.MethodPatch:
+			return s.updateComment(r, segments[1], segments[3])
+		case http.MethodDelete:
+			return nil, s.deleteComment(segments[1], segments[3])
+		}
+	case 5:
+		if segments[2] != "comments" || segments[4] != "replies" {
+			break
+		}
+		switch r.Method {
+		case http.MethodPost:
+			return s.createReply(r, segments[1], segments[3])
+		case http.MethodGet:
+			return s.listReplies(r, segments[1], segments[3])
+		}
+	case 6:
+		if segments[2] != "comments" || segments[4] != "replies" {
+			break
+		}
+		switch r.Method {
+		case http.MethodGet:
+			return s.getReply(r, segments[1], segments[3], segments[5])
+		case http.MethodPatch:
+			return s.updateReply(r, segments[1], segments[3], segments[5])
+		case http.MethodDelete:
+			return nil, s.deleteReply(segments[1], segments[3], segments[5])
+		}
+	}
+	return nil, &apiError{http.StatusNotFound, "notFound", fmt.Sprintf("%s %s is not supported", r.Method, r.URL.Path)}
+}
+
+func (s *Server) id() string {
+	s.seq++
+	return fmt.Sprintf("AAAA%08x", s.seq)
+}
+
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
+
+func decode(r *http.Request, v interface{}) *apiError {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return invalid("invalid request body: %s", err)
+	}
+	return nil
+}
+
+// content trims whitespace the way Drive does and enforces the maximum content size.
+func (s *Server) content(content string) (string, *apiError) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return "", &apiError{http.StatusBadRequest, "required", "Required: content"}
+	}
+	var size = len(content)
+	if s.Count != nil {
+		size = s.Count(content)
+	}
+	if size > s.MaxContentSize {
+		return "", invalid("Content exceeds the maximum size of %d.", s.MaxContentSize)
+	}
+	return content, nil
+}
+
+// paginate computes the window [start, end) of a list of n items, and the token of the next page.
+func paginate(r *http.Request, n int, size, max int) (int, int, string, *apiError) {
+	if raw := r.URL.Query().Get("pageSize"); raw != "" {
+		var err error
+		size, err = strconv.Atoi(raw)
+		if err != nil || size < 1 || size > max {
+			return 0, 0, "", invalid("Invalid value for pageSize: %s", raw)
+		}
+	}
+
+	var start int
+	if raw := r.URL.Query().Get("pageToken"); raw != "" {
+		var err error
+		start, err = strconv.Atoi(raw)
+		if err != nil || start < 0 || start > n {
+			return 0, 0, "", invalid("Invalid value for pageToken: %s", raw)
+		}
+	}
+
+	end := start + size
+	if end >= n {
+		return start, n, "", nil
+	}
+	return start, end, strconv.Itoa(end), nil
+}
+
+func (s *Server) file(id string) (*file, *apiError) {
+	f, ok := s.files[id]
+	if !ok {
+		return nil, notFound("File", id)
+	}
+	return f, nil
+}
+
+func (s *Server) comment(fileID, commentID string) (*file, *comment, *apiError) {
+	f, err := s.file(fileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, c := range f.comments {
+		if c.meta.Id == commentID && !c.meta.Deleted {
+			return f, c, nil
+		}
+	}
+	return nil, nil, notFound("Comment", commentID)
+}
+
+func (s *Server) reply(fileID, commentID, replyID string) (*comment, *drive.Reply, *apiError) {
+	_, c, err := s.comment(fileID, commentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, reply := range c.replies {
+		if reply.Id == replyID {
+			return c, reply, nil
+		}
+	}
+	return nil, nil, notFound("Reply", replyID)
+}
+
+func (s *Server) createFile(r *http.Request) (interface{}, *apiError) {
+	var req drive.File
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+
+	mimeType := req.MimeType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	ts := now()
+	f := &file{
+		meta: &drive.File{
+			Kind:         "drive#file",
+			Id:           s.id(),
+			Name:         req.Name,
+			MimeType:     mimeType,
+			Parents:      req.Parents,
+			Description:  req.Description,
+			CreatedTime:  ts,
+			ModifiedTime: ts,
+		},
+	}
+	s.files[f.meta.Id] = f
+	s.order = append(s.order, f)
+	return f.meta, nil
+}
+
+func (s *Server) listFiles(r *http.Request) (interface{}, *apiError) {
+	match, err := parseQuery(r.URL.Query().Get("q"))
+	if err != nil {
+		return nil, invalid("Invalid Value: %s", err)
+	}
+
+	var files []*drive.File
+	for _, f := range s.order {
+		if match(f.meta) {
+			files = append(files, f.meta)
+		}
+	}
+
+	start, end, next, apiErr := paginate(r, len(files), defaultFilesPageSize, maxFilesPageSize)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return &drive.FileList{
+		Kind:          "drive#fileList",
+		Files:         files[start:end],
+		NextPageToken: next,
+	}, nil
+}
+
+func (s *Server) getFile(id string) (interface{}, *apiError) {
+	f, err := s.file(id)
+	if err != nil {
+		return nil, err
+	}
+	return f.meta, nil
+}
+
+func (s *Server) updateFile(r *http.Request, id string) (interface{}, *apiError) {
+	f, err := s.file(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var req drive.File
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+
+	if req.Name != "" {
+		f.meta.Name = req.Name
+	}
+	if req.MimeType != "" {
+		f.meta.MimeType = req.MimeType
+	}
+	if req.Description != "" {
+		f.meta.Description = req.Description
+	}
+
+	query := r.URL.Query()
+	if remove := query.Get("removeParents"); remove != "" {
+		var parents []string
+		for _, p := range f.meta.Parents {
+			if !contains(strings.Split(remove, ","), p) {
+				parents = append(parents, p)
+			}
+		}
+		f.meta.Parents = parents
+	}
+	if add := query.Get("addParents"); add != "" {
+		for _, p := range strings.Split(add, ",") {
+			if !contains(f.meta.Parents, p) {
+				f.meta.Parents = append(f.meta.Parents, p)
+			}
+		}
+	}
+
+	f.meta.ModifiedTime = now()
+	if req.ModifiedTime != "" {
+		f.meta.ModifiedTime = req.ModifiedTime
+	}
+	return f.meta, nil
+}
+
+func (s *Server) deleteFile(id string) *apiError {
+	f, err := s.file(id)
+	if err != nil {
+		return err
+	}
+
+	delete(s.files, id)
+	for i := range s.order {
+		if s.order[i] == f {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *Server) createComment(r *http.Request, fileID string) (interface{}, *apiError) {
+	f, err := s.file(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	var req drive.Comment
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+
+	content, err := s.content(req.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	ts := now()
+	c := &comment{
+		meta: &drive.Comment{
+			Kind:         "drive#comment",
+			Id:           s.id(),
+			Content:      content,
+			CreatedTime:  ts,
+			ModifiedTime: ts,
+		},
+	}
+	f.comments = append(f.comments, c)
+	return c.meta, nil
+}
+
+func (s *Server) listComments(r *http.Request, fileID string) (interface{}, *apiError) {
+	f, err := s.file(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	includeDeleted := r.URL.Query().Get("includeDeleted") == "true"
+
+	var comments []*drive.Comment
+	for _, c := range f.comments {
+		if c.meta.Deleted && !includeDeleted {
+			continue
+		}
+		comments = append(comments, c.render(includeDeleted))
+	}
+
+	start, end, next, err := paginate(r, len(comments), defaultPageSize, maxPageSize)
+	if err != nil {
+		return nil, err
+	}
+	return &drive.CommentList{
+		Kind:          "drive#commentList",
+		Comments:      comments[start:end],
+		NextPageToken: next,
+	}, nil
+}
+
+func (s *Server) getComment(r *http.Request, fileID, commentID string) (interface{}, *apiError) {
+	_, c, err := s.comment(fileID, commentID)
+	if err != nil {
+		return nil, err
+	}
+	return c.render(r.URL.Query().Get("includeDeleted") == "true"), nil
+}
+
+func (s *Server) updateComment(r *http.Request, fileID, commentID string) (interface{}, *apiError) {
+	_, c, err := s.comment(fileID, commentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var req drive.Comment
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+
+	content, err := s.content(req.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	c.meta.Content = content
+	c.meta.ModifiedTime = now()
+	return c.meta, nil
+}
+
+func (s *Server) deleteComment(fileID, commentID string) *apiError {
+	_, c, err := s.comment(fileID, commentID)
+	if err != nil {
+		return err
+	}
+
+	c.meta.Deleted = true
+	c.meta.Content = ""
+	c.meta.ModifiedTime = now()
+	return nil
+}
+
+// render returns a copy of the comment including its replies, as Drive embeds the replies in the comment resource.
+func (c *comment) render(includeDeleted bool) *drive.Comment {
+	rendered := *c.meta
+	rendered.Replies = nil
+	for _, reply := range c.replies {
+		if reply.Deleted && !includeDeleted {
+			continue
+		}
+		rendered.Replies = append(rendered.Replies, reply)
+	}
+	return &rendered
+}
+
+func (s *Server) createReply(r *http.Request, fileID, commentID string) (interface{}, *apiError) {
+	_, c, err := s.comment(fileID, commentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var req drive.Reply
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+
+	content, err := s.content(req.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	ts := now()
+	reply := &drive.Reply{
+		Kind:         "drive#reply",
+		Id:           s.id(),
+		Content:      content,
+		CreatedTime:  ts,
+		ModifiedTime: ts,
+	}
+	c.replies = append(c.replies, reply)
+	c.meta.ModifiedTime = ts
+	return reply, nil
+}
+
+func (s *Server) listReplies(r *http.Request, fileID, commentID string) (interface{}, *apiError) {
+	_, c, err := s.comment(fileID, commentID)
+	if err != nil {
+		return nil, err
+	}
+
+	replies := c.render(r.URL.Query().Get("includeDeleted") == "true").Replies
+
+	start, end, next, err := paginate(r, len(replies), defaultPageSize, maxPageSize)
+	if err != nil {
+		return nil, err
+	}
+	return &drive.ReplyList{
+		Kind:          "drive#replyList",
+		Replies:       replies[start:end],
+		NextPageToken: next,
+	}, nil
+}
+
+func (s *Server) getReply(r *http.Request, fileID, commentID, replyID string) (interface{}, *apiError) {
+	_, reply, err := s.reply(fileID, commentID, replyID)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Deleted && r.URL.Query().Get("includeDeleted") != "true" {
+		return nil, notFound("Reply", replyID)
+	}
+	return reply, nil
+}
+
+func (s *Server) updateReply(r *http.Request, fileID, commentID, replyID string) (interface{}, *apiError) {
+	c, reply, err := s.reply(fileID, commentID, replyID)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Deleted {
+		return nil, notFound("Reply", replyID)
+	}
+
+	var req drive.Reply
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+
+	content, err := s.content(req.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	reply.Content = content
+	reply.ModifiedTime = now()
+	c.meta.ModifiedTime = reply.ModifiedTime
+	return reply, nil
+}
+
+func (s *Server) deleteReply(fileID, commentID, replyID string) *apiError {
+	c, reply, err := s.reply(fileID, commentID, replyID)
+	if err != nil {
+		return err
+	}
+	if reply.Deleted {
+		return notFound("Reply", replyID)
+	}
+
+	reply.Deleted = true
+	reply.Content = ""
+	reply.ModifiedTime = now()
+	c.meta.ModifiedTime = reply.ModifiedTime
+	return nil
+}
+
+func (s *Server) createPermission(r *http.Request, fileID string) (interface{}, *apiError) {
+	f, err := s.file(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	var req drive.Permission
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+
+	permission := &drive.Permission{
+		Kind:         "drive#permission",
+		Id:           s.id(),
+		EmailAddress: req.EmailAddress,
+		Role:         req.Role,
+		Type:         req.Type,
+	}
+	f.permissions = append(f.permissions, permission)
+	return permission, nil
+}
+
+func (s *Server) listPermissions(r *http.Request, fileID string) (interface{}, *apiError) {
+	f, err := s.file(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end, next, err := paginate(r, len(f.permissions), maxPageSize, maxPageSize)
+	if err != nil {
+		return nil, err
+	}
+	return &drive.PermissionList{
+		Kind:          "drive#permissionList",
+		Permissions:   f.permissions[start:end],
+		NextPageToken: next,
+	}, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
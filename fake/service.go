@@ -0,0 +1,81 @@
+package fake
+
+import (
+	"context"
+	"net/http"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+
+	"github.com/kaiserkarel/drfs"
+)
+
+// Service implements drfs.Service using a single client talking to a Server. There are no rate limits, so Take only
+// blocks on a cancelled context.
+type Service struct {
+	client *Client
+	emails []string
+}
+
+// NewService constructs a Service for the server. The emails are returned by Emails, causing drfs to share created
+// files with them.
+func NewService(ctx context.Context, server *Server, emails ...string) (*Service, error) {
+	return NewServiceWithTransport(ctx, server, server.Client().Transport, emails...)
+}
+
+// NewServiceWithTransport constructs a Service for the server, sending requests through the transport. Use it to
+// wrap the transport of the server in an Injector:
+//
+//	injector := NewInjector(server.Client().Transport, rules...)
+//	service, err := NewServiceWithTransport(ctx, server, injector)
+func NewServiceWithTransport(ctx context.Context, server *Server, transport http.RoundTripper, emails ...string) (*Service, error) {
+	service, err := drive.NewService(ctx,
+		option.WithEndpoint(server.URL+basePath),
+		option.WithHTTPClient(&http.Client{Transport: transport}))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Service{
+		client: &Client{service: service},
+		emails: emails,
+	}, nil
+}
+
+// Emails returns the emails the service was constructed with.
+func (s *Service) Emails() []string {
+	return s.emails
+}
+
+// Take returns the client of the service.
+func (s *Service) Take(ctx context.Context, _ int) (drfs.Client, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.client, nil
+}
+
+// Client implements drfs.Client for a Server.
+type Client struct {
+	service *drive.Service
+}
+
+func (c *Client) FilesService() *drive.FilesService {
+	return drive.NewFilesService(c.service)
+}
+
+func (c *Client) RepliesService() *drive.RepliesService {
+	return drive.NewRepliesService(c.service)
+}
+
+func (c *Client) CommentsService() *drive.CommentsService {
+	return drive.NewCommentsService(c.service)
+}
+
+func (c *Client) DrivesService() *drive.DrivesService {
+	return drive.NewDrivesService(c.service)
+}
+
+func (c *Client) PermissionsService() *drive.PermissionsService {
+	return drive.NewPermissionsService(c.service)
+}
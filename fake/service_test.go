@@ -0,0 +1,137 @@
+package fake_test
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+	"github.com/kaiserkarel/drfs/fake"
+	"github.com/kaiserkarel/drfs/recovery"
+)
+
+func TestImplementsService(t *testing.T) {
+	assert.Implements(t, (*drfs.Service)(nil), &fake.Service{}, "Service should implement drfs.Service")
+}
+
+func TestImplementsClient(t *testing.T) {
+	assert.Implements(t, (*drfs.Client)(nil), &fake.Client{}, "Client should implement drfs.Client")
+}
+
+func newService(t *testing.T, emails ...string) (*fake.Server, *fake.Service) {
+	server := fake.NewServer()
+	service, err := fake.NewService(context.Background(), server, emails...)
+	require.NoError(t, err)
+	return server, service
+}
+
+func TestReadWrite(t *testing.T) {
+	server, service := newService(t)
+	defer server.Close()
+
+	file, err := drfs.CreateFileCtx(context.Background(), service, "TestReadWrite", drfs.FileOptions{NumThreads: 4})
+	require.NoError(t, err)
+
+	payload := make([]byte, 2*4*drfs.EffectiveReplySize+1000)
+	rand.New(rand.NewSource(1)).Read(payload)
+	for i := range payload {
+		payload[i] = 'a' + payload[i]%26
+	}
+
+	_, err = file.WriteCtx(context.Background(), payload[:len(payload)-30])
+	require.NoError(t, err)
+	_, err = file.WriteCtx(context.Background(), payload[len(payload)-30:])
+	require.NoError(t, err)
+
+	client, err := service.Take(context.Background(), 1)
+	require.NoError(t, err)
+	driveFile, err := client.FilesService().Get(file.Index().Buckets[0].FileID).Fields("*").Do()
+	require.NoError(t, err)
+
+	reopened, err := drfs.OpenCtx(context.Background(), driveFile, service)
+	require.NoError(t, err)
+
+	assert.Equal(t, file.Index().Header, reopened.Index().Header, "index headers should be equal")
+	require.Len(t, reopened.Index().Buckets, 4)
+	for i, bucket := range reopened.Index().Buckets {
+		assert.Equal(t, file.Index().Buckets[i].Header, bucket.Header, "thread headers should be equal")
+	}
+
+	stat, err := reopened.Stat()
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(payload)), stat.Size())
+
+	rStat, err := recovery.Stats(reopened)
+	require.NoError(t, err)
+	assert.Equal(t, stat.Size(), rStat.Size(), "index size should match recovery size")
+
+	buf := make([]byte, len(payload))
+	_, err = io.ReadFull(reopened, buf)
+	require.NoError(t, err)
+	assert.Equal(t, string(payload), string(buf))
+}
+
+func TestLeadingSpacesStripped(t *testing.T) {
+	server, service := newService(t)
+	defer server.Close()
+
+	client, err := service.Take(context.Background(), 3)
+	require.NoError(t, err)
+
+	file, err := client.FilesService().Create(&drive.File{Name: "TestLeadingSpacesStripped"}).Do()
+	require.NoError(t, err)
+
+	comment, err := client.CommentsService().Create(file.Id, &drive.Comment{Content: "Test"}).Fields("*").Do()
+	require.NoError(t, err)
+
+	reply, err := client.RepliesService().Create(file.Id, comment.Id, &drive.Reply{Content: " hello "}).Fields("*").Do()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", reply.Content)
+}
+
+func TestPermissionsShared(t *testing.T) {
+	server, service := newService(t, "a@example.com", "b@example.com")
+	defer server.Close()
+
+	file, err := drfs.CreateFileCtx(context.Background(), service, "TestPermissionsShared", drfs.FileOptions{NumThreads: 1})
+	require.NoError(t, err)
+
+	client, err := service.Take(context.Background(), 1)
+	require.NoError(t, err)
+	list, err := client.PermissionsService().List(file.Index().Buckets[0].FileID).Do()
+	require.NoError(t, err)
+
+	var emails []string
+	for _, permission := range list.Permissions {
+		assert.Equal(t, "commenter", permission.Role)
+		emails = append(emails, permission.EmailAddress)
+	}
+	assert.ElementsMatch(t, service.Emails(), emails)
+}
+
+func TestFilesQuery(t *testing.T) {
+	server, service := newService(t)
+	defer server.Close()
+
+	client, err := service.Take(context.Background(), 4)
+	require.NoError(t, err)
+
+	for _, name := range []string{"a", "b", "it's"} {
+		_, err := client.FilesService().Create(&drive.File{Name: name}).Do()
+		require.NoError(t, err)
+	}
+
+	list, err := client.FilesService().List().Q("name = 'it\\'s'").Do()
+	require.NoError(t, err)
+	require.Len(t, list.Files, 1)
+	assert.Equal(t, "it's", list.Files[0].Name)
+
+	list, err = client.FilesService().List().Q("name != 'a' and trashed = false").Do()
+	require.NoError(t, err)
+	assert.Len(t, list.Files, 2)
+}
@@ -4,8 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"hash"
 	"io"
-	"sort"
+	"time"
 
 	"github.com/google/uuid"
 
@@ -17,11 +18,31 @@ import (
 // single reply, encoded in UTF-8.
 const MaxReplySize = 4096
 
-// EffectiveReplySize is the size used per reply, as leading spaces are removed by Drive.
+// EffectiveReplySize is the size used per reply, as leading spaces are removed by Drive. The number of bytes of data
+// stored per reply depends on the expansion of the Codec of the file.
 const EffectiveReplySize = MaxReplySize - 2
 
 type FileHeader struct {
 	FileOptions `json:"o"`
+
+	// ReplySize is the number of bytes stored per reply. If absent, it is derived from the Codec assuming the
+	// DefaultLimits.
+	ReplySize int `json:"r,omitempty"`
+
+	// Manifest is set for files storing the state of their threads in a manifest, rather than in their ThreadHeaders.
+	Manifest bool `json:"m,omitempty"`
+
+	// Hash is the SHA-256 of the content of the file, as of the last Sync. Files created before hashing have none.
+	Hash *FileHash `json:"h,omitempty"`
+
+	// Encryption holds the wrapped data key of encrypted files.
+	Encryption *Encryption `json:"e,omitempty"`
+
+	// Sizes records the size of the content of compressed files, as of the last Sync.
+	Sizes *Sizes `json:"z,omitempty"`
+
+	// ModTime is the modification time set by SetModTimeCtx. It is cleared once the content of the file changes.
+	ModTime *time.Time `json:"mod,omitempty"`
 }
 
 func (f FileHeader) MustMarshall() []byte {
@@ -43,6 +64,33 @@ func FileHeaderFromJSON(p io.Reader) (*FileHeader, error) {
 
 type FileOptions struct {
 	NumThreads int
+
+	// Codec is the ID of the Codec used to encode the data in replies. Defaults to Raw, which is only suitable for
+	// text; use Base64 or Base85 for binary data. Densest probes the backend to select a codec.
+	Codec string `json:",omitempty"`
+
+	// Checksums stores the CRC-32C of the data of each reply alongside it, so reads detect replies altered by Drive.
+	// Reads of a reply which does not match its checksum fail with a ChecksumError.
+	Checksums bool `json:",omitempty"`
+
+	// ParityThreads is the number of threads holding Reed-Solomon parity of the NumThreads data threads. Reply s of
+	// a parity thread is computed from reply s of every data thread, so reads reconstruct chunks of up to
+	// ParityThreads lost or damaged threads. NumThreads and ParityThreads may add up to at most 256. Parity requires
+	// a Codec storing binary data.
+	ParityThreads int `json:",omitempty"`
+
+	// Key encrypts the data of the file using AES-256-GCM if set. Each reply is encrypted separately, so reads at any
+	// offset decrypt only the replies read. Encryption requires a Codec storing binary data. The Key is not stored;
+	// open the file using OpenWithKeyCtx.
+	Key Key `json:"-"`
+
+	// Compression is the ID of the Compression of the content, such as Gzip. Compressed files are compressed as a
+	// stream, so reads at an offset decompress the file from the start unless continuing the previous read, and data
+	// is only stored completely by Sync. The hash of the file covers the stored data.
+	Compression string `json:",omitempty"`
+
+	// Parent is the ID of the Drive folder the file is created in. The file is created in My Drive if empty.
+	Parent string `json:"-"`
 }
 
 func (f *FileOptions) setDefaults() {
@@ -52,29 +100,188 @@ func (f *FileOptions) setDefaults() {
 }
 
 func OpenCtx(ctx context.Context, file *drive.File, service Service) (*File, error) {
+	return OpenWithKeyCtx(ctx, file, service, nil)
+}
+
+// OpenWithKeyCtx opens a file, decrypting it using the key if it is encrypted. The key is ignored for files which are
+// not encrypted. Opening an encrypted file without a key fails with ErrNoKey.
+func OpenWithKeyCtx(ctx context.Context, file *drive.File, service Service, key Key) (*File, error) {
 	index, err := IndexFromFile(context.TODO(), service, file)
 	if err != nil {
 		return nil, fmt.Errorf("unable to index file: %w", err)
 	}
+	if len(index.Buckets) == 0 {
+		return nil, ErrMissingThreads
+	}
 
-	var writerlist = make([]*Thread, len(index.Buckets))
-	copy(writerlist, index.Buckets)
-	sort.Sort(byLengthThenNumber(writerlist))
+	var hashKeyed []byte
+	if e := index.Header.Encryption; e != nil {
+		dataKey, err := e.dataKey(key)
+		if err != nil {
+			return nil, err
+		}
+		s, err := newSealer(dataKey)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range index.Threads() {
+			t.sealer = s
+		}
+		hashKeyed = hashKey(dataKey)
+	}
+
+	// a pending batch may still be in flight, so it is only recovered once the file is altered. Until then, the file
+	// is read as last committed.
+	var pending *JournalRecord
+	if index.journal != nil && index.journal.record.Pending() {
+		record := index.journal.record
+		pending = &record
+	}
+
+	var size int64
+	for _, b := range index.Buckets {
+		size += b.size()
+	}
+
+	// writes continue hashing the content of the file from the stored state.
+	var digest hash.Hash
+	var hashed int64
+	if index.Header.Hash != nil {
+		digest, err = index.Header.Hash.restore(hashKeyed)
+		if err != nil {
+			return nil, err
+		}
+		hashed = index.Header.Hash.Size
+	}
+
+	var erasure *erasureCode
+	if len(index.Parity) > 0 {
+		erasure, err = newErasureCode(len(index.Buckets), len(index.Parity))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	compression, err := CompressionByID(index.Header.Compression)
+	if err != nil {
+		return nil, err
+	}
 
 	return &File{
-		file:    file,
-		index:   *index,
-		writers: newThreadRing(writerlist),
-		readers: newThreadRing(index.Buckets),
-		service: service,
+		file:      file,
+		index:     *index,
+		writers:   writerRing(index.Buckets),
+		service:   service,
+		replySize: index.Buckets[0].replySize,
+		pending:   pending,
+		hash:      digest,
+		hashed:    hashed,
+		hashKey:   hashKeyed,
+		erasure:   erasure,
+
+		compression: compression,
+		content:     contentOf(index.Header, size),
+		contentAt:   size,
 	}, nil
 }
 
+// writerRing returns the ring of threads written to, starting at the thread holding a partial last chunk, or else at
+// the thread of the next chunk.
+func writerRing(buckets []*Thread) *threadRing {
+	var replySize = int64(buckets[0].replySize)
+	var size int64
+	for _, b := range buckets {
+		size += b.size()
+	}
+	var next = (size + replySize - 1) / replySize
+	if size%replySize != 0 {
+		next--
+	}
+	var start = int(next % int64(len(buckets)))
+
+	var writerlist = make([]*Thread, 0, len(buckets))
+	writerlist = append(writerlist, buckets[start:]...)
+	writerlist = append(writerlist, buckets[:start]...)
+	return newThreadRing(writerlist)
+}
+
+// abandon deletes the Drive file of a file which could not be created, returning the error which caused it.
+func abandon(service Service, fileID string, err error) error {
+	client, limitErr := service.Take(context.TODO(), 1)
+	if limitErr != nil {
+		return fmt.Errorf("%w (%s)", err, limitErr)
+	}
+
+	deleteErr := client.FilesService().
+		Delete(fileID).
+		Fields("id").
+		Context(context.TODO()).
+		Do()
+	if deleteErr != nil {
+		return fmt.Errorf("%w (%s)", err, deleteErr)
+	}
+	return err
+}
+
 func CreateFileCtx(ctx context.Context, service Service, fileName string, options FileOptions) (*File, error) {
 	options.setDefaults()
 
-	var fileheader = FileHeader{FileOptions: options}
-	var buckets = make([]*Thread, options.NumThreads)
+	var buckets = make([]*Thread, options.NumThreads+options.ParityThreads)
+
+	var erasure *erasureCode
+	if options.ParityThreads > 0 {
+		var err error
+		erasure, err = newErasureCode(options.NumThreads, options.ParityThreads)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	compression, err := CompressionByID(options.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	var codec Codec
+	if options.Codec != Densest {
+		codec, err = CodecByID(options.Codec)
+		if err != nil {
+			return nil, err
+		}
+		if erasure != nil && codec.ID() == Raw {
+			return nil, fmt.Errorf("parity is binary data, which codec %s cannot store", Raw)
+		}
+		if options.Key != nil && codec.ID() == Raw {
+			return nil, fmt.Errorf("encrypted data is binary, which codec %s cannot store", Raw)
+		}
+	}
+
+	// the data key is generated and wrapped before creating any comment, as deriving the wrapping key takes time.
+	var encryption *Encryption
+	var sealed *sealer
+	var hashKeyed []byte
+	if options.Key != nil {
+		if len(buckets) > 1<<16 {
+			return nil, fmt.Errorf("encrypted files have at most %d threads", 1<<16)
+		}
+		var dataKey []byte
+		encryption, dataKey, err = newEncryption(options.Key)
+		if err != nil {
+			return nil, err
+		}
+		sealed, err = newSealer(dataKey)
+		if err != nil {
+			return nil, err
+		}
+		hashKeyed = hashKey(dataKey)
+		options.Key = nil
+	}
+
+	var parents []string
+	if options.Parent != "" {
+		parents = []string{options.Parent}
+		options.Parent = ""
+	}
 
 	client, err := service.Take(context.TODO(), 2)
 	if err != nil {
@@ -82,7 +289,7 @@ func CreateFileCtx(ctx context.Context, service Service, fileName string, option
 	}
 
 	file, err := client.FilesService().
-		Create(&drive.File{Name: fileName}).
+		Create(&drive.File{Name: fileName, Parents: parents}).
 		Fields("id").
 		Context(context.TODO()).
 		Do()
@@ -94,14 +301,45 @@ func CreateFileCtx(ctx context.Context, service Service, fileName string, option
 	if len(emails) > 1 {
 		err = ensurePermissionsSet(ctx, client, emails, file.Id)
 		if err != nil {
-			return nil, fmt.Errorf("unable to set permissions: %w", err)
+			return nil, abandon(service, file.Id, fmt.Errorf("unable to set permissions: %w", err))
+		}
+	}
+
+	var size int
+	if codec != nil {
+		if options.Checksums {
+			codec = withChecksum(codec)
+		}
+		size = replySize(codec)
+	} else {
+		codec, size, err = probeCodec(ctx, service, file.Id)
+		if err != nil {
+			return nil, abandon(service, file.Id, fmt.Errorf("unable to probe codec: %w", err))
+		}
+		options.Codec = codec.ID()
+		if options.Checksums {
+			// the checksum takes the place of data in the encoding which was probed.
+			size = fit(withChecksum(codec), codec.EncodedLen(size))
+			codec = withChecksum(codec)
 		}
 	}
+	if sealed != nil {
+		// the nonce and tag are stored alongside the data of each reply.
+		size -= sealOverhead
+	}
+
+	var digest = newContentHash(hashKeyed)
+	fileHash, err := newFileHash(digest, 0, hashKeyed)
+	if err != nil {
+		return nil, abandon(service, file.Id, err)
+	}
+	var fileheader = FileHeader{FileOptions: options, ReplySize: size, Manifest: true, Hash: fileHash, Encryption: encryption}
 
 	grp, ctx := errgroup.WithContext(context.TODO())
-	comments := make([]*drive.Comment, options.NumThreads)
+	comments := make([]*drive.Comment, len(buckets))
 
 	// create the file header itself.
+	var fileheaderID string
 	grp.Go(func() error {
 		return retry(ctx, func() error {
 			client, err := service.Take(context.TODO(), 1)
@@ -109,21 +347,39 @@ func CreateFileCtx(ctx context.Context, service Service, fileName string, option
 				return err
 			}
 
-			header := FileHeader{options}
-			_, err = client.CommentsService().
-				Create(file.Id, &drive.Comment{Content: string(header.MustMarshall())}).
+			comment, err := client.CommentsService().
+				Create(file.Id, &drive.Comment{Content: string(fileheader.MustMarshall())}).
 				Context(context.TODO()).Fields("id").
 				Do()
-			return err
+			if err != nil {
+				return err
+			}
+			fileheaderID = comment.Id
+			return nil
 		})
 	})
 
-	// create individual threads.
-	for i := 0; i < options.NumThreads; i++ {
+	// create the manifest holding the state of the threads.
+	var m *manifest
+	grp.Go(func() error {
+		var err error
+		m, err = createManifest(ctx, service, file.Id, len(buckets))
+		return err
+	})
+
+	// create the journal recording batches in flight.
+	var j *journal
+	grp.Go(func() error {
+		var err error
+		j, err = createJournal(ctx, service, file.Id, 0)
+		return err
+	})
 
-		i := i
-		grp.Go(func() error {
-			return retry(ctx, func() error {
+	// create individual threads, followed by the parity threads. Threads are created one at a time, as Reindex numbers
+	// threads of which the header is lost by the order in which they were created.
+	grp.Go(func() error {
+		for i := range buckets {
+			err := retry(ctx, func() error {
 				client, err := service.Take(context.TODO(), 1)
 				if err != nil {
 					return err
@@ -143,56 +399,73 @@ func CreateFileCtx(ctx context.Context, service Service, fileName string, option
 
 				comments[i] = comment
 				buckets[i] = &Thread{
-					FileID:    file.Id,
-					CommentID: comment.Id,
-					Header:    *header,
-					service:   service,
-					cursor:    0,
-					ri:        0,
-					replies:   nil,
-					oldState:  nil,
+					FileID:      file.Id,
+					CommentID:   comment.Id,
+					Header:      *header,
+					service:     service,
+					table:       &replyTable{},
+					deferHeader: true,
 				}
+				buckets[i].useCodec(codec, size)
+				buckets[i].sealer = sealed
 				return nil
 			})
-		})
-	}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 
 	err = grp.Wait()
 	if err != nil {
-		client, limitErr := service.Take(context.TODO(), 1)
-		if limitErr != nil {
-			return nil, fmt.Errorf("%w (%s)", err, limitErr)
-		}
-
-		deleteErr := client.FilesService().
-			Delete(file.Id).
-			Fields("id").
-			Context(context.TODO()).
-			Do()
-		if deleteErr != nil {
-			return nil, fmt.Errorf("%w (%s)", err, deleteErr)
-		}
-		return nil, err
+		return nil, abandon(service, file.Id, err)
 	}
 
 	return &File{
 		file: file,
 		index: Index{
-			Header:  fileheader,
-			Buckets: buckets,
+			Header:       fileheader,
+			Buckets:      buckets[:options.NumThreads],
+			Parity:       buckets[options.NumThreads:],
+			fileheaderID: fileheaderID,
+			manifest:     m,
+			journal:      j,
 		},
-		writers: newThreadRing(buckets),
-		readers: newThreadRing(buckets),
-		service: service,
+		writers:   newThreadRing(buckets[:options.NumThreads]),
+		service:   service,
+		replySize: size,
+		hash:      digest,
+		hashKey:   hashKeyed,
+		erasure:   erasure,
+
+		compression: compression,
 	}, nil
 }
 
 type File struct {
-	file    *drive.File
-	index   Index
-	writers *threadRing
-	readers *threadRing
-	service Service
+	file      *drive.File
+	index     Index
+	writers   *threadRing
+	service   Service
+	replySize int
+	offset    int64          // offset of the next Read.
+	pending   *JournalRecord // batch which was pending when the file was opened, recovered before altering the file.
+
+	hash       hash.Hash // hash of the first hashed bytes of the file, for files with a FileHash.
+	hashed     int64
+	readHash   hash.Hash // hash of the first readHashed bytes of the file, as read sequentially.
+	readHashed int64
+	hashKey    []byte // key prefixed to the content when hashing, for encrypted files.
+
+	erasure *erasureCode // code of the parity threads, if any.
+
+	compression  Compression    // compression of the content, if any.
+	compressor   io.WriteCloser // stream compressing writes until the next Sync.
+	stored       *storedWriter  // writer of the compressor.
+	decompressor *decompressor  // stream of the last read.
+	content      int64          // size of the content of compressed files; -1 if unknown.
+	contentAt    int64          // stored size at which content was last known to be current.
 }
 
 func (f *File) Service() Service {
@@ -203,6 +476,11 @@ func (f *File) Index() Index {
 	return f.index
 }
 
+// ReplySize returns the number of bytes stored per reply, which depends on the Codec of the file.
+func (f *File) ReplySize() int {
+	return f.replySize
+}
+
 type bounds struct {
 	lower int
 	upper int
@@ -14,3 +14,11 @@ func TestImplementsReader(t *testing.T) {
 func TestImplementsWriter(t *testing.T) {
 	assert.Implements(t, (*io.Writer)(nil), &File{}, "File should implement io.Writer")
 }
+
+func TestImplementsSeeker(t *testing.T) {
+	assert.Implements(t, (*io.Seeker)(nil), &File{}, "File should implement io.Seeker")
+}
+
+func TestImplementsReaderAt(t *testing.T) {
+	assert.Implements(t, (*io.ReaderAt)(nil), &File{}, "File should implement io.ReaderAt")
+}
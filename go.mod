@@ -1,11 +1,14 @@
 module github.com/kaiserkarel/drfs
 
-go 1.13
+go 1.16
 
 require (
+	bazil.org/fuse v0.0.0-20200117225306-7b5117fecadc
+	filippo.io/age v1.0.0
 	github.com/cenkalti/backoff/v4 v4.0.0
 	github.com/google/uuid v1.1.1
 	github.com/k0kubun/pp v3.0.1+incompatible
+	github.com/klauspost/compress v1.15.9
 	github.com/machinebox/progress v0.2.0
 	github.com/magiconair/properties v1.8.1
 	github.com/mattn/go-colorable v0.1.4 // indirect
@@ -15,8 +18,10 @@ require (
 	github.com/spf13/viper v1.6.1
 	github.com/stretchr/testify v1.4.0
 	github.com/udhos/equalfile v0.3.0
+	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
 	golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e
+	golang.org/x/sys v0.10.0 // indirect
 	golang.org/x/time v0.0.0-20191024005414-555d28b269f0
 	google.golang.org/api v0.15.0
 )
@@ -0,0 +1,286 @@
+package drfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// ErrHashMismatch is returned by reads reaching the end of a file of which the content does not match the hash in its
+// FileHeader.
+var ErrHashMismatch = errors.New("content hash mismatch")
+
+// FileHash is the SHA-256 of the first Size bytes of a file, keyed for encrypted files. The state of the hash is
+// stored alongside the sum, so writes appending to the file continue hashing without reading it. The state of
+// encrypted files is sealed, as it holds the key and any content which does not fill a block of the hash. The hash is
+// written by Sync; if a writer dies before, the hash covers a prefix of the file and the remainder is hashed by the
+// next Sync.
+type FileHash struct {
+	Size  int64  `json:"n"`
+	Sum   string `json:"s"`
+	State []byte `json:"st"`
+}
+
+// newContentHash returns the hash of the content of a file. The hash of encrypted files is keyed by prefixing the key.
+func newContentHash(key []byte) hash.Hash {
+	h := sha256.New()
+	_, _ = h.Write(key)
+	return h
+}
+
+// newHash returns the hash of the content of the file.
+func (f *File) newHash() hash.Hash {
+	return newContentHash(f.hashKey)
+}
+
+// hashStateLabel is authenticated alongside the sealed state of the hash of an encrypted file.
+const hashStateLabel = "drfs hash state"
+
+// newFileHash returns the FileHash of the size bytes hashed by h. The state is sealed using the key the content is
+// hashed with, for encrypted files.
+func newFileHash(h hash.Hash, size int64, key []byte) (*FileHash, error) {
+	state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("marshal hash: %w", err)
+	}
+	if key != nil {
+		state, err = wrapKey(derive(key, []byte(hashStateLabel)), state, hashStateLabel)
+		if err != nil {
+			return nil, fmt.Errorf("seal hash: %w", err)
+		}
+	}
+	return &FileHash{Size: size, Sum: hex.EncodeToString(h.Sum(nil)), State: state}, nil
+}
+
+// restore returns the hash of which the state is stored, unsealing it using the key of encrypted files.
+func (h *FileHash) restore(key []byte) (hash.Hash, error) {
+	var state = h.State
+	if key != nil {
+		var err error
+		state, err = unwrapKey(derive(key, []byte(hashStateLabel)), state, hashStateLabel)
+		if err != nil {
+			return nil, fmt.Errorf("unseal hash: %w", err)
+		}
+	}
+	d := sha256.New()
+	err := d.(encoding.BinaryUnmarshaler).UnmarshalBinary(state)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal hash: %w", err)
+	}
+	return d, nil
+}
+
+// hashOf returns the hash of the content of a file of the given size, or nil if the header holds no hash of its
+// current content.
+func hashOf(header FileHeader, size int64) []byte {
+	if header.Hash == nil || header.Hash.Size != size {
+		return nil
+	}
+	sum, err := hex.DecodeString(header.Hash.Sum)
+	if err != nil {
+		return nil
+	}
+	return sum
+}
+
+// hashWritten adds the data written at offset off to the hash of the file. Data is only hashed if it directly follows
+// the hashed data; else the hash is caught up by Sync.
+func (f *File) hashWritten(p []byte, off int64) {
+	if f.hash == nil || off != f.hashed {
+		return
+	}
+	_, _ = f.hash.Write(p)
+	f.hashed += int64(len(p))
+}
+
+// hashRead adds the data read sequentially at offset off to the hash verified at the end of the file. Reading from the
+// start of the file restarts hashing.
+func (f *File) hashRead(p []byte, off int64) {
+	if f.index.Header.Hash == nil {
+		return
+	}
+	if off == 0 {
+		f.readHash, f.readHashed = f.newHash(), 0
+	}
+	if f.readHash == nil || off != f.readHashed {
+		return
+	}
+	_, _ = f.readHash.Write(p)
+	f.readHashed += int64(len(p))
+}
+
+// verifyRead verifies the hash of the data read sequentially once the end of the file is reached. Files of which the
+// hash does not cover the current content, or which were not read from the start, are not verified.
+func (f *File) verifyRead() error {
+	if hashOf(f.index.Header, f.size()) == nil || f.readHash == nil || f.readHashed != f.index.Header.Hash.Size {
+		return io.EOF
+	}
+	// the data is verified once per pass over the file.
+	sum := f.readHash.Sum(nil)
+	f.readHash = nil
+	if hex.EncodeToString(sum) != f.index.Header.Hash.Sum {
+		return ErrHashMismatch
+	}
+	return io.EOF
+}
+
+// PrefixOf reports whether the content of the file is a prefix of the data read from r, such as to resume an upload
+// which was interrupted. Data covered by the hash in the FileHeader is compared against the hash, so only the data
+// written since the last Sync is read from the file. Compressed files hash their stored data, so their content is
+// read in full. If the content is a prefix, r is left positioned after it.
+func (f *File) PrefixOf(r io.Reader) (bool, error) {
+	size, err := f.length(context.Background())
+	if err != nil {
+		return false, err
+	}
+
+	var off int64
+	if recorded := f.index.Header.Hash; f.compression == nil && recorded != nil && recorded.Size <= size {
+		h := f.newHash()
+		if _, err := io.CopyN(h, r, recorded.Size); err != nil {
+			if err == io.EOF {
+				return false, nil
+			}
+			return false, err
+		}
+		if hex.EncodeToString(h.Sum(nil)) != recorded.Sum {
+			return false, nil
+		}
+		off = recorded.Size
+	}
+
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return false, err
+	}
+	var content = make([]byte, f.replySize*len(f.index.Buckets))
+	var data = make([]byte, len(content))
+	for off < size {
+		p := content
+		if remaining := size - off; remaining < int64(len(p)) {
+			p = p[:remaining]
+		}
+		n, err := io.ReadFull(f, p)
+		if err != nil {
+			return false, fmt.Errorf("read file to compare: %w", err)
+		}
+		_, err = io.ReadFull(r, data[:n])
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(p[:n], data[:n]) {
+			return false, nil
+		}
+		off += int64(n)
+	}
+	return true, nil
+}
+
+// Matches reports whether the content of the file equals the data read from r, such as to skip uploading an unchanged
+// file. Data covered by the hash in the FileHeader is hashed the way the file hashes its content, keyed for encrypted
+// files, so files of which the hash is in sync are not read at all. Compressed files are read in full, as PrefixOf
+// does.
+func (f *File) Matches(r io.Reader) (bool, error) {
+	ok, err := f.PrefixOf(r)
+	if err != nil || !ok {
+		return false, err
+	}
+	// the data must end where the content does.
+	_, err = io.ReadFull(r, make([]byte, 1))
+	if err == io.EOF {
+		return true, nil
+	}
+	return false, err
+}
+
+// syncHash writes the hash of the content of the file to the FileHeader. Data which was not hashed while writing is
+// read to catch up.
+func (f *File) syncHash(ctx context.Context) error {
+	if f.hash == nil {
+		return nil
+	}
+
+	var size = f.size()
+	if f.hashed > size {
+		// the file was truncated, so the hash is computed anew.
+		f.hash, f.hashed = f.newHash(), 0
+	}
+
+	var buf = make([]byte, f.replySize*len(f.index.Buckets))
+	for f.hashed < size {
+		p := buf
+		if remaining := size - f.hashed; remaining < int64(len(p)) {
+			p = p[:remaining]
+		}
+		n, err := f.readStoredAt(ctx, p, f.hashed)
+		if err != nil {
+			return fmt.Errorf("read file to hash: %w", err)
+		}
+		_, _ = f.hash.Write(p[:n])
+		f.hashed += int64(n)
+	}
+
+	// the size of the content of compressed files is recorded alongside the hash.
+	var sizes *Sizes
+	if f.compression != nil {
+		content, err := f.length(ctx)
+		if err != nil {
+			return err
+		}
+		sizes = &Sizes{Stored: size, Content: content}
+	}
+
+	var recorded = f.index.Header.Sizes
+	if f.index.Header.Hash != nil && f.index.Header.Hash.Size == f.hashed && (sizes == nil || recorded != nil && *sizes == *recorded) {
+		return nil
+	}
+
+	var header = f.index.Header
+	var err error
+	header.Hash, err = newFileHash(f.hash, f.hashed, f.hashKey)
+	if err != nil {
+		return err
+	}
+	header.Sizes = sizes
+	if f.index.Header.Hash == nil || f.index.Header.Hash.Size != f.hashed {
+		// the content changed, which updates the modification time.
+		header.ModTime = nil
+	}
+	return f.writeHeader(ctx, header)
+}
+
+// writeHeader replaces the FileHeader of the file.
+func (f *File) writeHeader(ctx context.Context, header FileHeader) error {
+	err := retry(ctx, func() error {
+		client, err := f.service.Take(ctx, 1)
+		if err != nil {
+			return err
+		}
+		_, err = client.CommentsService().
+			Update(f.file.Id, f.index.fileheaderID, &drive.Comment{Content: string(header.MustMarshall())}).
+			Fields("id").
+			Context(ctx).
+			Do()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("update fileheader: %w", err)
+	}
+	f.index.Header = header
+	return nil
+}
+
+// Close commits the file using Sync. Files hold no other resources.
+func (f *File) Close() error {
+	return f.Sync()
+}
@@ -0,0 +1,218 @@
+package drfs_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+	"github.com/kaiserkarel/drfs/fake"
+)
+
+func reopen(t *testing.T, service drfs.Service, file *drfs.File) *drfs.File {
+	stat, err := file.Fstat()
+	require.NoError(t, err)
+	reopened, err := drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+	require.NoError(t, err)
+	return reopened
+}
+
+func assertHash(t *testing.T, file *drfs.File, payload []byte) {
+	stat, err := file.Fstat()
+	require.NoError(t, err)
+	sum := sha256.Sum256(payload)
+	assert.Equal(t, sum[:], stat.Hash())
+}
+
+func TestFileHash(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+
+	file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: 3, Codec: drfs.Base85})
+	require.NoError(t, err)
+	assertHash(t, file, nil)
+
+	payload := binaryPayload(7*file.ReplySize() + 100)
+	_, err = file.WriteCtx(context.Background(), payload[:100])
+	require.NoError(t, err)
+	_, err = file.WriteCtx(context.Background(), payload[100:4*file.ReplySize()])
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	assertHash(t, file, payload[:4*file.ReplySize()])
+
+	// appends continue from the stored state of the hash.
+	reopened := reopen(t, service, file)
+	assertHash(t, reopened, payload[:4*file.ReplySize()])
+	_, err = reopened.WriteCtx(context.Background(), payload[4*file.ReplySize():])
+	require.NoError(t, err)
+	require.NoError(t, reopened.Sync())
+
+	reopened = reopen(t, service, file)
+	assertHash(t, reopened, payload)
+	got, err := ioutil.ReadAll(reopened)
+	require.NoError(t, err)
+	assert.Equal(t, payload, got)
+}
+
+func TestFileHashCatchUp(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+
+	file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: 2, Codec: drfs.Base64})
+	require.NoError(t, err)
+	payload := binaryPayload(3*file.ReplySize() + 10)
+	_, err = file.WriteCtx(context.Background(), payload)
+	require.NoError(t, err)
+
+	// the writer did not sync, so the hash covers none of the content.
+	reopened := reopen(t, service, file)
+	stat, err := reopened.Fstat()
+	require.NoError(t, err)
+	assert.Nil(t, stat.Hash())
+	got, err := ioutil.ReadAll(reopened)
+	require.NoError(t, err)
+	assert.Equal(t, payload, got)
+
+	require.NoError(t, reopened.Sync())
+	assertHash(t, reopen(t, service, file), payload)
+}
+
+func TestFileHashMismatch(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+
+	file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: 2})
+	require.NoError(t, err)
+	payload := strings.Repeat("lorem ipsum ", file.ReplySize())
+	_, err = file.WriteCtx(context.Background(), []byte(payload))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	// alter a reply without changing its length.
+	client, err := service.Take(context.Background(), 1)
+	require.NoError(t, err)
+	thread := file.Index().Buckets[1]
+	list, err := client.RepliesService().List(thread.FileID, thread.CommentID).Fields("*").Do()
+	require.NoError(t, err)
+	reply := list.Replies[0]
+	content := strings.Replace(reply.Content, "lorem", "ipsum", 1)
+	_, err = client.RepliesService().Update(thread.FileID, thread.CommentID, reply.Id, &drive.Reply{Content: content}).Do()
+	require.NoError(t, err)
+
+	reopened := reopen(t, service, file)
+	_, err = io.Copy(ioutil.Discard, reopened)
+	assert.True(t, errors.Is(err, drfs.ErrHashMismatch), err)
+
+	// reads which did not start at the beginning of the file are not verified.
+	_, err = reopened.Seek(10, io.SeekStart)
+	require.NoError(t, err)
+	_, err = io.Copy(ioutil.Discard, reopened)
+	assert.NoError(t, err)
+}
+
+func TestPrefixOf(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+
+	for _, compression := range []string{"", drfs.Gzip} {
+		file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: 3, Codec: drfs.Base85, Compression: compression})
+		require.NoError(t, err)
+		payload := binaryPayload(7*file.ReplySize() + 100)
+		_, err = file.WriteCtx(context.Background(), payload[:2*file.ReplySize()])
+		require.NoError(t, err)
+		require.NoError(t, file.Sync())
+
+		// the writer died before syncing, so the hash covers only a part of the content.
+		_, err = file.WriteCtx(context.Background(), payload[2*file.ReplySize():4*file.ReplySize()+10])
+		require.NoError(t, err)
+		if compression != "" {
+			// compressed streams are only readable once synced.
+			require.NoError(t, file.Sync())
+		}
+		reopened := reopen(t, service, file)
+
+		r := bytes.NewReader(payload)
+		ok, err := reopened.PrefixOf(r)
+		require.NoError(t, err)
+		assert.True(t, ok, compression)
+		rest, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, payload[4*file.ReplySize()+10:], rest)
+
+		ok, err = reopened.PrefixOf(bytes.NewReader(payload[:3*file.ReplySize()]))
+		require.NoError(t, err)
+		assert.False(t, ok, "the data is shorter than the file")
+
+		altered := append([]byte(nil), payload...)
+		altered[3*file.ReplySize()] ^= 0xff
+		ok, err = reopened.PrefixOf(bytes.NewReader(altered))
+		require.NoError(t, err)
+		assert.False(t, ok, "the data differs after the hashed prefix")
+
+		altered[3*file.ReplySize()] ^= 0xff
+		altered[10] ^= 0xff
+		ok, err = reopened.PrefixOf(bytes.NewReader(altered))
+		require.NoError(t, err)
+		assert.False(t, ok, "the data differs within the hashed prefix")
+	}
+}
+
+func TestMatches(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+
+	for _, options := range []drfs.FileOptions{
+		{NumThreads: 3, Codec: drfs.Base85},
+		{NumThreads: 3, Codec: drfs.Base85, Key: drfs.Passphrase("matches")},
+		{NumThreads: 3, Codec: drfs.Base85, Compression: drfs.Gzip},
+	} {
+		file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), options)
+		require.NoError(t, err)
+		payload := binaryPayload(4*file.ReplySize() + 100)
+		_, err = file.WriteCtx(context.Background(), payload)
+		require.NoError(t, err)
+		require.NoError(t, file.Sync())
+
+		ok, err := file.Matches(bytes.NewReader(payload))
+		require.NoError(t, err)
+		assert.True(t, ok)
+
+		ok, err = file.Matches(bytes.NewReader(payload[:len(payload)-1]))
+		require.NoError(t, err)
+		assert.False(t, ok, "the data is shorter than the file")
+
+		ok, err = file.Matches(bytes.NewReader(append(payload, 0)))
+		require.NoError(t, err)
+		assert.False(t, ok, "the data is longer than the file")
+
+		altered := append([]byte(nil), payload...)
+		altered[10] ^= 0xff
+		ok, err = file.Matches(bytes.NewReader(altered))
+		require.NoError(t, err)
+		assert.False(t, ok, "the data differs")
+	}
+}
@@ -6,23 +6,37 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/google/uuid"
 	"google.golang.org/api/drive/v3"
 )
 
 var (
 	ErrMissingFileHeader = errors.New("fileheader missing")
+
+	// ErrMissingThreads is returned when opening a file of which no thread is found.
+	ErrMissingThreads = errors.New("threads missing")
 )
 
 // Index describes the structure of a file.
 type Index struct {
 	Header  FileHeader
 	Buckets []*Thread
+	Parity  []*Thread // threads holding the parity of the buckets, if any.
+
+	fileheaderID string
+	manifest     *manifest
+	journal      *journal
 }
 
 // IndexFromFile queries the buckets from a file to generate an Index.
 func IndexFromFile(ctx context.Context, s Service, file *drive.File) (*Index, error) {
 	var fileheader *FileHeader
+	var fileheaderID string
 	var buckets []*Thread
+	var tables = make(map[uuid.UUID]string) // comment IDs of reply tables, by the UUID of their thread.
+	var manifestComment *drive.Comment
+	var manifestHeader *ManifestHeader
+	var j *journal
 
 	client, err := s.Take(ctx, 6) // 512 comments is the default per drfsFile. 100 pages per pagination means at most
 	// it will take 6 calls in the paginator
@@ -35,6 +49,32 @@ func IndexFromFile(ctx context.Context, s Service, file *drive.File) (*Index, er
 			payload := strings.NewReader(comment.Content)
 			threadheader, err := ThreadHeaderFromJSON(payload)
 			if err != nil {
+				// possibly the reply table of a thread.
+				if tableheader, tableErr := TableHeaderFromJSON(strings.NewReader(comment.Content)); tableErr == nil {
+					if _, ok := tables[tableheader.UUID]; !ok {
+						tables[tableheader.UUID] = comment.Id
+					}
+					continue
+				}
+
+				// possibly the manifest.
+				if header, manifestErr := ManifestHeaderFromJSON(strings.NewReader(comment.Content)); manifestErr == nil {
+					if manifestComment != nil {
+						return errors.New("multiple manifests")
+					}
+					manifestComment, manifestHeader = comment, header
+					continue
+				}
+
+				// possibly the journal.
+				if record, journalErr := JournalRecordFromJSON(strings.NewReader(comment.Content)); journalErr == nil {
+					if j != nil {
+						return errors.New("multiple journals")
+					}
+					j = &journal{commentID: comment.Id, record: *record}
+					continue
+				}
+
 				// possibly the file header. Check if we already encountered it. If so error anyway, else try to decode.
 				if fileheader != nil {
 					return err
@@ -45,6 +85,7 @@ func IndexFromFile(ctx context.Context, s Service, file *drive.File) (*Index, er
 				if err != nil {
 					return err
 				}
+				fileheaderID = comment.Id
 				continue
 			}
 
@@ -53,6 +94,7 @@ func IndexFromFile(ctx context.Context, s Service, file *drive.File) (*Index, er
 				CommentID: comment.Id,
 				service:   s,
 				Header:    *threadheader,
+				modTime:   parseTime(comment.ModifiedTime),
 			})
 		}
 		return nil
@@ -66,14 +108,84 @@ func IndexFromFile(ctx context.Context, s Service, file *drive.File) (*Index, er
 		return nil, ErrMissingFileHeader
 	}
 
+	codec, err := CodecByID(fileheader.Codec)
+	if err != nil {
+		return nil, err
+	}
+	if fileheader.Checksums {
+		codec = withChecksum(codec)
+	}
+
+	var size = fileheader.ReplySize
+	if size == 0 {
+		size = replySize(codec)
+	}
+
+	for _, bucket := range buckets {
+		bucket.useCodec(codec, size)
+		bucket.table = &replyTable{commentID: tables[bucket.Header.UUID]}
+	}
+
 	sort.Sort(byHeaderNumber(buckets))
 
+	// threads of files with parity might be lost, as these can be reconstructed. Lost threads take the state recorded
+	// in the manifest.
+	var numThreads = fileheader.NumThreads + fileheader.ParityThreads
+	if fileheader.ParityThreads > 0 && len(buckets) < numThreads {
+		var complete = make([]*Thread, 0, numThreads)
+		for i := 0; i < numThreads; i++ {
+			if len(buckets) > 0 && buckets[0].Header.Number == i {
+				complete = append(complete, buckets[0])
+				buckets = buckets[1:]
+				continue
+			}
+			lost := &Thread{FileID: file.Id, service: s, Header: ThreadHeader{Number: i}, table: &replyTable{}, lost: true}
+			lost.useCodec(codec, size)
+			complete = append(complete, lost)
+		}
+		buckets = append(complete, buckets...)
+	}
+
+	// files without a manifest store the state of each thread in its ThreadHeader.
+	var m *manifest
+	if fileheader.Manifest {
+		if manifestComment == nil {
+			return nil, ErrMissingManifest
+		}
+
+		m, err = readManifest(ctx, s, file.Id, manifestComment, *manifestHeader)
+		if err != nil {
+			return nil, err
+		}
+		err = m.apply(buckets)
+		if err != nil {
+			return nil, err
+		}
+		for _, bucket := range buckets {
+			bucket.deferHeader = true
+		}
+	}
+
+	var parity []*Thread
+	if fileheader.ParityThreads > 0 && len(buckets) >= numThreads {
+		buckets, parity = buckets[:fileheader.NumThreads], buckets[fileheader.NumThreads:]
+	}
+
 	return &Index{
-		Header:  *fileheader,
-		Buckets: buckets,
+		Header:       *fileheader,
+		Buckets:      buckets,
+		Parity:       parity,
+		fileheaderID: fileheaderID,
+		manifest:     m,
+		journal:      j,
 	}, nil
 }
 
+// Threads returns the buckets followed by the parity threads.
+func (i Index) Threads() []*Thread {
+	return append(append([]*Thread{}, i.Buckets...), i.Parity...)
+}
+
 type byHeaderNumber []*Thread
 
 func (s byHeaderNumber) Len() int {
@@ -0,0 +1,286 @@
+package drfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// JournalRecord is the content of the journal comment, recording the batch in flight. Before writing any reply, a batch
+// growing the file from Before to After bytes is recorded. The record is overwritten by the next batch, and cleared by
+// Sync by setting both to the size of the file, so a batch costs a single update of the journal. A file with a pending
+// record is recovered by RecoverCtx, which runs before the file is first altered; the record of a batch which was
+// committed is recovered by rolling it forward.
+//
+// As data is striped round-robin, the size of the file determines the length and capacity of every thread, so the
+// sizes suffice to tell which threads the batch altered.
+type JournalRecord struct {
+	Before int64 `json:"jb"`
+	After  int64 `json:"ja"`
+}
+
+// Pending reports whether the record describes a batch which was not committed.
+func (r JournalRecord) Pending() bool {
+	return r.Before != r.After
+}
+
+func (r JournalRecord) MustMarshall() []byte {
+	p, err := json.Marshal(r)
+	if err != nil {
+		panic(fmt.Errorf("marshaling journalrecord failed: %w", err))
+	}
+	return p
+}
+
+func JournalRecordFromJSON(p io.Reader) (*JournalRecord, error) {
+	dec := json.NewDecoder(p)
+	dec.DisallowUnknownFields()
+
+	record := &JournalRecord{}
+	err := dec.Decode(record)
+	return record, err
+}
+
+// journal is the local copy of the journal of a file.
+type journal struct {
+	commentID string
+	record    JournalRecord
+}
+
+// createJournal creates the journal of a file of the given size.
+func createJournal(ctx context.Context, s Service, fileID string, size int64) (*journal, error) {
+	var j = &journal{record: JournalRecord{Before: size, After: size}}
+	err := retry(ctx, func() error {
+		client, err := s.Take(ctx, 1)
+		if err != nil {
+			return err
+		}
+		comment, err := client.CommentsService().
+			Create(fileID, &drive.Comment{Content: string(j.record.MustMarshall())}).
+			Fields("id").
+			Context(ctx).
+			Do()
+		if err != nil {
+			return err
+		}
+		j.commentID = comment.Id
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create journal: %w", err)
+	}
+	return j, nil
+}
+
+// write the record to the journal.
+func (j *journal) write(ctx context.Context, s Service, fileID string, record JournalRecord) error {
+	err := retry(ctx, func() error {
+		client, err := s.Take(ctx, 1)
+		if err != nil {
+			return err
+		}
+		_, err = client.CommentsService().
+			Update(fileID, j.commentID, &drive.Comment{Content: string(record.MustMarshall())}).
+			Fields("id").
+			Context(ctx).
+			Do()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("update journal: %w", err)
+	}
+	j.record = record
+	return nil
+}
+
+// beginBatch records a batch growing the file from before to after bytes. Files without a journal are not recorded.
+func (f *File) beginBatch(ctx context.Context, before, after int64) error {
+	if f.index.journal == nil {
+		return nil
+	}
+	return f.index.journal.write(ctx, f.service, f.file.Id, JournalRecord{Before: before, After: after})
+}
+
+// endBatch clears the record of the last batch once the file is consistent at its current size. A record which was
+// pending when the file was opened is left to RecoverCtx, as its writer may still be alive.
+func (f *File) endBatch(ctx context.Context) error {
+	if f.index.journal == nil || f.pending != nil {
+		return nil
+	}
+	size := f.size()
+	record := JournalRecord{Before: size, After: size}
+	if f.index.journal.record == record {
+		return nil
+	}
+	return f.index.journal.write(ctx, f.service, f.file.Id, record)
+}
+
+// layout returns the length and capacity of every thread of a file of the given size. Chunk c of the file is stored in
+// thread c % numThreads.
+func layout(size int64, numThreads int, replySize int) []ThreadHeader {
+	var headers = make([]ThreadHeader, numThreads)
+	var chunks = (size + int64(replySize) - 1) / int64(replySize)
+	for i := range headers {
+		headers[i].Number = i
+		headers[i].Length = chunks / int64(numThreads)
+		if int64(i) < chunks%int64(numThreads) {
+			headers[i].Length++
+		}
+	}
+	if chunks > 0 {
+		last := (chunks - 1) % int64(numThreads)
+		headers[last].Capacity = int(chunks*int64(replySize) - size)
+	}
+	return headers
+}
+
+// RecoverCtx recovers the batch which was pending when the file was opened, if any. Opening a file does not recover
+// it, as the writer of the batch may still be alive: the file is read as last committed. Writes and rebuilding threads
+// recover the file first, so RecoverCtx only needs to be called to alter a file by other means, such as package
+// recovery.
+func (f *File) RecoverCtx(ctx context.Context) error {
+	if f.pending == nil {
+		return nil
+	}
+	changed, err := recoverBatch(ctx, f.service, f.file.Id, &f.index)
+	if err != nil {
+		return fmt.Errorf("unable to recover unfinished batch: %w", err)
+	}
+	var recovered = *f.pending
+	f.pending = nil
+	f.writers = writerRing(f.index.Buckets)
+	f.content, f.contentAt = contentOf(f.index.Header, f.size()), f.size()
+
+	// the parity of the stripes touched by the batch is computed anew, unless the batch was committed along with its
+	// parity.
+	if f.erasure != nil && changed {
+		err = f.rebuildParity(ctx, nil, f.stripeOf(recovered.Before))
+		if err == nil {
+			err = f.commit(ctx)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to recover parity: %w", err)
+		}
+	}
+	return nil
+}
+
+// recoverBatch recovers a file from a batch which was pending when the writer died. The threads altered by the batch
+// are inspected; if their replies form a prefix of the batch, it is rolled forward to include these. Otherwise it is
+// rolled back using RollbackCodecCtx. recoverBatch reports whether the committed state of a thread changed, which is
+// not the case for a batch which was committed.
+func recoverBatch(ctx context.Context, s Service, fileID string, index *Index) (bool, error) {
+	var record = index.journal.record
+	var numThreads = len(index.Buckets)
+	var replySize = index.Buckets[0].replySize
+	var before = layout(record.Before, numThreads, replySize)
+	var after = layout(record.After, numThreads, replySize)
+
+	var actual = make([]ThreadHeader, numThreads)
+	var replies = make([][]string, numThreads)
+	var size int64
+	for i, t := range index.Buckets {
+		actual[i] = t.Header
+		// lost threads cannot be inspected, and keep their committed state.
+		touched := before[i].Length != after[i].Length || before[i].Capacity != after[i].Capacity
+		if touched && !t.lost {
+			header, ids, err := inspectThread(ctx, s, fileID, t)
+			if err != nil {
+				return false, err
+			}
+			actual[i], replies[i] = header, ids
+		}
+		size += actual[i].Length*int64(replySize) - int64(actual[i].Capacity)
+	}
+
+	// the batch is rolled forward if the threads are laid out as a file of the size found.
+	var forward = size >= record.Before && size <= record.After
+	for i, header := range layout(size, numThreads, replySize) {
+		forward = forward && header.Length == actual[i].Length && header.Capacity == actual[i].Capacity
+	}
+
+	var changed bool
+	for i, t := range index.Buckets {
+		if forward || replies[i] == nil {
+			changed = changed || t.Header != actual[i]
+			t.Header = actual[i]
+			continue
+		}
+
+		target := t.Header
+		target.Length, target.Capacity, target.Tail = before[i].Length, before[i].Capacity, ""
+		if target.Length > 0 {
+			target.Tail = replies[i][target.Length-1]
+		}
+
+		if actual[i].Length < target.Length || actual[i].Length-target.Length > 1 {
+			return false, fmt.Errorf("thread %d has %d replies; expected %d or %d", t.Header.Number, actual[i].Length, target.Length, target.Length+1)
+		}
+		if actual[i] != target {
+			err := retry(ctx, func() error {
+				return RollbackCodecCtx(ctx, s, fileID, t.CommentID, t.codecAt(target.Length-1), target, actual[i])
+			})
+			if err != nil {
+				return false, fmt.Errorf("rollback thread %d: %w", t.Header.Number, err)
+			}
+		}
+		changed = changed || t.Header != target
+		t.Header = target
+	}
+
+	// only files with a manifest have a journal.
+	err := index.manifest.commit(ctx, s, fileID, index.Threads())
+	if err != nil {
+		return false, err
+	}
+
+	var recovered = record.Before
+	if forward {
+		recovered = size
+	}
+	return changed, index.journal.write(ctx, s, fileID, JournalRecord{Before: recovered, After: recovered})
+}
+
+// inspectThread derives the state of a thread from its replies, returning the reply IDs in order.
+func inspectThread(ctx context.Context, s Service, fileID string, t *Thread) (ThreadHeader, []string, error) {
+	var header = t.Header
+	var ids []string
+	var tail *drive.Reply
+	err := retry(ctx, func() error {
+		ids, tail = nil, nil
+		client, err := s.Take(ctx, int(t.Header.Length/MaxPages)+2)
+		if err != nil {
+			return err
+		}
+		return client.RepliesService().
+			List(fileID, t.CommentID).
+			PageSize(MaxPages).
+			Fields("*").
+			Pages(ctx, func(list *drive.ReplyList) error {
+				for _, reply := range list.Replies {
+					if !reply.Deleted {
+						ids = append(ids, reply.Id)
+						tail = reply
+					}
+				}
+				return nil
+			})
+	})
+	if err != nil {
+		return header, nil, fmt.Errorf("list replies of thread %d: %w", t.Header.Number, err)
+	}
+
+	header.Length, header.Tail, header.Capacity = int64(len(ids)), "", 0
+	if tail != nil {
+		data, err := t.Decode(header.Length-1, tail.Content)
+		if err != nil {
+			return header, nil, fmt.Errorf("decode reply %s: %w", tail.Id, err)
+		}
+		header.Tail = tail.Id
+		header.Capacity = t.replySize - len(data)
+	}
+	return header, ids, nil
+}
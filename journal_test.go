@@ -0,0 +1,251 @@
+package drfs_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+	"github.com/kaiserkarel/drfs/fake"
+)
+
+// crash simulates a writer dying during a batch. Once the reply writes to the applied threads are handled by the
+// backend, all further requests fail; the reply write to thread blocked fails without being handled.
+type crash struct {
+	mu      sync.Mutex
+	applied map[string]bool // comment IDs of threads.
+	blocked string
+	dead    bool
+}
+
+func (c *crash) rules() []*fake.Rule {
+	replies := func(r *http.Request, commentID string) bool {
+		return commentID != "" && strings.Contains(r.URL.Path, "/comments/"+commentID+"/replies")
+	}
+
+	return []*fake.Rule{
+		{Match: func(r *http.Request) bool {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return c.dead
+		}, Fault: fake.BadRequest, Probability: 1},
+		{Match: func(r *http.Request) bool {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return replies(r, c.blocked)
+		}, Fault: fake.BadRequest, Probability: 1},
+		{Match: func(r *http.Request) bool {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			for commentID := range c.applied {
+				if replies(r, commentID) && r.Method != http.MethodGet {
+					delete(c.applied, commentID)
+					c.dead = len(c.applied) == 0
+					return true
+				}
+			}
+			return false
+		}, Fault: fake.Partial(fake.BadRequest), Probability: 1},
+	}
+}
+
+// writeCrash writes two batches to a file with 2 threads, crashing during the second. The file is opened again, and
+// reads as it was before the second batch until it is recovered.
+func writeCrash(t *testing.T, payload []byte, applied, blocked int) *drfs.File {
+	file, _ := crashWrite(t, drfs.FileOptions{NumThreads: 2}, payload, 2, 100, []int{applied}, blocked)
+	return file
+}
+
+// crashWrite writes chunks replies and tail bytes of the payload to a new file, then crashes while writing the next
+// batch. The file is opened again, returning the number of bytes written by the first batch.
+func crashWrite(t *testing.T, options drfs.FileOptions, payload []byte, chunks, tail int, applied []int, blocked int) (*drfs.File, int) {
+	c := &crash{applied: map[string]bool{}}
+	server := fake.NewServer()
+	injector := fake.NewInjector(server.Client().Transport, c.rules()...)
+	service, err := fake.NewServiceWithTransport(context.Background(), server, injector)
+	require.NoError(t, err)
+	file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), options)
+	require.NoError(t, err)
+
+	first := chunks*file.ReplySize() + tail
+	_, err = file.WriteCtx(context.Background(), payload[:first])
+	require.NoError(t, err)
+
+	for _, i := range applied {
+		c.applied[file.Index().Buckets[i].CommentID] = true
+	}
+	c.blocked = file.Index().Buckets[blocked].CommentID
+	_, err = file.WriteBatch(context.Background(), payload[first:])
+	require.Error(t, err)
+	require.True(t, c.dead)
+
+	// the writer died; open the file using a fresh service.
+	service, err = fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+	_, err = file.Fstat()
+	require.Error(t, err, "the crashed writer should not be able to reach the backend")
+
+	client, err := service.Take(context.Background(), 1)
+	require.NoError(t, err)
+	list, err := client.FilesService().List().Do()
+	require.NoError(t, err)
+	require.Len(t, list.Files, 1)
+
+	reopened, err := drfs.OpenWithKeyCtx(context.Background(), list.Files[0], service, options.Key)
+	require.NoError(t, err)
+	stat, err := reopened.Fstat()
+	require.NoError(t, err)
+	require.Equal(t, int64(first), stat.Size())
+	return reopened, first
+}
+
+func TestJournalRollForward(t *testing.T) {
+	payload := randomPayload(4 * drfs.EffectiveReplySize)
+
+	// the append to the tail of thread 0 is applied, completing a prefix of the batch.
+	file := writeCrash(t, payload, 0, 1)
+	require.NoError(t, file.RecoverCtx(context.Background()))
+	size := 3 * drfs.EffectiveReplySize
+	assertConsistent(t, file, size)
+
+	buf := make([]byte, size)
+	_, err := io.ReadFull(file, buf)
+	require.NoError(t, err)
+	assert.Equal(t, payload[:size], buf)
+
+	_, err = file.WriteCtx(context.Background(), payload[size:])
+	require.NoError(t, err)
+	assertConsistent(t, file, len(payload))
+}
+
+func TestJournalRollBack(t *testing.T) {
+	payload := randomPayload(4 * drfs.EffectiveReplySize)
+
+	// the reply created in thread 1 does not follow the data in thread 0, so it is removed.
+	file := writeCrash(t, payload, 1, 0)
+	require.NoError(t, file.RecoverCtx(context.Background()))
+	size := 2*drfs.EffectiveReplySize + 100
+	assertConsistent(t, file, size)
+
+	buf := make([]byte, size)
+	_, err := io.ReadFull(file, buf)
+	require.NoError(t, err)
+	assert.Equal(t, payload[:size], buf)
+
+	_, err = file.WriteCtx(context.Background(), payload[size:])
+	require.NoError(t, err)
+	assertConsistent(t, file, len(payload))
+
+	buf = make([]byte, len(payload))
+	_, err = file.ReadAt(buf, 0)
+	require.NoError(t, err)
+	assert.Equal(t, payload, buf)
+}
+
+func TestJournalRollBackEncrypted(t *testing.T) {
+	payload := randomPayload(4 * drfs.EffectiveReplySize)
+	options := drfs.FileOptions{NumThreads: 3, Codec: drfs.Base85, Key: drfs.Passphrase("journal")}
+
+	// the append to the tail of thread 1 and the reply created in thread 0 skip thread 2, so both are rolled back. The
+	// tail of thread 1 is encrypted anew.
+	file, first := crashWrite(t, options, payload, 1, 100, []int{1, 0}, 2)
+	require.NoError(t, file.RecoverCtx(context.Background()))
+	assertConsistent(t, file, first)
+
+	stat, err := file.Fstat()
+	require.NoError(t, err)
+	reopened, err := drfs.OpenWithKeyCtx(context.Background(), stat.Sys().(*drive.File), file.Service(), options.Key)
+	require.NoError(t, err)
+	got, err := ioutil.ReadAll(reopened)
+	require.NoError(t, err)
+	assert.Equal(t, payload[:first], got)
+}
+
+func TestJournalOpenReadOnly(t *testing.T) {
+	payload := randomPayload(4 * drfs.EffectiveReplySize)
+	file := writeCrash(t, payload, 1, 0)
+	first := 2*drfs.EffectiveReplySize + 100
+
+	// reading does not recover the file, as the batch may still be in flight.
+	got, err := ioutil.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, payload[:first], got)
+
+	client, err := file.Service().Take(context.Background(), 1)
+	require.NoError(t, err)
+	thread := file.Index().Buckets[1]
+	list, err := client.RepliesService().List(thread.FileID, thread.CommentID).Do()
+	require.NoError(t, err)
+	assert.Len(t, list.Replies, 2, "the reply of the batch should be left in place")
+
+	// writing recovers the file first, rolling the batch back.
+	_, err = file.WriteCtx(context.Background(), payload[first:])
+	require.NoError(t, err)
+	assertConsistent(t, file, len(payload))
+	got = make([]byte, len(payload))
+	_, err = file.ReadAt(got, 0)
+	require.NoError(t, err)
+	assert.Equal(t, payload, got)
+}
+
+func TestJournalLayout(t *testing.T) {
+	server, _, file := newFile(t, 3)
+	defer server.Close()
+
+	for _, n := range []int{100, drfs.EffectiveReplySize - 100, 4 * drfs.EffectiveReplySize, 50} {
+		_, err := file.WriteCtx(context.Background(), randomPayload(n))
+		require.NoError(t, err)
+
+		// a consistent file is opened as is.
+		stat, err := file.Fstat()
+		require.NoError(t, err)
+		reopened, err := drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), file.Service())
+		require.NoError(t, err)
+		for i, bucket := range reopened.Index().Buckets {
+			assert.Equal(t, file.Index().Buckets[i].Header, bucket.Header)
+		}
+	}
+}
+
+func TestJournalCommittedBatch(t *testing.T) {
+	// files with a manifest only update comments to write the journal, besides Sync writing the file header.
+	var mu sync.Mutex
+	var updates int
+	isUpdate := fake.Match(http.MethodPatch, "comments")
+	server, _, file := newFile(t, 2, &fake.Rule{Match: func(r *http.Request) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if isUpdate(r) {
+			updates++
+		}
+		return false
+	}})
+	defer server.Close()
+
+	payload := randomPayload(10 * drfs.EffectiveReplySize)
+	_, err := file.WriteCtx(context.Background(), payload[:8*drfs.EffectiveReplySize])
+	require.NoError(t, err)
+	assert.Equal(t, 4, updates, "every batch should update the journal once")
+
+	// the record of the last batch is left pending; as the batch was committed, it is rolled forward.
+	stat, err := file.Fstat()
+	require.NoError(t, err)
+	reopened, err := drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), file.Service())
+	require.NoError(t, err)
+	_, err = reopened.WriteCtx(context.Background(), payload[8*drfs.EffectiveReplySize:])
+	require.NoError(t, err)
+	require.NoError(t, reopened.Sync())
+	assertConsistent(t, reopened, len(payload))
+
+	got, err := ioutil.ReadAll(reopened)
+	require.NoError(t, err)
+	assert.Equal(t, payload, got)
+}
@@ -0,0 +1,20 @@
+package drfs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// derive returns the HMAC-SHA256 of the label keyed by key.
+func derive(key []byte, label []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(label)
+	return mac.Sum(nil)
+}
+
+// stretch derives a 32 byte key from the passphrase using PBKDF2-HMAC-SHA256.
+func stretch(passphrase, salt []byte, iterations int) []byte {
+	return pbkdf2.Key(passphrase, salt, iterations, 32, sha256.New)
+}
@@ -0,0 +1,23 @@
+package drfs
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStretch(t *testing.T) {
+	// test vectors of PBKDF2-HMAC-SHA256 from RFC 7914, truncated to the 32 bytes of a key.
+	for _, c := range []struct {
+		password, salt string
+		iterations     int
+		key            string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	} {
+		key := stretch([]byte(c.password), []byte(c.salt), c.iterations)
+		assert.Equal(t, c.key[:64], hex.EncodeToString(key))
+	}
+}
@@ -0,0 +1,342 @@
+package drfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/api/drive/v3"
+)
+
+// ManifestEntries is the number of threads of which the state is stored per reply of the manifest.
+const ManifestEntries = 64
+
+var (
+	ErrMissingManifest = errors.New("manifest missing")
+)
+
+// ManifestHeader is the content of the manifest comment. The manifest holds the state of every thread, so it is
+// committed once per batch instead of writing the ThreadHeader after every reply. Reply k of the comment holds the
+// state of threads k*ManifestEntries up to (k+1)*ManifestEntries.
+type ManifestHeader struct {
+	Threads int `json:"mt"`
+}
+
+func (m ManifestHeader) MustMarshall() []byte {
+	p, err := json.Marshal(m)
+	if err != nil {
+		panic(fmt.Errorf("marshaling manifestheader failed: %w", err))
+	}
+	return p
+}
+
+func ManifestHeaderFromJSON(p io.Reader) (*ManifestHeader, error) {
+	dec := json.NewDecoder(p)
+	dec.DisallowUnknownFields()
+
+	header := &ManifestHeader{}
+	err := dec.Decode(header)
+	return header, err
+}
+
+// ManifestEntry is the state of a thread, as stored in the manifest.
+type ManifestEntry struct {
+	Length   int64  `json:"l"`
+	Tail     string `json:"t"`
+	Capacity int    `json:"c"`
+}
+
+func entryOf(h ThreadHeader) ManifestEntry {
+	return ManifestEntry{Length: h.Length, Tail: h.Tail, Capacity: h.Capacity}
+}
+
+// manifestPart is the content of a reply of the manifest.
+type manifestPart struct {
+	K       int             `json:"k"`
+	Entries []ManifestEntry `json:"e"`
+}
+
+func (p manifestPart) MustMarshall() []byte {
+	b, err := json.Marshal(p)
+	if err != nil {
+		panic(fmt.Errorf("marshaling manifest failed: %w", err))
+	}
+	return b
+}
+
+// manifest is the local copy of the manifest of a file.
+type manifest struct {
+	commentID string
+	parts     []string        // IDs of the replies of the manifest.
+	entries   []ManifestEntry // committed state, by thread number.
+}
+
+// createManifest creates the manifest of a file with the given number of empty threads.
+func createManifest(ctx context.Context, s Service, fileID string, threads int) (*manifest, error) {
+	var m = &manifest{entries: make([]ManifestEntry, threads)}
+
+	err := retry(ctx, func() error {
+		client, err := s.Take(ctx, 1)
+		if err != nil {
+			return err
+		}
+		comment, err := client.CommentsService().
+			Create(fileID, &drive.Comment{Content: string(ManifestHeader{Threads: threads}.MustMarshall())}).
+			Fields("id").
+			Context(ctx).
+			Do()
+		if err != nil {
+			return err
+		}
+		m.commentID = comment.Id
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create manifest: %w", err)
+	}
+
+	// parts are created in order, as their order in the comment identifies them.
+	for k := 0; k*ManifestEntries < threads; k++ {
+		var reply *drive.Reply
+		err := retry(ctx, func() error {
+			client, err := s.Take(ctx, 1)
+			if err != nil {
+				return err
+			}
+			reply, err = client.RepliesService().
+				Create(fileID, m.commentID, &drive.Reply{Content: string(m.part(k).MustMarshall())}).
+				Fields("id").
+				Context(ctx).
+				Do()
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("create manifest: %w", err)
+		}
+		m.parts = append(m.parts, reply.Id)
+	}
+	return m, nil
+}
+
+// readManifest parses the manifest from its comment, listing its replies if these are not included in the comment.
+func readManifest(ctx context.Context, s Service, fileID string, comment *drive.Comment, header ManifestHeader) (*manifest, error) {
+	var numparts = (header.Threads + ManifestEntries - 1) / ManifestEntries
+
+	var replies = comment.Replies
+	if len(replies) < numparts {
+		replies = nil
+		err := retry(ctx, func() error {
+			client, err := s.Take(ctx, 1)
+			if err != nil {
+				return err
+			}
+			replies = nil
+			return client.RepliesService().
+				List(fileID, comment.Id).
+				PageSize(MaxPages).
+				Fields("*").
+				Pages(ctx, func(list *drive.ReplyList) error {
+					replies = append(replies, list.Replies...)
+					return nil
+				})
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list manifest: %w", err)
+		}
+	}
+
+	var m = &manifest{commentID: comment.Id}
+	for _, reply := range replies {
+		if reply.Deleted || len(m.parts) == numparts {
+			continue
+		}
+
+		var part manifestPart
+		dec := json.NewDecoder(strings.NewReader(reply.Content))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&part); err != nil {
+			return nil, fmt.Errorf("decode manifest: %w", err)
+		}
+		if part.K != len(m.parts) {
+			return nil, fmt.Errorf("manifest reply %s holds part %d; expected %d", reply.Id, part.K, len(m.parts))
+		}
+
+		m.parts = append(m.parts, reply.Id)
+		m.entries = append(m.entries, part.Entries...)
+	}
+
+	if len(m.parts) != numparts || len(m.entries) != header.Threads {
+		return nil, fmt.Errorf("manifest holds %d threads in %d parts; expected %d", len(m.entries), len(m.parts), header.Threads)
+	}
+	return m, nil
+}
+
+// part returns part k of the committed state.
+func (m *manifest) part(k int) manifestPart {
+	upper := (k + 1) * ManifestEntries
+	if upper > len(m.entries) {
+		upper = len(m.entries)
+	}
+	return manifestPart{K: k, Entries: m.entries[k*ManifestEntries : upper]}
+}
+
+// apply sets the state of the threads, ordered by number, to the committed state.
+func (m *manifest) apply(threads []*Thread) error {
+	if len(threads) != len(m.entries) {
+		return fmt.Errorf("manifest holds %d threads; found %d", len(m.entries), len(threads))
+	}
+
+	for i, thread := range threads {
+		if thread.Header.Number != i {
+			return fmt.Errorf("thread %d is missing", i)
+		}
+		entry := m.entries[i]
+		thread.Header.Length = entry.Length
+		thread.Header.Tail = entry.Tail
+		thread.Header.Capacity = entry.Capacity
+	}
+	return nil
+}
+
+// commit writes the state of the threads, ordered by number, to the manifest. Only the parts holding altered threads
+// are updated. Parts are updated concurrently, so a failed commit might be partially applied.
+func (m *manifest) commit(ctx context.Context, s Service, fileID string, threads []*Thread) error {
+	grp, ctx := errgroup.WithContext(ctx)
+	for k := range m.parts {
+		k := k
+		lower := k * ManifestEntries
+		upper := lower + len(m.part(k).Entries)
+
+		var entries = make([]ManifestEntry, 0, upper-lower)
+		var altered bool
+		for i := lower; i < upper; i++ {
+			entry := entryOf(threads[i].Header)
+			altered = altered || entry != m.entries[i]
+			entries = append(entries, entry)
+		}
+		if !altered {
+			continue
+		}
+
+		content := string(manifestPart{K: k, Entries: entries}.MustMarshall())
+		grp.Go(func() error {
+			err := retry(ctx, func() error {
+				client, err := s.Take(ctx, 1)
+				if err != nil {
+					return err
+				}
+				_, err = client.RepliesService().
+					Update(fileID, m.commentID, m.parts[k], &drive.Reply{Content: content}).
+					Fields("id").
+					Context(ctx).
+					Do()
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("update manifest: %w", err)
+			}
+			copy(m.entries[lower:upper], entries)
+			return nil
+		})
+	}
+	return grp.Wait()
+}
+
+// Sync commits the state of the threads to the manifest of the file, and the hash of its content to the FileHeader.
+// Writes commit the manifest once per batch, but the hash is only written by Sync.
+func (f *File) Sync() error {
+	return f.SyncCtx(context.Background())
+}
+
+// SyncCtx commits the file using the provided context for API calls.
+func (f *File) SyncCtx(ctx context.Context) error {
+	err := f.flushCompressed(ctx)
+	if err != nil {
+		return err
+	}
+	err = f.commit(ctx)
+	if err != nil {
+		return err
+	}
+	err = f.flushTables(ctx, true)
+	if err != nil {
+		return err
+	}
+	err = f.endBatch(ctx)
+	if err != nil {
+		return err
+	}
+	return f.syncHash(ctx)
+}
+
+// commit the state of the threads to the manifest. commit is a no-op for files without a manifest.
+func (f *File) commit(ctx context.Context) error {
+	if f.index.manifest == nil {
+		return nil
+	}
+	return f.index.manifest.commit(ctx, f.service, f.file.Id, f.index.Threads())
+}
+
+// AddManifestCtx converts a file storing the state of its threads in their ThreadHeaders to one storing it in a
+// manifest, recording batches in a journal as files created by CreateFileCtx do. The manifest and journal are created
+// before the FileHeader is updated, so an interrupted conversion leaves the file storing the state in its ThreadHeaders;
+// Reindex deletes the manifest it left behind. AddManifestCtx is a no-op for files which have a manifest.
+func (f *File) AddManifestCtx(ctx context.Context) error {
+	if f.index.manifest != nil {
+		return nil
+	}
+	if err := f.flushCompressed(ctx); err != nil {
+		return err
+	}
+
+	var threads = f.index.Threads()
+	m, err := createManifest(ctx, f.service, f.file.Id, len(threads))
+	if err != nil {
+		return err
+	}
+	if err := m.commit(ctx, f.service, f.file.Id, threads); err != nil {
+		return err
+	}
+	var j = f.index.journal
+	if j == nil {
+		j, err = createJournal(ctx, f.service, f.file.Id, f.size())
+	} else {
+		err = j.write(ctx, f.service, f.file.Id, JournalRecord{Before: f.size(), After: f.size()})
+	}
+	if err != nil {
+		return err
+	}
+
+	var header = f.index.Header
+	header.Manifest = true
+	if err := f.writeHeader(ctx, header); err != nil {
+		return err
+	}
+	f.index.manifest = m
+	f.index.journal = j
+	return nil
+}
+
+// flushTables writes the IDs of new replies to the reply tables of the threads. Unless all is set, only the replies
+// of tables which are full are written.
+func (f *File) flushTables(ctx context.Context, all bool) error {
+	grp, ctx := errgroup.WithContext(ctx)
+	for _, t := range f.index.Threads() {
+		t := t
+		if t.lost {
+			continue
+		}
+		grp.Go(func() error {
+			err := t.flushTable(ctx, all)
+			if err != nil {
+				return fmt.Errorf("thread %d: %w", t.Header.Number, err)
+			}
+			return nil
+		})
+	}
+	return grp.Wait()
+}
@@ -0,0 +1,186 @@
+package drfs_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+	"github.com/kaiserkarel/drfs/fake"
+)
+
+// newLegacyFile creates a file as written before manifests were introduced, storing the state of each thread in its
+// ThreadHeader.
+func newLegacyFile(t *testing.T, numThreads int, rules ...*fake.Rule) (*fake.Server, *fake.Injector, *drfs.File) {
+	server := fake.NewServer()
+	injector := fake.NewInjector(server.Client().Transport, rules...)
+	service, err := fake.NewServiceWithTransport(context.Background(), server, injector)
+	require.NoError(t, err)
+
+	client, err := service.Take(context.Background(), 1)
+	require.NoError(t, err)
+	file, err := client.FilesService().Create(&drive.File{Name: t.Name()}).Do()
+	require.NoError(t, err)
+
+	header := drfs.FileHeader{FileOptions: drfs.FileOptions{NumThreads: numThreads}}
+	_, err = client.CommentsService().Create(file.Id, &drive.Comment{Content: string(header.MustMarshall())}).Do()
+	require.NoError(t, err)
+	for i := 0; i < numThreads; i++ {
+		header := drfs.ThreadHeader{Number: i, UUID: uuid.New()}
+		_, err = client.CommentsService().Create(file.Id, &drive.Comment{Content: string(header.MustMarshall())}).Do()
+		require.NoError(t, err)
+	}
+
+	f, err := drfs.OpenCtx(context.Background(), file, service)
+	require.NoError(t, err)
+	return server, injector, f
+}
+
+// manifestID returns the ID of the manifest comment of the file.
+func manifestID(t *testing.T, file *drfs.File) string {
+	stat, err := file.Fstat()
+	require.NoError(t, err)
+
+	client, err := file.Service().Take(context.Background(), 1)
+	require.NoError(t, err)
+	list, err := client.CommentsService().List(stat.ID()).Fields("*").Do()
+	require.NoError(t, err)
+	for _, comment := range list.Comments {
+		if _, err := drfs.ManifestHeaderFromJSON(strings.NewReader(comment.Content)); err == nil {
+			return comment.Id
+		}
+	}
+	t.Fatal("manifest missing")
+	return ""
+}
+
+func TestManifestLegacyFile(t *testing.T) {
+	server, _, file := newLegacyFile(t, 3)
+	defer server.Close()
+	assert.False(t, file.Index().Header.Manifest)
+
+	payload := randomPayload(5*drfs.EffectiveReplySize + 10)
+	_, err := file.WriteCtx(context.Background(), payload)
+	require.NoError(t, err)
+	assertConsistent(t, file, len(payload))
+
+	stat, err := file.Fstat()
+	require.NoError(t, err)
+	reopened, err := drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), file.Service())
+	require.NoError(t, err)
+
+	buf := make([]byte, len(payload))
+	_, err = io.ReadFull(reopened, buf)
+	require.NoError(t, err)
+	assert.Equal(t, payload, buf)
+}
+
+func TestManifestLegacyFileWithoutThreads(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+
+	client, err := service.Take(context.Background(), 1)
+	require.NoError(t, err)
+	file, err := client.FilesService().Create(&drive.File{Name: t.Name()}).Do()
+	require.NoError(t, err)
+	header := drfs.FileHeader{FileOptions: drfs.FileOptions{NumThreads: 3}}
+	_, err = client.CommentsService().Create(file.Id, &drive.Comment{Content: string(header.MustMarshall())}).Do()
+	require.NoError(t, err)
+
+	_, err = drfs.OpenCtx(context.Background(), file, service)
+	assert.True(t, errors.Is(err, drfs.ErrMissingThreads))
+}
+
+func TestManifestCommitsOncePerBatch(t *testing.T) {
+	var headerUpdates int
+	var manifestUpdates int
+	var manifest string
+	var threads = make(map[string]bool)
+	server, _, file := newFile(t, 4, &fake.Rule{
+		Match: func(r *http.Request) bool {
+			if fake.Match(http.MethodPatch, "comments")(r) && threads[r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]] {
+				headerUpdates++
+			}
+			if r.Method == http.MethodPatch && manifest != "" && strings.Contains(r.URL.Path, "/comments/"+manifest+"/") {
+				manifestUpdates++
+			}
+			return false
+		},
+	})
+	defer server.Close()
+	manifest = manifestID(t, file)
+	for _, thread := range file.Index().Buckets {
+		threads[thread.CommentID] = true
+	}
+
+	payload := randomPayload(8*drfs.EffectiveReplySize + 10)
+	_, err := file.WriteCtx(context.Background(), payload)
+	require.NoError(t, err)
+
+	assert.Equal(t, 0, headerUpdates, "ThreadHeaders should not be written")
+	assert.Equal(t, 3, manifestUpdates, "the manifest should be committed once per batch")
+	assertConsistent(t, file, len(payload))
+}
+
+func TestManifestCommitFailure(t *testing.T) {
+	var manifest string
+	server, _, file := newFile(t, 4, &fake.Rule{
+		Match: func(r *http.Request) bool {
+			return r.Method == http.MethodPatch && manifest != "" && strings.Contains(r.URL.Path, "/comments/"+manifest+"/")
+		},
+		Fault: fake.BadRequest,
+		Calls: []int{2},
+	})
+	defer server.Close()
+	manifest = manifestID(t, file)
+
+	payload := randomPayload(6 * drfs.EffectiveReplySize)
+	n, err := file.WriteBatch(context.Background(), payload)
+	require.NoError(t, err)
+	assert.Equal(t, 4*drfs.EffectiveReplySize, n)
+
+	// the writes of the batch are rolled back, as the manifest does not refer to them.
+	n, err = file.WriteBatch(context.Background(), payload[n:])
+	require.Error(t, err)
+	assert.Equal(t, 0, n)
+	assertConsistent(t, file, 4*drfs.EffectiveReplySize)
+
+	_, err = file.WriteCtx(context.Background(), payload[4*drfs.EffectiveReplySize:])
+	require.NoError(t, err)
+	assertConsistent(t, file, len(payload))
+
+	buf := make([]byte, len(payload))
+	_, err = io.ReadFull(file, buf)
+	require.NoError(t, err)
+	assert.Equal(t, payload, buf)
+}
+
+func TestManifestHeaderKeys(t *testing.T) {
+	// comments are told apart by the keys of their JSON, so a FileHeader shares none with a ManifestHeader.
+	var keys = func(p []byte) map[string]json.RawMessage {
+		var m map[string]json.RawMessage
+		require.NoError(t, json.Unmarshal(p, &m))
+		return m
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	fileheader := keys(drfs.FileHeader{FileOptions: drfs.FileOptions{NumThreads: 1}, ModTime: &mtime}.MustMarshall())
+	for key := range keys(drfs.ManifestHeader{Threads: 1}.MustMarshall()) {
+		assert.NotContains(t, fileheader, key)
+	}
+
+	header, err := drfs.FileHeaderFromJSON(strings.NewReader(string(drfs.FileHeader{ModTime: &mtime}.MustMarshall())))
+	require.NoError(t, err)
+	assert.Equal(t, mtime, *header.ModTime)
+}
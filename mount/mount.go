@@ -0,0 +1,303 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+// Package mount serves the namespace of package os as a FUSE filesystem, so that drfs files can be used by any
+// program. Directories are Drive folders and files are drfs files, sized by their index.
+//
+// Reads may be sequential or ranged. Writes are append-only, as drfs files cannot be overwritten in place: a write
+// must start at the end of the file. Rewriting a whole file works, as opening it with O_TRUNC empties it first.
+package mount
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+
+	"github.com/kaiserkarel/drfs"
+	drfsos "github.com/kaiserkarel/drfs/os"
+)
+
+// FS is the filesystem rooted at drfsos.Root.
+type FS struct{}
+
+var _ fs.FS = FS{}
+
+func (FS) Root() (fs.Node, error) {
+	return &Dir{}, nil
+}
+
+// Dir is a Drive folder, named by its path relative to drfsos.Root.
+type Dir struct {
+	path string
+}
+
+var (
+	_ fs.Node               = (*Dir)(nil)
+	_ fs.NodeStringLookuper = (*Dir)(nil)
+	_ fs.HandleReadDirAller = (*Dir)(nil)
+	_ fs.NodeMkdirer        = (*Dir)(nil)
+	_ fs.NodeCreater        = (*Dir)(nil)
+	_ fs.NodeRemover        = (*Dir)(nil)
+	_ fs.NodeRenamer        = (*Dir)(nil)
+	_ fs.NodeSetattrer      = (*Dir)(nil)
+)
+
+func (d *Dir) Attr(ctx context.Context, attr *fuse.Attr) error {
+	attr.Mode = os.ModeDir | 0755
+	if d.path == "" {
+		return nil
+	}
+	info, err := drfsos.Stat(d.path)
+	if err != nil {
+		return errno(err)
+	}
+	attr.Mtime = info.ModTime()
+	return nil
+}
+
+func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	info, err := drfsos.Stat(d.join(name))
+	if err != nil {
+		return nil, errno(err)
+	}
+	return node(d.join(name), info), nil
+}
+
+func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	infos, err := drfsos.ReadDir(d.path)
+	if err != nil {
+		return nil, errno(err)
+	}
+
+	var dirents = make([]fuse.Dirent, len(infos))
+	for i, info := range infos {
+		dirents[i] = fuse.Dirent{Name: info.Name(), Type: fuse.DT_File}
+		if info.IsDir() {
+			dirents[i].Type = fuse.DT_Dir
+		}
+	}
+	return dirents, nil
+}
+
+func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error) {
+	if err := drfsos.Mkdir(d.join(req.Name), req.Mode); err != nil {
+		return nil, errno(err)
+	}
+	return &Dir{path: d.join(req.Name)}, nil
+}
+
+func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
+	file := &File{path: d.join(req.Name)}
+	h, err := file.open(openFlag(req.Flags) | drfsos.O_CREATE)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, h, nil
+}
+
+func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	return errno(drfsos.Remove(d.join(req.Name)))
+}
+
+func (d *Dir) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Node) error {
+	target, ok := newDir.(*Dir)
+	if !ok {
+		return fuse.Errno(syscall.EXDEV)
+	}
+	return errno(drfsos.Rename(d.join(req.OldName), target.join(req.NewName)))
+}
+
+func (d *Dir) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	if req.Valid.Mtime() && d.path != "" {
+		if err := drfsos.Chtimes(d.path, req.Mtime, req.Mtime); err != nil {
+			return errno(err)
+		}
+	}
+	return d.Attr(ctx, &resp.Attr)
+}
+
+func (d *Dir) join(name string) string {
+	return path.Join(d.path, name)
+}
+
+// File is a drfs file, named by its path relative to drfsos.Root. Its attributes are those of the last Stat, updated
+// by writes through its handles.
+type File struct {
+	path string
+
+	mu    sync.Mutex
+	size  int64
+	mtime time.Time
+}
+
+var (
+	_ fs.Node          = (*File)(nil)
+	_ fs.NodeOpener    = (*File)(nil)
+	_ fs.NodeSetattrer = (*File)(nil)
+)
+
+func (f *File) Attr(ctx context.Context, attr *fuse.Attr) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	attr.Mode = 0644
+	attr.Size = uint64(f.size)
+	attr.Mtime = f.mtime
+	return nil
+}
+
+func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	return f.open(openFlag(req.Flags))
+}
+
+// Setattr truncates the file, and sets its modification time. Drive files have no permission bits or owners, so
+// other attributes are ignored.
+func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	if req.Valid.Size() {
+		if err := drfsos.Truncate(f.path, int64(req.Size)); err != nil {
+			return errno(err)
+		}
+		f.mu.Lock()
+		f.size, f.mtime = int64(req.Size), time.Now()
+		f.mu.Unlock()
+	}
+	if req.Valid.Mtime() {
+		if err := drfsos.Chtimes(f.path, req.Mtime, req.Mtime); err != nil {
+			return errno(err)
+		}
+		f.mu.Lock()
+		f.mtime = req.Mtime
+		f.mu.Unlock()
+	}
+	return f.Attr(ctx, &resp.Attr)
+}
+
+func (f *File) open(flag int) (*Handle, error) {
+	file, err := drfsos.OpenFile(f.path, flag, 0644)
+	if err != nil {
+		return nil, errno(err)
+	}
+	info, err := file.Fstat()
+	if err != nil {
+		return nil, errno(err)
+	}
+
+	f.mu.Lock()
+	f.size, f.mtime = info.Size(), info.ModTime()
+	f.mu.Unlock()
+	return &Handle{node: f, file: file, size: info.Size(), writable: flag&(drfsos.O_WRONLY|drfsos.O_RDWR) != 0}, nil
+}
+
+// Handle is an open File. Writes must start at the end of the file, as they are appended to it.
+type Handle struct {
+	node *File
+
+	mu       sync.Mutex
+	file     *drfsos.File
+	size     int64
+	writable bool
+}
+
+var (
+	_ fs.HandleReader   = (*Handle)(nil)
+	_ fs.HandleWriter   = (*Handle)(nil)
+	_ fs.HandleFlusher  = (*Handle)(nil)
+	_ fs.HandleReleaser = (*Handle)(nil)
+)
+
+func (h *Handle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var buf = make([]byte, req.Size)
+	n, err := h.file.ReadAt(buf, req.Offset)
+	if err != nil && err != io.EOF {
+		return errno(err)
+	}
+	resp.Data = buf[:n]
+	return nil
+}
+
+func (h *Handle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if req.Offset != h.size {
+		return fuse.Errno(syscall.ENOTSUP)
+	}
+	n, err := h.file.Write(req.Data)
+	h.size += int64(n)
+	resp.Size = n
+
+	h.node.mu.Lock()
+	h.node.size, h.node.mtime = h.size, time.Now()
+	h.node.mu.Unlock()
+	return errno(err)
+}
+
+// Flush commits the writes to the file, such as when it is closed.
+func (h *Handle) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.writable {
+		return nil
+	}
+	return errno(h.file.Sync())
+}
+
+func (h *Handle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return errno(h.file.Close())
+}
+
+// openFlag returns the flag for drfsos.OpenFile of the flags of a FUSE request.
+func openFlag(flags fuse.OpenFlags) int {
+	var flag = drfsos.O_RDONLY
+	switch {
+	case flags.IsWriteOnly():
+		flag = drfsos.O_WRONLY
+	case flags.IsReadWrite():
+		flag = drfsos.O_RDWR
+	}
+	if flags&fuse.OpenTruncate != 0 {
+		flag |= drfsos.O_TRUNC
+	}
+	if flags&fuse.OpenExclusive != 0 {
+		flag |= drfsos.O_EXCL
+	}
+	return flag
+}
+
+func node(name string, info drfs.FileInfo) fs.Node {
+	if info.IsDir() {
+		return &Dir{path: name}
+	}
+	return &File{path: name, size: info.Size(), mtime: info.ModTime()}
+}
+
+// errno maps the errors of package os onto the errno returned to the kernel. Errors which are no syscall.Errno, such
+// as those of the Drive API, become EIO.
+func errno(err error) error {
+	var e syscall.Errno
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &e):
+		return fuse.Errno(e)
+	case errors.Is(err, os.ErrNotExist):
+		return fuse.ENOENT
+	case errors.Is(err, os.ErrExist):
+		return fuse.EEXIST
+	default:
+		return fuse.EIO
+	}
+}
@@ -0,0 +1,117 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package mount
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs/fake"
+	drfsos "github.com/kaiserkarel/drfs/os"
+)
+
+// TestFS calls the nodes directly, as mounting requires fusermount and privileges which tests may not have.
+func TestFS(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	var ctx = context.Background()
+	service, err := fake.NewService(ctx, server)
+	require.NoError(t, err)
+	client, err := service.Take(ctx, 1)
+	require.NoError(t, err)
+	root, err := client.FilesService().Create(&drive.File{Name: t.Name(), MimeType: drfsos.FolderMimeType}).Do()
+	require.NoError(t, err)
+	drfsos.UseService(service)
+	drfsos.Root = root.Id
+
+	rootNode, err := FS{}.Root()
+	require.NoError(t, err)
+	dir := rootNode.(*Dir)
+
+	sub, err := dir.Mkdir(ctx, &fuse.MkdirRequest{Name: "dir", Mode: os.ModeDir | 0755})
+	require.NoError(t, err)
+	_, err = dir.Mkdir(ctx, &fuse.MkdirRequest{Name: "dir", Mode: os.ModeDir | 0755})
+	assert.Equal(t, fuse.EEXIST, err)
+
+	node, handle, err := sub.(*Dir).Create(ctx, &fuse.CreateRequest{Name: "file", Flags: fuse.OpenWriteOnly}, &fuse.CreateResponse{})
+	require.NoError(t, err)
+	h := handle.(*Handle)
+	write(t, h, 0, "hello")
+	write(t, h, 5, " world")
+
+	// writes must append to the file.
+	err = h.Write(ctx, &fuse.WriteRequest{Offset: 3, Data: []byte("x")}, &fuse.WriteResponse{})
+	assert.Equal(t, fuse.Errno(syscall.ENOTSUP), err)
+	require.NoError(t, h.Flush(ctx, &fuse.FlushRequest{}))
+	require.NoError(t, h.Release(ctx, &fuse.ReleaseRequest{}))
+
+	var attr fuse.Attr
+	require.NoError(t, node.Attr(ctx, &attr))
+	assert.Equal(t, uint64(len("hello world")), attr.Size)
+
+	// a looked up file is sized by its index, and supports ranged reads.
+	node, err = sub.(*Dir).Lookup(ctx, "file")
+	require.NoError(t, err)
+	require.NoError(t, node.Attr(ctx, &attr))
+	assert.Equal(t, uint64(len("hello world")), attr.Size)
+	assert.Equal(t, os.FileMode(0644), attr.Mode)
+
+	handle, err = node.(*File).Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	require.NoError(t, err)
+	assert.Equal(t, "world", read(t, handle.(*Handle), 6, 100))
+	assert.Equal(t, "hello", read(t, handle.(*Handle), 0, 5))
+	err = handle.(*Handle).Write(ctx, &fuse.WriteRequest{Offset: 11, Data: []byte("x")}, &fuse.WriteResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EBADF), err)
+	require.NoError(t, handle.(*Handle).Release(ctx, &fuse.ReleaseRequest{}))
+
+	// opening with O_TRUNC rewrites the whole file.
+	handle, err = node.(*File).Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadWrite | fuse.OpenTruncate}, &fuse.OpenResponse{})
+	require.NoError(t, err)
+	write(t, handle.(*Handle), 0, "bye")
+	assert.Equal(t, "bye", read(t, handle.(*Handle), 0, 100))
+	require.NoError(t, handle.(*Handle).Release(ctx, &fuse.ReleaseRequest{}))
+
+	dirents, err := dir.ReadDirAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []fuse.Dirent{{Name: "dir", Type: fuse.DT_Dir}}, dirents)
+
+	assert.Equal(t, fuse.Errno(syscall.ENOTEMPTY), dir.Remove(ctx, &fuse.RemoveRequest{Name: "dir", Dir: true}))
+	require.NoError(t, sub.(*Dir).Rename(ctx, &fuse.RenameRequest{OldName: "file", NewName: "moved"}, dir))
+	_, err = sub.(*Dir).Lookup(ctx, "file")
+	assert.Equal(t, fuse.ENOENT, err)
+
+	require.NoError(t, dir.Remove(ctx, &fuse.RemoveRequest{Name: "dir", Dir: true}))
+	require.NoError(t, dir.Remove(ctx, &fuse.RemoveRequest{Name: "moved"}))
+	dirents, err = dir.ReadDirAll(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, dirents)
+}
+
+func TestErrno(t *testing.T) {
+	assert.Nil(t, errno(nil))
+	assert.Equal(t, fuse.ENOENT, errno(&os.PathError{Op: "open", Path: "a", Err: os.ErrNotExist}))
+	assert.Equal(t, fuse.Errno(syscall.EISDIR), errno(&os.PathError{Op: "open", Path: "a", Err: syscall.EISDIR}))
+	assert.Equal(t, fuse.EIO, errno(errors.New("googleapi: Error 500")))
+}
+
+func write(t *testing.T, h *Handle, offset int64, data string) {
+	var resp fuse.WriteResponse
+	require.NoError(t, h.Write(context.Background(), &fuse.WriteRequest{Offset: offset, Data: []byte(data)}, &resp))
+	assert.Equal(t, len(data), resp.Size)
+}
+
+func read(t *testing.T, h *Handle, offset int64, size int) string {
+	var resp fuse.ReadResponse
+	require.NoError(t, h.Read(context.Background(), &fuse.ReadRequest{Offset: offset, Size: size}, &resp))
+	return string(resp.Data)
+}
@@ -0,0 +1,304 @@
+package os
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+)
+
+// FolderMimeType is the MIME type of Drive folders, which hold the directories of the namespace.
+const FolderMimeType = "application/vnd.google-apps.folder"
+
+// Root is the ID of the Drive folder at the top of the namespace; paths name files relative to Root, separating
+// directories by '/'. "root" refers to My Drive of the account. Each account has a My Drive of its own, so when using
+// multiple accounts, set Root to a folder shared with all of them. If empty on first use, Root is read from DRFS_ROOT.
+var Root string
+
+// Mkdir creates a directory, which is a Drive folder. Its parent must exist. Drive folders have no permission bits,
+// so perm is ignored.
+func Mkdir(name string, perm os.FileMode) error {
+	ns, err := current()
+	if err != nil {
+		return err
+	}
+
+	parent, base, err := ns.resolveParent(context.Background(), name)
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	existing, err := ns.lookup(context.Background(), parent.Id, base)
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	if existing != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+
+	client, err := ns.service.Take(context.Background(), 1)
+	if err != nil {
+		return err
+	}
+	_, err = client.FilesService().
+		Create(&drive.File{Name: base, MimeType: FolderMimeType, Parents: []string{parent.Id}}).
+		Fields("id").
+		Do()
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return nil
+}
+
+// MkdirAll creates a directory and any parents which do not exist yet. It does nothing if the directory exists.
+func MkdirAll(name string, perm os.FileMode) error {
+	ns, err := current()
+	if err != nil {
+		return err
+	}
+
+	var dir string
+	for _, elem := range split(name) {
+		dir = path.Join(dir, elem)
+		file, err := ns.resolve(context.Background(), dir)
+		if errors.Is(err, os.ErrNotExist) {
+			err = Mkdir(dir, perm)
+			if errors.Is(err, os.ErrExist) {
+				// created in the meantime.
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: err}
+		}
+		if !isDir(file) {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+		}
+	}
+	return nil
+}
+
+// ReadDir returns the entries of the directory sorted by name. Each file is opened to report its size; Drive files
+// which are not drfs files are left out.
+func ReadDir(name string) ([]drfs.FileInfo, error) {
+	ns, err := current()
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := ns.resolve(context.Background(), name)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	if !isDir(dir) {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+
+	infos, err := ns.entries(context.Background(), dir.Id)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return infos, nil
+}
+
+// Stat returns the FileInfo of the file or directory.
+func Stat(name string) (drfs.FileInfo, error) {
+	ns, err := current()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := ns.resolve(context.Background(), name)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	info, err := ns.stat(context.Background(), file)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+// Lstat returns the FileInfo of the file or directory, as Stat does; Drive has no symbolic links.
+func Lstat(name string) (drfs.FileInfo, error) {
+	return Stat(name)
+}
+
+// Glob returns the paths of the files and directories matching the pattern, using the syntax of path.Match, as
+// filepath.Glob does. Drive files which are not drfs files never match.
+func Glob(pattern string) ([]string, error) {
+	ns, err := current()
+	if err != nil {
+		return nil, err
+	}
+	return fs.Glob(&FS{ns: ns}, clean(pattern))
+}
+
+// Rename moves the file or directory at oldpath to newpath. A file at newpath is replaced; a directory is not. The
+// comments of a file stay intact, as its Drive file is only renamed. The replaced file is deleted once the rename
+// succeeded, so a failed rename leaves it in place.
+func Rename(oldpath, newpath string) error {
+	ns, err := current()
+	if err != nil {
+		return err
+	}
+
+	file, err := ns.resolve(context.Background(), oldpath)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	if len(split(oldpath)) == 0 || len(split(newpath)) == 0 {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EINVAL}
+	}
+	if isDir(file) && clean(newpath) != clean(oldpath) && strings.HasPrefix(clean(newpath)+"/", clean(oldpath)+"/") {
+		// a directory cannot be moved into itself.
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EINVAL}
+	}
+
+	parent, base, err := ns.resolveParent(context.Background(), newpath)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	existing, err := ns.lookup(context.Background(), parent.Id, base)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	if existing != nil {
+		if existing.Id == file.Id {
+			return nil
+		}
+		if isDir(existing) {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrExist}
+		}
+	}
+
+	client, err := ns.service.Take(context.Background(), 1)
+	if err != nil {
+		return err
+	}
+	update := client.FilesService().Update(file.Id, &drive.File{Name: base}).Fields("id")
+	if !contains(file.Parents, parent.Id) {
+		update = update.AddParents(parent.Id).RemoveParents(strings.Join(file.Parents, ","))
+	}
+	if _, err := update.Do(); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	if existing != nil {
+		if err := ns.remove(context.Background(), existing); err != nil {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+		}
+	}
+	return nil
+}
+
+// RemoveAll removes the file or directory and everything it contains. It returns nil if the path does not exist.
+func RemoveAll(name string) error {
+	ns, err := current()
+	if err != nil {
+		return err
+	}
+
+	if len(split(name)) == 0 {
+		return &os.PathError{Op: "removeall", Path: name, Err: syscall.EINVAL}
+	}
+	file, err := ns.resolve(context.Background(), name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return &os.PathError{Op: "removeall", Path: name, Err: err}
+	}
+	if err := ns.remove(context.Background(), file); err != nil {
+		return &os.PathError{Op: "removeall", Path: name, Err: err}
+	}
+	return nil
+}
+
+// clean returns the path relative to Root in canonical form.
+func clean(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// split returns the elements of the path. The empty path and "/" refer to Root, which has no elements.
+func split(name string) []string {
+	name = clean(name)
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, "/")
+}
+
+// quote returns s as a string literal of the Drive query language.
+func quote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+func isDir(file *drive.File) bool {
+	return file.MimeType == FolderMimeType
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// dirInfo describes a directory.
+type dirInfo struct {
+	file *drive.File
+}
+
+func (d *dirInfo) ID() string {
+	return d.file.Id
+}
+
+func (d *dirInfo) Name() string {
+	return d.file.Name
+}
+
+func (d *dirInfo) Size() int64 {
+	return 0
+}
+
+func (d *dirInfo) Mode() os.FileMode {
+	return os.ModeDir | 0755
+}
+
+func (d *dirInfo) ModTime() time.Time {
+	mod, _ := time.Parse(time.RFC3339, d.file.ModifiedTime)
+	return mod
+}
+
+func (d *dirInfo) IsDir() bool {
+	return true
+}
+
+func (d *dirInfo) Sys() interface{} {
+	return d.file
+}
+
+func (d *dirInfo) QuotaBytesUsed() int64 {
+	return d.file.QuotaBytesUsed
+}
+
+func (d *dirInfo) Hash() []byte {
+	return nil
+}
+
+func (d *dirInfo) StoredSize() int64 {
+	return 0
+}
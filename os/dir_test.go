@@ -0,0 +1,186 @@
+package os
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+	"github.com/kaiserkarel/drfs/fake"
+)
+
+// useFake points the package at an in-memory backend, rooted in a fresh folder, injecting faults by the rules.
+func useFake(t *testing.T, rules ...*fake.Rule) *fake.Server {
+	server := fake.NewServer()
+
+	injector := fake.NewInjector(server.Client().Transport, rules...)
+	fakeService, err := fake.NewServiceWithTransport(context.Background(), server, injector)
+	require.NoError(t, err)
+	client, err := fakeService.Take(context.Background(), 1)
+	require.NoError(t, err)
+	root, err := client.FilesService().Create(&drive.File{Name: t.Name(), MimeType: FolderMimeType}).Do()
+	require.NoError(t, err)
+
+	UseService(fakeService)
+	Root = root.Id
+	return server
+}
+
+func TestNamespace(t *testing.T) {
+	server := useFake(t)
+	defer server.Close()
+
+	_, err := Create("missing/file")
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	require.NoError(t, MkdirAll("a/b", 0755))
+	require.NoError(t, MkdirAll("a/b", 0755))
+	assert.True(t, errors.Is(Mkdir("a", 0755), os.ErrExist))
+
+	for _, name := range []string{"a/b/one", "a/two", "two"} {
+		file, err := Create(name)
+		require.NoError(t, err)
+		_, err = file.Write([]byte(name))
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+	}
+
+	// files of the same name in different directories are distinct.
+	file, err := Lookup("/a/two")
+	require.NoError(t, err)
+	got, err := ioutil.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, "a/two", string(got))
+
+	_, err = Open("a")
+	assert.Error(t, err, "a is a directory")
+
+	infos, err := ReadDir("a")
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	assert.Equal(t, "b", infos[0].Name())
+	assert.True(t, infos[0].IsDir())
+	assert.Equal(t, "two", infos[1].Name())
+	assert.False(t, infos[1].IsDir())
+	assert.Equal(t, int64(len("a/two")), infos[1].Size())
+
+	info, err := Stat("a/b")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	assert.True(t, info.Mode().IsDir())
+
+	// renaming moves the file between directories, replacing the file at the new path.
+	require.NoError(t, Rename("a/b/one", "two"))
+	_, err = Stat("a/b/one")
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	file, err = Lookup("two")
+	require.NoError(t, err)
+	got, err = ioutil.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, "a/b/one", string(got))
+
+	assert.Error(t, Rename("a", "a/b/c"), "a directory cannot be moved into itself")
+	require.NoError(t, Rename("a/b", "c"))
+	info, err = Stat("c")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	require.NoError(t, Rename("c", "c/"), "renaming a directory to itself does nothing")
+
+	require.NoError(t, RemoveAll("a"))
+	require.NoError(t, RemoveAll("a"))
+	infos, err = ReadDir("/")
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	assert.Equal(t, "c", infos[0].Name())
+	assert.Equal(t, "two", infos[1].Name())
+}
+
+func TestRenameFails(t *testing.T) {
+	rule := &fake.Rule{Match: fake.Match(http.MethodPatch, "files"), Fault: fake.BadRequest}
+	server := useFake(t, rule)
+	defer server.Close()
+
+	for _, name := range []string{"one", "two"} {
+		file, err := Create(name)
+		require.NoError(t, err)
+		_, err = file.WriteString(name)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+	}
+
+	// the file at the new path is left intact if the rename fails.
+	rule.Probability = 1
+	assert.Error(t, Rename("one", "two"))
+	assertContent(t, "one", "one")
+	assertContent(t, "two", "two")
+
+	rule.Probability = 0
+	require.NoError(t, Rename("one", "two"))
+	assertContent(t, "two", "one")
+	_, err := Stat("one")
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+func TestLegacyPath(t *testing.T) {
+	server := useFake(t)
+	defer server.Close()
+
+	// a file uploaded before directories, named by its local path.
+	ns, err := current()
+	require.NoError(t, err)
+	legacy, err := drfs.CreateFileCtx(context.Background(), ns.service, "/backups/db.tar", drfs.FileOptions{NumThreads: 2, Parent: Root})
+	require.NoError(t, err)
+	_, err = legacy.Write([]byte("lorem"))
+	require.NoError(t, err)
+	require.NoError(t, legacy.Close())
+
+	assertContent(t, "/backups/db.tar", "lorem")
+	_, err = Stat("backups/db.tar")
+	assert.True(t, errors.Is(err, os.ErrNotExist), "the name must match as uploaded")
+
+	require.NoError(t, MkdirAll("backups", 0755))
+	require.NoError(t, Rename("/backups/db.tar", "backups/db.tar"))
+	assertContent(t, "backups/db.tar", "lorem")
+	infos, err := ReadDir("/")
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "backups", infos[0].Name())
+}
+
+func TestGlob(t *testing.T) {
+	server := useFake(t)
+	defer server.Close()
+
+	require.NoError(t, MkdirAll("a/b", 0755))
+	for _, name := range []string{"a/one.tar", "a/two.tar", "a/b/three.tar", "four.txt"} {
+		file, err := Create(name)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+	}
+
+	matches, err := Glob("a/*.tar")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/one.tar", "a/two.tar"}, matches)
+
+	matches, err = Glob("/*/*")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/b", "a/one.tar", "a/two.tar"}, matches)
+
+	matches, err = Glob("four.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"four.txt"}, matches)
+
+	matches, err = Glob("missing*")
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+
+	_, err = Glob("[")
+	assert.Error(t, err)
+}
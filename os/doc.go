@@ -2,4 +2,8 @@
 // attempts to be a drop in replacement using drfs to reduce boilerplate code. Before usage a
 // drfs client is initiated by looking for a secret either in the directory specified by
 // DRFS_APPLICATION_CREDENTIALS or a specific directory using GOOGLE_APPLICATION_CREDENTIALS.
+//
+// Files are named by slash-separated paths relative to Root. Directories are Drive folders, so the namespace shows in
+// the Drive UI as well. Errors wrap os.ErrNotExist and os.ErrExist as those of package os do, so callers can use
+// errors.Is.
 package os
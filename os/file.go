@@ -0,0 +1,290 @@
+package os
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+	"github.com/kaiserkarel/drfs/recovery"
+)
+
+// File is a drfs.File opened by OpenFile, which checks the access mode it was opened with on every read and write. Only
+// the methods which honor the access mode are exposed; use Lookup for the drfs.File itself.
+type File struct {
+	file *drfs.File
+
+	name string
+	flag int
+	ns   namespace
+}
+
+// Name returns the path the file was opened by.
+func (f *File) Name() string {
+	return f.name
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	if err := f.checkRead("read"); err != nil {
+		return 0, err
+	}
+	return f.file.Read(p)
+}
+
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.checkRead("read"); err != nil {
+		return 0, err
+	}
+	return f.file.ReadAt(p, off)
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	if err := f.checkWrite("write"); err != nil {
+		return 0, err
+	}
+	return f.file.Write(p)
+}
+
+func (f *File) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	return f.file.Seek(offset, whence)
+}
+
+// Matches reports whether the content of the file equals the data read from r, as drfs.File.Matches does.
+func (f *File) Matches(r io.Reader) (bool, error) {
+	if err := f.checkRead("read"); err != nil {
+		return false, err
+	}
+	return f.file.Matches(r)
+}
+
+func (f *File) Stat() (os.FileInfo, error) {
+	return f.file.Stat()
+}
+
+func (f *File) Fstat() (drfs.FileInfo, error) {
+	return f.file.Fstat()
+}
+
+// Index returns the index of the file, describing its threads.
+func (f *File) Index() drfs.Index {
+	return f.file.Index()
+}
+
+// Truncate changes the size of the file. Growing the file appends zeros. Truncating to 0 replaces the Drive file by an
+// empty one with the same options, while shrinking it otherwise deletes the data past size using recovery.Trim;
+// compressed files can only be shrunk to 0.
+func (f *File) Truncate(size int64) error {
+	if err := f.checkWrite("truncate"); err != nil {
+		return err
+	}
+	file, err := truncate(f.ns, f.file, size)
+	if err != nil {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: err}
+	}
+	f.file = file
+	return nil
+}
+
+// Sync commits files opened for writing. Files opened read-only are left as is.
+func (f *File) Sync() error {
+	if !f.writable() {
+		return nil
+	}
+	return f.file.Sync()
+}
+
+// Close commits files opened for writing, as Sync does.
+func (f *File) Close() error {
+	return f.Sync()
+}
+
+func (f *File) writable() bool {
+	return f.flag&(O_WRONLY|O_RDWR) != 0
+}
+
+func (f *File) checkRead(op string) error {
+	if f.flag&O_WRONLY != 0 {
+		return &os.PathError{Op: op, Path: f.name, Err: syscall.EBADF}
+	}
+	return nil
+}
+
+func (f *File) checkWrite(op string) error {
+	if !f.writable() {
+		return &os.PathError{Op: op, Path: f.name, Err: syscall.EBADF}
+	}
+	return nil
+}
+
+// Remove removes the file or empty directory.
+func Remove(name string) error {
+	ns, err := current()
+	if err != nil {
+		return err
+	}
+
+	if len(split(name)) == 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.EINVAL}
+	}
+	file, err := ns.resolve(context.Background(), name)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	if isDir(file) {
+		children, err := ns.list(context.Background(), file.Id)
+		if err != nil {
+			return &os.PathError{Op: "remove", Path: name, Err: err}
+		}
+		if len(children) > 0 {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+		}
+	}
+
+	client, err := ns.service.Take(context.Background(), 1)
+	if err != nil {
+		return err
+	}
+	if err := client.FilesService().Delete(file.Id).Do(); err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return nil
+}
+
+// Truncate changes the size of the file, as File.Truncate does.
+func Truncate(name string, size int64) error {
+	f, err := OpenFile(name, O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Chtimes changes the modification time of the file or directory. Drive records no access times, so atime is
+// ignored.
+func Chtimes(name string, atime time.Time, mtime time.Time) error {
+	ns, err := current()
+	if err != nil {
+		return err
+	}
+
+	file, err := ns.resolve(context.Background(), name)
+	if err != nil {
+		return &os.PathError{Op: "chtimes", Path: name, Err: err}
+	}
+
+	if isDir(file) {
+		client, err := ns.service.Take(context.Background(), 1)
+		if err != nil {
+			return err
+		}
+		_, err = client.FilesService().
+			Update(file.Id, &drive.File{ModifiedTime: mtime.UTC().Format(time.RFC3339Nano)}).
+			Fields("id").
+			Do()
+		if err != nil {
+			return &os.PathError{Op: "chtimes", Path: name, Err: err}
+		}
+		return nil
+	}
+
+	opened, err := drfs.OpenWithKeyCtx(context.Background(), file, ns.service, ns.key)
+	if err != nil {
+		return &os.PathError{Op: "chtimes", Path: name, Err: err}
+	}
+	if err := opened.SetModTimeCtx(context.Background(), mtime); err != nil {
+		return &os.PathError{Op: "chtimes", Path: name, Err: err}
+	}
+	return nil
+}
+
+// truncate changes the size of the file, returning the file to continue using, which is reopened or replaced if
+// shrunk.
+func truncate(ns namespace, file *drfs.File, size int64) (*drfs.File, error) {
+	if size < 0 {
+		return nil, syscall.EINVAL
+	}
+	if err := file.Sync(); err != nil {
+		return nil, err
+	}
+	info, err := file.Fstat()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case size == info.Size():
+		return file, nil
+	case size > info.Size():
+		var zeros = make([]byte, 1<<20)
+		for remaining := size - info.Size(); remaining > 0; {
+			p := zeros
+			if remaining < int64(len(p)) {
+				p = p[:remaining]
+			}
+			n, err := file.Write(p)
+			if err != nil {
+				return nil, err
+			}
+			remaining -= int64(n)
+		}
+		return file, file.Sync()
+	case size == 0:
+		return recreate(ns, file, info.Sys().(*drive.File).Id)
+	case file.Index().Header.Compression != "":
+		return nil, errors.New("compressed files can only be truncated to 0")
+	}
+
+	if _, err := recovery.Trim(file, size); err != nil {
+		return nil, err
+	}
+	reopened, err := drfs.OpenWithKeyCtx(context.Background(), info.Sys().(*drive.File), ns.service, ns.key)
+	if err != nil {
+		return nil, err
+	}
+	// the hash is computed anew for the remaining content.
+	return reopened, reopened.Sync()
+}
+
+// recreate replaces the file by an empty one of the same name, folder and options, which is cheaper than deleting
+// every reply of the file. The new file is created before the old one is removed, so a failure leaves the old file.
+func recreate(ns namespace, file *drfs.File, id string) (*drfs.File, error) {
+	ctx := context.Background()
+	client, err := ns.service.Take(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := client.FilesService().Get(id).Fields("name, parents").Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	options := file.Index().Header.FileOptions
+	options.Key = ns.key
+	if len(existing.Parents) > 0 {
+		options.Parent = existing.Parents[0]
+	}
+	created, err := drfs.CreateFileCtx(ctx, ns.service, existing.Name, options)
+	if err != nil {
+		return nil, err
+	}
+	if err := drfs.RemoveCtx(ctx, file); err != nil {
+		if errRemove := drfs.RemoveCtx(ctx, created); errRemove != nil {
+			return nil, fmt.Errorf("%w (%s)", err, errRemove)
+		}
+		return nil, err
+	}
+	return created, nil
+}
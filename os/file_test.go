@@ -0,0 +1,168 @@
+package os
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenFile(t *testing.T) {
+	server := useFake(t)
+	defer server.Close()
+
+	_, err := OpenFile("file", O_RDWR, 0)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	file, err := OpenFile("file", O_WRONLY|O_CREATE|O_EXCL, 0644)
+	require.NoError(t, err)
+	_, err = file.WriteString("hello")
+	require.NoError(t, err)
+	_, err = file.Read(make([]byte, 1))
+	assert.True(t, errors.Is(err, syscall.EBADF))
+	_, err = file.Matches(strings.NewReader("hello"))
+	assert.True(t, errors.Is(err, syscall.EBADF))
+	require.NoError(t, file.Close())
+
+	_, err = OpenFile("file", O_WRONLY|O_CREATE|O_EXCL, 0644)
+	assert.True(t, errors.Is(err, os.ErrExist))
+
+	// writes append to the file.
+	file, err = OpenFile("file", O_WRONLY|O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = file.WriteString(" world")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	file, err = Open("file")
+	require.NoError(t, err)
+	_, err = file.WriteString("read-only")
+	assert.True(t, errors.Is(err, syscall.EBADF))
+	got, err := ioutil.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(got))
+	require.NoError(t, file.Close())
+
+	// creating an existing file truncates it.
+	file, err = Create("file")
+	require.NoError(t, err)
+	_, err = file.WriteString("bye")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	assertContent(t, "file", "bye")
+}
+
+func TestTruncate(t *testing.T) {
+	server := useFake(t)
+	defer server.Close()
+
+	file, err := Create("file")
+	require.NoError(t, err)
+	var payload = strings.Repeat("lorem ipsum dolor sit amet ", 2000)
+	_, err = file.WriteString(payload)
+	require.NoError(t, err)
+	require.NoError(t, file.Truncate(30000))
+
+	// the file continues after truncating.
+	_, err = file.WriteString("end")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	assertContent(t, "file", payload[:30000]+"end")
+
+	require.NoError(t, Truncate("file", 30010))
+	assertContent(t, "file", payload[:30000]+"end\x00\x00\x00\x00\x00\x00\x00")
+
+	require.NoError(t, Truncate("file", 0))
+	assertContent(t, "file", "")
+
+	assert.True(t, errors.Is(Truncate("missing", 0), os.ErrNotExist))
+}
+
+func TestTruncateEmpty(t *testing.T) {
+	server := useFake(t)
+	defer server.Close()
+
+	require.NoError(t, Mkdir("dir", 0755))
+	file, err := Create("dir/file")
+	require.NoError(t, err)
+	_, err = file.WriteString(strings.Repeat("lorem ipsum dolor sit amet ", 2000))
+	require.NoError(t, err)
+	threads := len(file.Index().Buckets)
+
+	// the file is replaced by an empty one, in the same folder and with the same threads.
+	require.NoError(t, file.Truncate(0))
+	assert.Len(t, file.Index().Buckets, threads)
+	_, err = file.WriteString("lorem")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	assertContent(t, "dir/file", "lorem")
+
+	infos, err := ReadDir("dir")
+	require.NoError(t, err)
+	assert.Len(t, infos, 1)
+}
+
+func TestRemove(t *testing.T) {
+	server := useFake(t)
+	defer server.Close()
+
+	require.NoError(t, Mkdir("dir", 0755))
+	file, err := Create("dir/file")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	assert.True(t, errors.Is(Remove("dir"), syscall.ENOTEMPTY))
+	require.NoError(t, Remove("dir/file"))
+	assert.True(t, errors.Is(Remove("dir/file"), os.ErrNotExist))
+	require.NoError(t, Remove("dir"))
+	_, err = Lstat("dir")
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+func TestChtimes(t *testing.T) {
+	server := useFake(t)
+	defer server.Close()
+
+	require.NoError(t, Mkdir("dir", 0755))
+	file, err := Create("dir/file")
+	require.NoError(t, err)
+	_, err = file.WriteString("hello")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	var mtime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, name := range []string{"dir", "dir/file"} {
+		require.NoError(t, Chtimes(name, mtime, mtime))
+		info, err := Stat(name)
+		require.NoError(t, err)
+		assert.True(t, mtime.Equal(info.ModTime()), "%s: %s", name, info.ModTime())
+	}
+
+	// writing updates the modification time.
+	file, err = OpenFile("dir/file", O_WRONLY|O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = file.WriteString(" world")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	info, err := Stat("dir/file")
+	require.NoError(t, err)
+	assert.True(t, info.ModTime().After(mtime))
+}
+
+func assertContent(t *testing.T, name, content string) {
+	file, err := Open(name)
+	require.NoError(t, err)
+	got, err := ioutil.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(got))
+
+	info, err := file.Stat()
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), info.Size())
+}
@@ -0,0 +1,205 @@
+package os
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"syscall"
+
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+)
+
+// FS provides read-only access to the files under a Drive folder, such as for http.FS, template.ParseFS or
+// fs.WalkDir. It implements fs.FS, fs.ReadDirFS, fs.StatFS and fs.ReadFileFS. Directories are Drive folders; Drive
+// files which are not drfs files are left out of directory listings.
+type FS struct {
+	ns namespace
+}
+
+// NewFS returns the FS of the folder with ID root, such as Root. The key decrypts encrypted files, and may be nil.
+func NewFS(service drfs.Service, root string, key drfs.Key) *FS {
+	return &FS{ns: namespace{service: service, root: root, key: key}}
+}
+
+// Open opens the file or directory. Files are backed by a drfs.File, and support Seek and ReadAt as well.
+func (f *FS) Open(name string) (fs.File, error) {
+	file, err := f.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if isDir(file) {
+		return &dirFile{fsys: f, file: file}, nil
+	}
+
+	opened, err := drfs.OpenWithKeyCtx(context.Background(), file, f.ns.service, f.ns.key)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &readOnlyFile{file: opened}, nil
+}
+
+// ReadDir returns the entries of the directory sorted by name.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	file, err := f.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !isDir(file) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+
+	entries, err := f.entries(file)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return entries, nil
+}
+
+// Stat returns the FileInfo of the file or directory.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	file, err := f.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.ns.stat(context.Background(), file)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+// ReadFile returns the content of the file.
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if _, ok := file.(*dirFile); ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: syscall.EISDIR}
+	}
+	p, err := io.ReadAll(file)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return p, nil
+}
+
+// resolve returns the Drive file at the path, which must be valid according to fs.ValidPath.
+func (f *FS) resolve(op, name string) (*drive.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	file, err := f.ns.resolve(context.Background(), name)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return file, nil
+}
+
+func (f *FS) entries(dir *drive.File) ([]fs.DirEntry, error) {
+	infos, err := f.ns.entries(context.Background(), dir.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries = make([]fs.DirEntry, len(infos))
+	for i, info := range infos {
+		entries[i] = dirEntry{info: info}
+	}
+	return entries, nil
+}
+
+// readOnlyFile exposes the reading methods of a File. Close does not Sync the file, as nothing is written.
+type readOnlyFile struct {
+	file *drfs.File
+}
+
+func (r *readOnlyFile) Read(p []byte) (int, error) {
+	return r.file.Read(p)
+}
+
+func (r *readOnlyFile) ReadAt(p []byte, off int64) (int, error) {
+	return r.file.ReadAt(p, off)
+}
+
+func (r *readOnlyFile) Seek(offset int64, whence int) (int64, error) {
+	return r.file.Seek(offset, whence)
+}
+
+func (r *readOnlyFile) Stat() (fs.FileInfo, error) {
+	return r.file.Stat()
+}
+
+func (r *readOnlyFile) Close() error {
+	return nil
+}
+
+// dirFile is an open directory, listing its entries on the first call to ReadDir.
+type dirFile struct {
+	fsys    *FS
+	file    *drive.File
+	entries []fs.DirEntry
+	listed  bool
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) {
+	return &dirInfo{file: d.file}, nil
+}
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.file.Name, Err: syscall.EISDIR}
+}
+
+func (d *dirFile) Close() error {
+	return nil
+}
+
+// ReadDir returns the next n entries of the directory, or all remaining entries if n <= 0.
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.listed {
+		entries, err := d.fsys.entries(d.file)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: d.file.Name, Err: err}
+		}
+		d.entries, d.listed = entries, true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+type dirEntry struct {
+	info drfs.FileInfo
+}
+
+func (e dirEntry) Name() string {
+	return e.info.Name()
+}
+
+func (e dirEntry) IsDir() bool {
+	return e.info.IsDir()
+}
+
+func (e dirEntry) Type() fs.FileMode {
+	return e.info.Mode().Type()
+}
+
+func (e dirEntry) Info() (fs.FileInfo, error) {
+	return e.info, nil
+}
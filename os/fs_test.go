@@ -0,0 +1,45 @@
+package os
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFS(t *testing.T) {
+	server := useFake(t)
+	defer server.Close()
+
+	require.NoError(t, MkdirAll("a/b", 0755))
+	for _, name := range []string{"a/b/one", "a/two", "three"} {
+		file, err := Create(name)
+		require.NoError(t, err)
+		_, err = file.Write([]byte(name))
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+	}
+
+	fsys := NewFS(service, Root, nil)
+	require.NoError(t, fstest.TestFS(fsys, "a/b/one", "a/two", "three"))
+
+	p, err := fs.ReadFile(fsys, "a/b/one")
+	require.NoError(t, err)
+	assert.Equal(t, "a/b/one", string(p))
+
+	var walked []string
+	err = fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		walked = append(walked, path)
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{".", "a", "a/b", "a/b/one", "a/two", "three"}, walked)
+
+	_, err = fsys.Open("missing")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+	_, err = fsys.Open("/three")
+	assert.True(t, errors.Is(err, fs.ErrInvalid))
+}
@@ -0,0 +1,42 @@
+package os
+
+import (
+	"os"
+	"sync"
+
+	"github.com/kaiserkarel/drfs"
+)
+
+const (
+	DRFS_KEY_FILE          = "DRFS_KEY_FILE"
+	DRFS_PASSPHRASE        = "DRFS_PASSPHRASE"
+	DRFS_AGE_IDENTITY_FILE = "DRFS_AGE_IDENTITY_FILE"
+)
+
+// Key encrypts created files and decrypts opened files. If nil on first use, the key is read from the file named by
+// DRFS_KEY_FILE, the age identities are read from the file named by DRFS_AGE_IDENTITY_FILE, or the key is derived from
+// DRFS_PASSPHRASE. Files are created unencrypted if none is set.
+var Key drfs.Key
+
+var keyOnce = sync.Once{}
+var keyErr error
+
+func key() (drfs.Key, error) {
+	keyOnce.Do(func() {
+		if Key != nil {
+			return
+		}
+		if path, ok := os.LookupEnv(DRFS_KEY_FILE); ok {
+			Key, keyErr = drfs.KeyFile(path)
+			return
+		}
+		if path, ok := os.LookupEnv(DRFS_AGE_IDENTITY_FILE); ok {
+			Key, keyErr = drfs.AgeIdentityFile(path)
+			return
+		}
+		if passphrase, ok := os.LookupEnv(DRFS_PASSPHRASE); ok {
+			Key = drfs.Passphrase(passphrase)
+		}
+	})
+	return Key, keyErr
+}
@@ -0,0 +1,196 @@
+package os
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"syscall"
+
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+)
+
+// namespace resolves paths to Drive files, starting from the root folder.
+type namespace struct {
+	service drfs.Service
+	root    string
+	key     drfs.Key
+}
+
+// current returns the namespace of the package, rooted at Root.
+func current() (namespace, error) {
+	err := ensure()
+	if err != nil {
+		return namespace{}, err
+	}
+	key, err := key()
+	if err != nil {
+		return namespace{}, err
+	}
+	return namespace{service: service, root: Root, key: key}, nil
+}
+
+// remove deletes the Drive file, deleting the children of folders first.
+func (n namespace) remove(ctx context.Context, file *drive.File) error {
+	if isDir(file) {
+		children, err := n.list(ctx, file.Id)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			if err := n.remove(ctx, child); err != nil {
+				return err
+			}
+		}
+	}
+
+	client, err := n.service.Take(ctx, 1)
+	if err != nil {
+		return err
+	}
+	return client.FilesService().Delete(file.Id).Context(ctx).Do()
+}
+
+// resolve returns the Drive file at the path, walking the directories from the root. It returns os.ErrNotExist if any
+// element is missing.
+func (n namespace) resolve(ctx context.Context, name string) (*drive.File, error) {
+	client, err := n.service.Take(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	elems := split(name)
+	if len(elems) == 0 {
+		return client.FilesService().Get(n.root).Fields("*").Context(ctx).Do()
+	}
+
+	var file = &drive.File{Id: n.root, MimeType: FolderMimeType}
+	for _, elem := range elems {
+		if !isDir(file) {
+			return n.legacy(ctx, name, syscall.ENOTDIR)
+		}
+		file, err = n.lookup(ctx, file.Id, elem)
+		if err != nil {
+			return nil, err
+		}
+		if file == nil {
+			return n.legacy(ctx, name, os.ErrNotExist)
+		}
+	}
+	return file, nil
+}
+
+// legacy looks up a file in Root named by the whole path, slashes included, as drfs named files by the local path
+// they were uploaded from before it had directories. It returns err if there is none. Rename moves such files to the
+// directories their name describes.
+func (n namespace) legacy(ctx context.Context, name string, err error) (*drive.File, error) {
+	if len(split(name)) < 2 {
+		return nil, err
+	}
+	file, lookupErr := n.lookup(ctx, n.root, name)
+	if lookupErr != nil || file == nil || isDir(file) {
+		return nil, err
+	}
+	return file, nil
+}
+
+// resolveParent returns the directory holding the path, and the name of the path within it.
+func (n namespace) resolveParent(ctx context.Context, name string) (*drive.File, string, error) {
+	elems := split(name)
+	if len(elems) == 0 {
+		return nil, "", syscall.EINVAL
+	}
+
+	parent, err := n.resolve(ctx, path.Join(elems[:len(elems)-1]...))
+	if err != nil {
+		return nil, "", err
+	}
+	if !isDir(parent) {
+		return nil, "", syscall.ENOTDIR
+	}
+	return parent, elems[len(elems)-1], nil
+}
+
+// lookup returns the child of the folder by name, or nil if there is none.
+func (n namespace) lookup(ctx context.Context, folderID, name string) (*drive.File, error) {
+	client, err := n.service.Take(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.FilesService().List().
+		Q(fmt.Sprintf("name = %s and %s in parents and trashed = false", quote(name), quote(folderID))).
+		Fields("*").
+		Context(ctx).
+		Do()
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(resp.Files) {
+	case 0:
+		return nil, nil
+	case 1:
+		return resp.Files[0], nil
+	default:
+		return nil, fmt.Errorf("multiple files match name: %s", name)
+	}
+}
+
+// list returns the children of the folder.
+func (n namespace) list(ctx context.Context, folderID string) ([]*drive.File, error) {
+	client, err := n.service.Take(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*drive.File
+	err = client.FilesService().List().
+		Q(fmt.Sprintf("%s in parents and trashed = false", quote(folderID))).
+		Fields("*").
+		Pages(ctx, func(list *drive.FileList) error {
+			files = append(files, list.Files...)
+			return nil
+		})
+	return files, err
+}
+
+// stat returns the FileInfo of the Drive file, opening drfs files.
+func (n namespace) stat(ctx context.Context, file *drive.File) (drfs.FileInfo, error) {
+	if isDir(file) {
+		return &dirInfo{file: file}, nil
+	}
+
+	f, err := drfs.OpenWithKeyCtx(ctx, file, n.service, n.key)
+	if err != nil {
+		return nil, err
+	}
+	return f.Fstat()
+}
+
+// entries returns the FileInfo of the children of the folder sorted by name, leaving out Drive files which are not
+// drfs files.
+func (n namespace) entries(ctx context.Context, folderID string) ([]drfs.FileInfo, error) {
+	children, err := n.list(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []drfs.FileInfo
+	for _, child := range children {
+		info, err := n.stat(ctx, child)
+		if errors.Is(err, drfs.ErrMissingFileHeader) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", child.Name, err)
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
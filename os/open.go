@@ -2,47 +2,111 @@ package os
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"os"
+	"syscall"
+
+	"google.golang.org/api/drive/v3"
 
 	"github.com/kaiserkarel/drfs"
 )
 
 const DefaultNumThreads = 48
 
-// Open either creates or opens the file by filename; first searching for that specific file through the list api.
-// It errors if more or less than 1 file(s) are found.
-func Open(fileName string) (*drfs.File, error) {
-	err := ensure()
+// DefaultCodec is the codec of created files. Base85 allows storing arbitrary binary files.
+const DefaultCodec = drfs.Base85
+
+// Compression is the compression of created files, such as drfs.Gzip or drfs.Zstd. Files are created uncompressed if empty.
+var Compression string
+
+// Flags to OpenFile, as in package os.
+const (
+	O_RDONLY = os.O_RDONLY // open the file read-only.
+	O_WRONLY = os.O_WRONLY // open the file write-only.
+	O_RDWR   = os.O_RDWR   // open the file read-write.
+	O_APPEND = os.O_APPEND // append data to the file when writing.
+	O_CREATE = os.O_CREATE // create a new file if none exists.
+	O_EXCL   = os.O_EXCL   // used with O_CREATE, file must not exist.
+	O_SYNC   = os.O_SYNC   // open for synchronous I/O.
+	O_TRUNC  = os.O_TRUNC  // truncate regular writable file when opened.
+)
+
+// Open opens the file by path, relative to Root, for reading.
+func Open(name string) (*File, error) {
+	return OpenFile(name, O_RDONLY, 0)
+}
+
+// Create creates or truncates the file by path, opening it for reading and writing. The directory holding the file
+// must exist.
+func Create(name string) (*File, error) {
+	return OpenFile(name, O_RDWR|O_CREATE|O_TRUNC, 0666)
+}
+
+// OpenFile opens the file by path, relative to Root, honoring the access mode, O_CREATE, O_EXCL and O_TRUNC. Writes
+// always append to the file, as drfs files cannot be overwritten in place, so O_APPEND is implied. Drive files have
+// no permission bits, so perm is ignored. It errors if the path names a directory, or more than 1 file of the name
+// exists in the directory.
+func OpenFile(name string, flag int, perm os.FileMode) (*File, error) {
+	ns, err := current()
 	if err != nil {
 		return nil, err
 	}
 
-	client, err := service.Take(context.Background(), 1)
+	var file *drive.File
+	parent, base, err := ns.resolveParent(context.Background(), name)
+	if err == nil {
+		file, err = ns.lookup(context.Background(), parent.Id, base)
+	}
+	if file == nil && (err == nil || errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ENOTDIR)) {
+		// files uploaded before directories are named by their path.
+		if legacy, _ := ns.legacy(context.Background(), name, nil); legacy != nil {
+			file, err = legacy, nil
+		}
+	}
 	if err != nil {
-		return nil, err
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
 	}
 
-	resp, err := client.FilesService().List().Q(fmt.Sprintf("name = '%s'", fileName)).Fields("*").Do()
+	var opened *drfs.File
+	switch {
+	case file != nil && flag&(O_CREATE|O_EXCL) == O_CREATE|O_EXCL:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case file != nil && isDir(file):
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	case file != nil:
+		opened, err = drfs.OpenWithKeyCtx(context.Background(), file, ns.service, ns.key)
+	case flag&O_CREATE == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	default:
+		opened, err = drfs.CreateFileCtx(context.Background(), ns.service, base, drfs.FileOptions{NumThreads: DefaultNumThreads, Codec: DefaultCodec, Compression: Compression, Key: ns.key, Parent: parent.Id})
+	}
 	if err != nil {
-		return nil, err
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
 	}
 
-	if len(resp.Files) == 0 {
-		file, err := drfs.CreateFileCtx(context.Background(), service, fileName, drfs.FileOptions{NumThreads: DefaultNumThreads})
-		if err != nil {
+	f := &File{file: opened, name: name, flag: flag, ns: ns}
+	if flag&O_TRUNC != 0 && f.writable() {
+		if err := f.Truncate(0); err != nil {
 			return nil, err
 		}
-		return file, nil
 	}
+	return f, nil
+}
 
-	if len(resp.Files) > 1 {
-		return nil, fmt.Errorf("multiple files match name: %s", fileName)
+// Lookup opens an existing file by path for reading and writing, returning the drfs.File itself, such as for package
+// recovery.
+func Lookup(fileName string) (*drfs.File, error) {
+	ns, err := current()
+	if err != nil {
+		return nil, err
 	}
 
-	return drfs.OpenCtx(context.Background(), resp.Files[0], service)
-}
-
-func OpenFile(fileName string, _ int, _ os.FileMode) (*drfs.File, error) {
-	return Open(fileName)
+	file, err := ns.resolve(context.Background(), fileName)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: fileName, Err: err}
+	}
+	if isDir(file) {
+		return nil, &os.PathError{Op: "open", Path: fileName, Err: syscall.EISDIR}
+	}
+	return drfs.OpenWithKeyCtx(context.Background(), file, ns.service, ns.key)
 }
@@ -14,6 +14,7 @@ var serviceErr error
 
 const (
 	DRFS_CREDS = "DRFS_APPLICATION_CREDENTIALS"
+	DRFS_ROOT  = "DRFS_ROOT"
 )
 
 var once = sync.Once{}
@@ -26,10 +27,27 @@ func ensure() error {
 		} else {
 			defaultService()
 		}
+		defaultRoot()
 	})
 	return serviceErr
 }
 
+// UseService makes the package use the service instead of one configured from the environment, such as a
+// fake.Service.
+func UseService(s drfs.Service) {
+	once.Do(defaultRoot)
+	service, serviceErr = s, nil
+}
+
+func defaultRoot() {
+	if Root == "" {
+		Root = os.Getenv(DRFS_ROOT)
+	}
+	if Root == "" {
+		Root = "root"
+	}
+}
+
 func serviceFromDir(dir string) {
 	creds, err := drive.CredentialsFromDirectory(context.Background(), dir)
 	if err != nil {
@@ -0,0 +1,434 @@
+package drfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/google/uuid"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/api/drive/v3"
+)
+
+// ErrThreadLost is returned when reading a thread of which the comment is missing. Files with parity reconstruct the
+// data of lost threads; see File.RebuildThread.
+var ErrThreadLost = errors.New("thread lost")
+
+// stripeOf returns the stripe holding the byte at offset off. Stripe s consists of reply s of every bucket.
+func (f *File) stripeOf(off int64) int64 {
+	return off / int64(f.replySize) / int64(len(f.index.Buckets))
+}
+
+// stripes returns the number of stripes of the file.
+func (f *File) stripes() int64 {
+	var chunks = (f.size() + int64(f.replySize) - 1) / int64(f.replySize)
+	var numThreads = int64(len(f.index.Buckets))
+	return (chunks + numThreads - 1) / numThreads
+}
+
+// readThreadAt reads data of bucket i starting at offset off, at most up to the end of the reply containing off. If
+// the reply cannot be read and the file has parity, it is reconstructed from the other threads.
+func (f *File) readThreadAt(ctx context.Context, i int, p []byte, off int64) (int, error) {
+	t := f.index.Buckets[i]
+	n, err := t.ReadAtCtx(ctx, p, off)
+	if err == nil || err == io.EOF || f.erasure == nil || ctx.Err() != nil {
+		return n, err
+	}
+
+	var size = int64(f.replySize)
+	chunk, errReconstruct := f.reconstruct(ctx, i, off/size, chunkLen(t.Header, off/size, f.replySize))
+	if errReconstruct != nil {
+		return n, fmt.Errorf("%w [reconstruct: %s]", err, errReconstruct)
+	}
+	t.cacheChunk(off/size, chunk)
+	return copy(p, chunk[off%size:]), nil
+}
+
+// chunkLen returns the length of the data of reply s of a thread in the given state.
+func chunkLen(header ThreadHeader, s int64, replySize int) int {
+	if s == header.Length-1 {
+		return replySize - header.Capacity
+	}
+	return replySize
+}
+
+// shard returns the data of reply s of the thread, padded with zeros to the size of a reply. Replies past the end of
+// the thread are zero. Nil is returned if the reply cannot be read.
+func (f *File) shard(ctx context.Context, t *Thread, s int64) []byte {
+	var shard = make([]byte, f.replySize)
+	if s >= t.Header.Length {
+		return shard
+	}
+	chunk, err := t.chunkAt(ctx, s)
+	if err != nil || len(chunk) > f.replySize {
+		return nil
+	}
+	copy(shard, chunk)
+	return shard
+}
+
+// reconstruct the first size bytes of reply s of bucket i from the replies s of the other threads.
+func (f *File) reconstruct(ctx context.Context, i int, s int64, size int) ([]byte, error) {
+	var threads = f.index.Threads()
+	var shards = make([][]byte, len(threads))
+
+	var grp sync.WaitGroup
+	for r, t := range threads {
+		r, t := r, t
+		if r == i || (r >= len(f.index.Buckets) && s >= t.Header.Length) {
+			continue // parity threads lack the replies of stripes without parity.
+		}
+		grp.Add(1)
+		go func() {
+			defer grp.Done()
+			shards[r] = f.shard(ctx, t, s)
+		}()
+	}
+	grp.Wait()
+
+	data, err := f.erasure.reconstruct(shards, i)
+	if err != nil {
+		return nil, fmt.Errorf("thread %d: reply %d: %w", i, s, err)
+	}
+	return data[:size], nil
+}
+
+// parityWrite records the writes to the parity threads of a batch, so these can be rolled back.
+type parityWrite struct {
+	threads  []*Thread
+	before   []ThreadHeader
+	created  [][]string // IDs of the created replies, by thread.
+	replaced [][]byte   // data of the replaced tail, by thread; nil if the tail was not replaced.
+}
+
+// writeParity updates the parity of the stripes holding the data p written at offset off. As the data of a stripe
+// only grows, the parity is updated by adding the written data to the current parity of the stripe.
+func (f *File) writeParity(ctx context.Context, off int64, p []byte) (*parityWrite, error) {
+	var replySize = int64(f.replySize)
+	var numThreads = int64(len(f.index.Buckets))
+	var first = f.stripeOf(off)
+
+	// the parity of every touched stripe, by parity thread.
+	var parity [][][]byte
+	for pos := off; pos < off+int64(len(p)); {
+		chunk := pos / replySize
+		within := pos % replySize
+		n := min(int(replySize-within), int(off+int64(len(p))-pos))
+
+		s := chunk/numThreads - first
+		for int64(len(parity)) <= s {
+			stripe := make([][]byte, len(f.index.Parity))
+			for j, t := range f.index.Parity {
+				stripe[j] = f.shard(ctx, t, first+int64(len(parity)))
+				if stripe[j] == nil {
+					return nil, fmt.Errorf("read parity thread %d: reply %d cannot be read", t.Header.Number, first+int64(len(parity)))
+				}
+			}
+			parity = append(parity, stripe)
+		}
+
+		f.erasure.update(parity[s], int(chunk%numThreads), int(within), p[pos-off:pos-off+int64(n)])
+		pos += int64(n)
+	}
+
+	var w = &parityWrite{
+		threads:  f.index.Parity,
+		before:   make([]ThreadHeader, len(f.index.Parity)),
+		created:  make([][]string, len(f.index.Parity)),
+		replaced: make([][]byte, len(f.index.Parity)),
+	}
+	grp, ctx := errgroup.WithContext(ctx)
+	for j, t := range f.index.Parity {
+		j, t := j, t
+		w.before[j] = t.Header
+		grp.Go(func() error {
+			for k, stripe := range parity {
+				s := first + int64(k)
+				if s < t.Header.Length {
+					if s != t.Header.Length-1 {
+						return fmt.Errorf("parity thread %d: reply %d is not the tail", t.Header.Number, s)
+					}
+					old, err := t.chunkAt(ctx, s)
+					if err != nil {
+						return err
+					}
+					err = t.replaceReply(ctx, s, t.Header.Tail, stripe[j])
+					if err != nil {
+						return err
+					}
+					w.replaced[j] = old
+					continue
+				}
+
+				err := t.Put(ctx, stripe[j])
+				if err != nil {
+					return err
+				}
+				w.created[j] = append(w.created[j], t.Header.Tail)
+			}
+			return nil
+		})
+	}
+	return w, grp.Wait()
+}
+
+// rollback the writes to the parity threads.
+func (w *parityWrite) rollback(ctx context.Context) error {
+	grp, ctx := errgroup.WithContext(ctx)
+	for j, t := range w.threads {
+		j, t := j, t
+		grp.Go(func() error {
+			for k := len(w.created[j]) - 1; k >= 0; k-- {
+				err := retry(ctx, func() error {
+					client, err := t.service.Take(ctx, 1)
+					if err != nil {
+						return err
+					}
+					return client.RepliesService().Delete(t.FileID, t.CommentID, w.created[j][k]).Context(ctx).Do()
+				})
+				if err != nil {
+					return fmt.Errorf("parity thread %d: delete reply: %w", t.Header.Number, err)
+				}
+			}
+			if w.replaced[j] != nil {
+				err := t.replaceReply(ctx, w.before[j].Length-1, w.before[j].Tail, w.replaced[j])
+				if err != nil {
+					return err
+				}
+			}
+			t.Header = w.before[j]
+			t.oldState = nil
+			t.cache.invalidate()
+			if !t.deferHeader {
+				return t.WriteHeader(ctx)
+			}
+			return nil
+		})
+	}
+	return grp.Wait()
+}
+
+// replaceReply replaces the data of reply i of the thread. The reply must be the tail, or hold a full reply of data.
+func (t *Thread) replaceReply(ctx context.Context, i int64, replyID string, p []byte) error {
+	err := retry(ctx, func() error {
+		client, err := t.service.Take(ctx, 1)
+		if err != nil {
+			return err
+		}
+		_, err = client.RepliesService().
+			Update(t.FileID, t.CommentID, replyID, &drive.Reply{Content: t.Encode(i, p)}).
+			Fields("id").
+			Context(ctx).
+			Do()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("thread %d: replace reply %s: %w", t.Header.Number, replyID, err)
+	}
+	if replyID == t.Header.Tail {
+		t.Header.Capacity = t.replySize - len(p)
+	}
+	t.cache.invalidate()
+	return nil
+}
+
+// rebuildParity computes the parity of the stripes from stripe first onwards anew, and deletes the replies of parity
+// threads past the last stripe. Only the given parity threads are rebuilt; nil rebuilds all.
+func (f *File) rebuildParity(ctx context.Context, threads []*Thread, first int64) error {
+	if threads == nil {
+		threads = f.index.Parity
+	}
+	var stripes = f.stripes()
+
+	// the data threads might have been altered by recovery, so cached replies are dropped.
+	for _, t := range f.index.Buckets {
+		t.cache.invalidate()
+	}
+
+	// the actual state of the parity threads, as a batch might have been interrupted.
+	var replies = make([][]string, len(threads))
+	for j, t := range threads {
+		header, ids, err := inspectThread(ctx, f.service, f.file.Id, t)
+		if err != nil {
+			return err
+		}
+		t.Header, replies[j] = header, ids
+		t.cache.invalidate()
+		if first > t.Header.Length {
+			first = t.Header.Length
+		}
+	}
+
+	for s := first; s < stripes; s++ {
+		parity := make([][]byte, len(f.index.Parity))
+		for j := range parity {
+			parity[j] = make([]byte, f.replySize)
+		}
+		for i, t := range f.index.Buckets {
+			shard := f.shard(ctx, t, s)
+			if shard == nil {
+				return fmt.Errorf("thread %d: reply %d cannot be read", t.Header.Number, s)
+			}
+			f.erasure.update(parity, i, 0, shard)
+		}
+
+		for j, t := range threads {
+			p := parity[t.Header.Number-len(f.index.Buckets)]
+			if s < int64(len(replies[j])) {
+				if err := t.replaceReply(ctx, s, replies[j][s], p); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := t.Put(ctx, p); err != nil {
+				return err
+			}
+			t.oldState = nil
+			replies[j] = append(replies[j], t.Header.Tail)
+		}
+	}
+
+	// replies of stripes which no longer exist are deleted from the end.
+	for j, t := range threads {
+		for int64(len(replies[j])) > stripes {
+			id := replies[j][len(replies[j])-1]
+			err := retry(ctx, func() error {
+				client, err := f.service.Take(ctx, 1)
+				if err != nil {
+					return err
+				}
+				return client.RepliesService().Delete(f.file.Id, t.CommentID, id).Context(ctx).Do()
+			})
+			if err != nil {
+				return fmt.Errorf("parity thread %d: delete reply %s: %w", t.Header.Number, id, err)
+			}
+			replies[j] = replies[j][:len(replies[j])-1]
+		}
+		t.Header.Length, t.Header.Tail, t.Header.Capacity = int64(len(replies[j])), "", 0
+		if len(replies[j]) > 0 {
+			t.Header.Tail = replies[j][len(replies[j])-1]
+		}
+		t.cache.invalidate()
+		if !t.deferHeader {
+			if err := t.WriteHeader(ctx); err != nil {
+				return fmt.Errorf("parity thread %d: write header: %w", t.Header.Number, err)
+			}
+		}
+	}
+	return nil
+}
+
+// RebuildParity computes the parity of the last stripe anew and deletes the parity of stripes past the end of the
+// file, as needed after the data of the file is truncated. It is a no-op for files without parity.
+func (f *File) RebuildParity(ctx context.Context) error {
+	if f.erasure == nil {
+		return nil
+	}
+	if err := f.RecoverCtx(ctx); err != nil {
+		return err
+	}
+	var last = f.stripes() - 1
+	if last < 0 {
+		last = 0
+	}
+	err := f.rebuildParity(ctx, nil, last)
+	if err != nil {
+		return err
+	}
+	err = f.commit(ctx)
+	if err != nil {
+		return err
+	}
+	return f.flushTables(ctx, true)
+}
+
+// RebuildThread replaces a lost or damaged thread by a new comment, of which the replies are reconstructed from the
+// other threads. Any comment of the thread is deleted afterwards. Parity is computed from every data thread, so lost
+// data threads are rebuilt before lost parity threads.
+func (f *File) RebuildThread(ctx context.Context, number int) error {
+	if f.erasure == nil {
+		return errors.New("file has no parity threads")
+	}
+	if err := f.RecoverCtx(ctx); err != nil {
+		return err
+	}
+	var threads = f.index.Threads()
+	if number < 0 || number >= len(threads) {
+		return fmt.Errorf("thread %d does not exist", number)
+	}
+
+	var t = threads[number]
+	var target = t.Header
+	var old = t.CommentID
+	if t.lost {
+		old = ""
+	}
+
+	// the new comment starts empty, and is filled in order.
+	header := ThreadHeader{Number: number, UUID: uuid.New()}
+	var comment *drive.Comment
+	err := retry(ctx, func() error {
+		client, err := f.service.Take(ctx, 1)
+		if err != nil {
+			return err
+		}
+		comment, err = client.CommentsService().
+			Create(f.file.Id, &drive.Comment{Content: string(header.MustMarshall())}).
+			Fields("id").
+			Context(ctx).
+			Do()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("create thread %d: %w", number, err)
+	}
+
+	// reconstructing reads every other thread; the thread itself is excluded.
+	var rebuilt = *t
+	rebuilt.CommentID, rebuilt.Header, rebuilt.lost = comment.Id, header, false
+	rebuilt.cache, rebuilt.table = newReplyCache(), &replyTable{}
+
+	if number < len(f.index.Buckets) {
+		for s := int64(0); s < target.Length; s++ {
+			chunk, err := f.reconstruct(ctx, number, s, chunkLen(target, s, f.replySize))
+			if err != nil {
+				return err
+			}
+			if err = rebuilt.Put(ctx, chunk); err != nil {
+				return err
+			}
+		}
+		if rebuilt.Header.Length != target.Length || rebuilt.Header.Capacity != target.Capacity {
+			return fmt.Errorf("thread %d: rebuilt %d replies; expected %d", number, rebuilt.Header.Length, target.Length)
+		}
+		rebuilt.oldState = nil
+		*t = rebuilt
+	} else {
+		*t = rebuilt
+		err = f.rebuildParity(ctx, []*Thread{t}, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = f.commit(ctx)
+	if err == nil {
+		err = f.flushTables(ctx, true)
+	}
+	if err != nil {
+		return err
+	}
+
+	if old == "" {
+		return nil
+	}
+	return retry(ctx, func() error {
+		client, err := f.service.Take(ctx, 1)
+		if err != nil {
+			return err
+		}
+		return client.CommentsService().Delete(f.file.Id, old).Context(ctx).Do()
+	})
+}
@@ -0,0 +1,108 @@
+package drfs_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+	"github.com/kaiserkarel/drfs/fake"
+)
+
+func deleteThread(t *testing.T, service drfs.Service, thread *drfs.Thread) {
+	client, err := service.Take(context.Background(), 1)
+	require.NoError(t, err)
+	require.NoError(t, client.CommentsService().Delete(thread.FileID, thread.CommentID).Do())
+}
+
+func TestParity(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+
+	file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: 3, ParityThreads: 2, Codec: drfs.Base64})
+	require.NoError(t, err)
+	require.Len(t, file.Index().Buckets, 3)
+	require.Len(t, file.Index().Parity, 2)
+
+	// partial replies are appended to, which updates the parity of a stripe more than once.
+	payload := binaryPayload(7*file.ReplySize() + 100)
+	for _, part := range [][2]int{{0, 10}, {10, 2*file.ReplySize() + 5}, {2*file.ReplySize() + 5, len(payload)}} {
+		_, err = file.WriteCtx(context.Background(), payload[part[0]:part[1]])
+		require.NoError(t, err)
+	}
+	assertConsistent(t, file, len(payload))
+	assert.Equal(t, int64(3), file.Index().Parity[0].Header.Length)
+
+	// any two threads can be lost.
+	for _, lost := range [][2]int{{0, 2}, {1, 3}, {3, 4}} {
+		reopened := reopen(t, service, file)
+		threads := reopened.Index().Threads()
+		deleteThread(t, service, threads[lost[0]])
+		deleteThread(t, service, threads[lost[1]])
+
+		reopened = reopen(t, service, file)
+		threads = reopened.Index().Threads()
+		assert.True(t, threads[lost[0]].Lost())
+		assert.True(t, threads[lost[1]].Lost())
+
+		got, err := ioutil.ReadAll(reopened)
+		require.NoError(t, err)
+		assert.Equal(t, payload, got, "threads %v should be reconstructed", lost)
+
+		_, err = reopened.Write([]byte("more"))
+		assert.True(t, errors.Is(err, drfs.ErrThreadLost))
+
+		for _, number := range lost {
+			require.NoError(t, reopened.RebuildThread(context.Background(), number))
+		}
+		assertConsistent(t, reopened, len(payload))
+	}
+
+	reopened := reopen(t, service, file)
+	for _, thread := range reopened.Index().Threads() {
+		assert.False(t, thread.Lost())
+	}
+	_, err = reopened.WriteCtx(context.Background(), payload)
+	require.NoError(t, err)
+
+	// the parity written after rebuilding covers the data.
+	reopened = reopen(t, service, file)
+	deleteThread(t, service, reopened.Index().Buckets[1])
+	reopened = reopen(t, service, file)
+	got, err := ioutil.ReadAll(reopened)
+	require.NoError(t, err)
+	assert.Equal(t, append(append([]byte{}, payload...), payload...), got)
+}
+
+func TestParityDamagedReply(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	payload := binaryPayload(4*drfs.EffectiveReplySize + 7)
+	file := writeFile(t, server, drfs.FileOptions{NumThreads: 2, ParityThreads: 1, Codec: drfs.Base64, Checksums: true}, payload)
+
+	// alter the data of a reply, keeping a valid encoding.
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+	client, err := service.Take(context.Background(), 1)
+	require.NoError(t, err)
+	thread := file.Index().Buckets[1]
+	list, err := client.RepliesService().List(thread.FileID, thread.CommentID).Fields("*").Do()
+	require.NoError(t, err)
+	content := []byte(list.Replies[0].Content)
+	content[len(content)-2] ^= 'A' ^ 'B'
+	_, err = client.RepliesService().Update(thread.FileID, thread.CommentID, list.Replies[0].Id, &drive.Reply{Content: string(content)}).Do()
+	require.NoError(t, err)
+
+	got, err := ioutil.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, payload, got)
+}
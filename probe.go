@@ -0,0 +1,173 @@
+package drfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// Densest is an experimental option for FileOptions.Codec, selecting the registered codec which stores the most bytes
+// per reply. The limits of a reply are probed against the backend when creating the file.
+const Densest = "densest"
+
+// probeRunes are the characters used to probe the limit of each class.
+var probeRunes = map[Class]rune{
+	ASCII:         'x',
+	BMP:           '一',
+	Supplementary: '\U00020000',
+}
+
+// ProbeLimits measures the maximum number of characters of each class Drive accepts in a reply, using a temporary
+// comment on the file.
+func ProbeLimits(ctx context.Context, s Service, fileID string) (Limits, error) {
+	var limits = make(Limits)
+	err := withProbeComment(ctx, s, fileID, func(commentID string) error {
+		for class, r := range probeRunes {
+			// largest k for which a reply of k characters is accepted.
+			lower, upper := 0, 2*MaxReplySize
+			for lower < upper {
+				k := (lower + upper + 1) / 2
+				content := strings.Repeat(string(r), k)
+				ok, err := probe(ctx, s, fileID, commentID, content, func(stored string) bool { return stored == content })
+				if err != nil {
+					return err
+				}
+				if ok {
+					lower = k
+				} else {
+					upper = k - 1
+				}
+			}
+			limits[class] = lower
+		}
+		return nil
+	})
+	return limits, err
+}
+
+// ProbeCodec selects the registered codec which stores the most bytes per reply under the limits, and verifies that
+// Drive stores its encoding without alteration. The codec and the number of bytes stored per reply are returned.
+func ProbeCodec(ctx context.Context, s Service, fileID string, limits Limits) (Codec, int, error) {
+	codecs.RLock()
+	var candidates []Codec
+	for _, c := range codecs.m {
+		candidates = append(candidates, c)
+	}
+	codecs.RUnlock()
+
+	sort.Slice(candidates, func(i, j int) bool {
+		ci, cj := limits.Capacity(candidates[i]), limits.Capacity(candidates[j])
+		if ci == cj {
+			return candidates[i].ID() < candidates[j].ID()
+		}
+		return ci > cj
+	})
+
+	var selected Codec
+	var size int
+	err := withProbeComment(ctx, s, fileID, func(commentID string) error {
+		rng := rand.New(rand.NewSource(1))
+		for _, c := range candidates {
+			if limits.Capacity(c) == 0 {
+				continue
+			}
+
+			sample := make([]byte, limits.Capacity(c))
+			rng.Read(sample)
+
+			ok, err := probe(ctx, s, fileID, commentID, encodeReply(c, sample), func(stored string) bool {
+				data, err := decodeReply(c, stored)
+				return err == nil && string(data) == string(sample)
+			})
+			if err != nil {
+				return err
+			}
+			if ok {
+				selected, size = c, len(sample)
+				return nil
+			}
+		}
+		return errors.New("no codec is stored without alteration")
+	})
+	return selected, size, err
+}
+
+// probeCodec probes the limits of a reply, and selects the densest codec under these limits.
+func probeCodec(ctx context.Context, s Service, fileID string) (Codec, int, error) {
+	limits, err := ProbeLimits(ctx, s, fileID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ProbeCodec(ctx, s, fileID, limits)
+}
+
+// withProbeComment calls fn with a temporary comment, which is deleted afterwards.
+func withProbeComment(ctx context.Context, s Service, fileID string, fn func(commentID string) error) error {
+	var comment *drive.Comment
+	err := retry(ctx, func() error {
+		client, err := s.Take(ctx, 1)
+		if err != nil {
+			return err
+		}
+		comment, err = client.CommentsService().
+			Create(fileID, &drive.Comment{Content: "probe"}).
+			Fields("id").
+			Context(ctx).
+			Do()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("create probe comment: %w", err)
+	}
+
+	err = fn(comment.Id)
+
+	deleteErr := retry(ctx, func() error {
+		client, err := s.Take(ctx, 1)
+		if err != nil {
+			return err
+		}
+		return client.CommentsService().Delete(fileID, comment.Id).Context(ctx).Do()
+	})
+	if err != nil {
+		return err
+	}
+	if deleteErr != nil {
+		return fmt.Errorf("delete probe comment: %w", deleteErr)
+	}
+	return nil
+}
+
+// probe creates a reply with the content, reporting whether Drive accepted it and stored it as verified by the
+// check. Replies which Drive rejects with a 400 are not an error.
+func probe(ctx context.Context, s Service, fileID string, commentID string, content string, check func(stored string) bool) (bool, error) {
+	var reply *drive.Reply
+	err := retry(ctx, func() error {
+		client, err := s.Take(ctx, 1)
+		if err != nil {
+			return err
+		}
+		reply, err = client.RepliesService().
+			Create(fileID, commentID, &drive.Reply{Content: content}).
+			Fields("content").
+			Context(ctx).
+			Do()
+		return err
+	})
+
+	var apiError *googleapi.Error
+	if errors.As(err, &apiError) && apiError.Code == http.StatusBadRequest {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("create probe reply: %w", err)
+	}
+	return check(reply.Content), nil
+}
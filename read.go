@@ -2,6 +2,7 @@ package drfs
 
 import (
 	"context"
+	"errors"
 	"io"
 	"sync"
 )
@@ -10,36 +11,111 @@ func (f *File) Read(p []byte) (int, error) {
 	return f.ReadCtx(context.Background(), p)
 }
 
+// ReadCtx reads len(p) bytes starting at the offset set by Seek, unless the end of the file is reached first.
 func (f *File) ReadCtx(ctx context.Context, p []byte) (int, error) {
 	var n int
-	for n <= len(p) {
-		a, err := f.ReadBatch(context.TODO(), p[n:])
+	for n < len(p) {
+		a, err := f.ReadBatch(ctx, p[n:])
 		n += a
-		if err != nil || a == 0 {
+		if err != nil {
 			return n, err
 		}
 	}
-	return n, io.EOF
+	return n, nil
 }
 
+// ReadBatch reads at most a single reply of every thread concurrently into p, starting at the offset set by Seek. Files
+// read sequentially from the start are verified against the hash in their FileHeader at the end of the file, returning
+// ErrHashMismatch instead of io.EOF if the content does not match.
 func (f *File) ReadBatch(ctx context.Context, p []byte) (int, error) {
-	var numbuckets = len(f.index.Buckets)
-	var segments = slice(p, EffectiveReplySize)
-	var read = make([]int, numbuckets)
-	var errs = make([]error, numbuckets)
+	if f.compression != nil {
+		n, err := f.readCompressedAt(ctx, p, f.offset)
+		f.offset += int64(n)
+		return n, err
+	}
+
+	if f.offset >= f.size() {
+		return 0, f.verifyRead()
+	}
+
+	n, err := f.readBatchAt(ctx, p, f.offset)
+	f.hashRead(p[:n], f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	return f.ReadAtCtx(context.Background(), p, off)
+}
+
+// ReadAtCtx reads len(p) bytes starting at offset off, without altering the offset used by Read. Data is striped
+// round-robin across the threads in chunks of ReplySize bytes, so chunk c is stored in reply c / NumThreads of thread
+// c % NumThreads. Only the pages of replies containing the requested chunks are listed.
+func (f *File) ReadAtCtx(ctx context.Context, p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("drfs: negative offset")
+	}
+	if f.compression != nil {
+		return f.readCompressedAt(ctx, p, off)
+	}
+	return f.readStoredAt(ctx, p, off)
+}
+
+// readStoredAt reads len(p) bytes of the data stored at offset off, which is compressed for compressed files.
+func (f *File) readStoredAt(ctx context.Context, p []byte, off int64) (int, error) {
+	var size = f.size()
+	var n int
+	for n < len(p) && off+int64(n) < size {
+		a, err := f.readBatchAt(ctx, p[n:], off+int64(n))
+		n += a
+		if err != nil {
+			return n, err
+		}
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readBatchAt reads the chunks starting at offset off into p, at most a single chunk per thread. Chunks are read
+// concurrently.
+func (f *File) readBatchAt(ctx context.Context, p []byte, off int64) (int, error) {
+	var numbuckets = int64(len(f.index.Buckets))
+	var replySize = int64(f.replySize)
+	var end = off + int64(len(p))
+	if size := f.size(); end > size {
+		end = size
+	}
+
+	var segments []bounds
+	var chunks []int64
+	for pos := off; pos < end && len(segments) < int(numbuckets); {
+		chunk := pos / replySize
+		upper := (chunk + 1) * replySize
+		if upper > end {
+			upper = end
+		}
+
+		segments = append(segments, bounds{lower: int(pos - off), upper: int(upper - off)})
+		chunks = append(chunks, chunk)
+		pos = upper
+	}
+
+	var read = make([]int, len(segments))
+	var errs = make([]error, len(segments))
 	var grp sync.WaitGroup
 
-	for i := 0; i < numbuckets && i < len(segments); i++ {
-		i := i
-		segment := segments[i]
-		bucket := f.readers.Get()
+	for i, segment := range segments {
+		i, segment := i, segment
+		bucket := int(chunks[i] % numbuckets)
+		offset := chunks[i]/numbuckets*replySize + int64(segment.lower) + off - chunks[i]*replySize
 
 		grp.Add(1)
 		go func() {
 			defer grp.Done()
-			n, err := bucket.ReadCtx(context.TODO(), p[segment.lower:segment.upper])
-			read[i] = n
-			errs[i] = err
+			read[i], errs[i] = f.readThreadAt(ctx, bucket, p[segment.lower:segment.upper], offset)
 		}()
 	}
 	grp.Wait()
@@ -51,6 +127,9 @@ func (f *File) ReadBatch(ctx context.Context, p []byte) (int, error) {
 		if err := errs[i]; err != nil {
 			return total, err
 		}
+		if n < segments[i].upper-segments[i].lower {
+			return total, io.ErrUnexpectedEOF
+		}
 	}
 	return total, nil
 }
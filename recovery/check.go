@@ -0,0 +1,258 @@
+package recovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+)
+
+// Problem is an inconsistency between a thread and its replies.
+type Problem struct {
+	Thread  int    // number of the thread.
+	Reply   string // ID of the reply, if the problem concerns a single reply.
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Reply != "" {
+		return fmt.Sprintf("thread %d: reply %s: %s", p.Thread, p.Reply, p.Message)
+	}
+	return fmt.Sprintf("thread %d: %s", p.Thread, p.Message)
+}
+
+// ThreadReport is the result of checking a single thread. Header is the state of the thread derived from its replies.
+type ThreadReport struct {
+	Thread   *drfs.Thread
+	Header   drfs.ThreadHeader
+	Problems []Problem
+
+	damaged bool // whether a reply is damaged, which cannot be repaired by rewriting the header.
+	lost    bool // whether the comment of the thread is missing.
+}
+
+// Stale reports whether the state of the thread in the index differs from the state derived from its replies.
+func (r ThreadReport) Stale() bool {
+	return r.Thread.Header != r.Header
+}
+
+// Report is the result of Check.
+type Report struct {
+	Threads []ThreadReport
+}
+
+// Problems returns the problems of every thread, ordered by thread.
+func (r *Report) Problems() []Problem {
+	var problems []Problem
+	for _, t := range r.Threads {
+		problems = append(problems, t.Problems...)
+	}
+	return problems
+}
+
+// OK reports whether no problems were found.
+func (r *Report) OK() bool {
+	return len(r.Problems()) == 0
+}
+
+// Check verifies the state of every thread, as found in the index of the file, against its replies: the Length must
+// equal the number of replies, the Tail must be the ID of the last reply and the Capacity must match the size of the
+// last reply. Each reply must have its padding intact, match its checksum if the file has checksums, and decode to a
+// full reply, except for the last. Deleted replies left by rollbacks are expected, but the Tail may not refer to one.
+// A deleted reply followed by live replies, where the thread holds fewer replies than its Length, held committed data:
+// the replies after it moved up a place, so the thread is damaged rather than stale. Parity threads are checked alike;
+// lost threads are reported without inspecting replies.
+func Check(file *drfs.File) (*Report, error) {
+	var report = &Report{}
+	for _, b := range file.Index().Threads() {
+		if b.Lost() {
+			report.Threads = append(report.Threads, ThreadReport{
+				Thread:   b,
+				Header:   b.Header,
+				Problems: []Problem{{Thread: b.Header.Number, Message: "thread is lost"}},
+				lost:     true,
+			})
+			continue
+		}
+		r, err := checkThread(context.Background(), file, b)
+		if err != nil {
+			return nil, err
+		}
+		report.Threads = append(report.Threads, r)
+	}
+	return report, nil
+}
+
+func checkThread(ctx context.Context, file *drfs.File, b *drfs.Thread) (ThreadReport, error) {
+	var report = ThreadReport{Thread: b, Header: b.Header}
+	var number = b.Header.Number
+	var problem = func(reply string, format string, args ...interface{}) {
+		report.Problems = append(report.Problems, Problem{Thread: number, Reply: reply, Message: fmt.Sprintf(format, args...)})
+	}
+
+	live, deleted, err := listReplies(ctx, file, b)
+	if err != nil {
+		return report, err
+	}
+
+	var capacity int
+	for i, reply := range live {
+		data, err := b.Decode(int64(i), reply.Content)
+		switch {
+		case errors.Is(err, drfs.ErrPaddingDamaged):
+			report.damaged = true
+			problem(reply.Id, "padding is damaged")
+			continue
+		case errors.Is(err, drfs.ErrChecksum):
+			report.damaged = true
+			problem(reply.Id, "checksum mismatch")
+			continue
+		case err != nil:
+			report.damaged = true
+			problem(reply.Id, "cannot be decoded: %s", err)
+			continue
+		}
+
+		if i < len(live)-1 && len(data) != file.ReplySize() {
+			report.damaged = true
+			problem(reply.Id, "holds %d bytes; expected %d", len(data), file.ReplySize())
+		}
+		capacity = file.ReplySize() - len(data)
+	}
+
+	report.Header.Length, report.Header.Tail, report.Header.Capacity = int64(len(live)), "", 0
+	if len(live) > 0 {
+		report.Header.Tail = live[len(live)-1].Id
+		report.Header.Capacity = capacity
+	}
+
+	// replies deleted by rollbacks were never committed, so the live replies still make up the Length.
+	if int64(len(live)) < b.Header.Length {
+		for _, id := range deleted.ids {
+			if after := len(live) - deleted.position[id]; after > 0 {
+				report.damaged = true
+				problem(id, "reply is deleted; %d replies follow it", after)
+			}
+		}
+	}
+
+	if _, ok := deleted.position[b.Header.Tail]; ok {
+		problem(b.Header.Tail, "tail is deleted")
+	}
+	if b.Header.Length != report.Header.Length {
+		problem("", "length is %d; found %d replies", b.Header.Length, report.Header.Length)
+	}
+	if b.Header.Tail != report.Header.Tail {
+		problem("", "tail is %q; last reply is %q", b.Header.Tail, report.Header.Tail)
+	}
+	if b.Header.Capacity != report.Header.Capacity {
+		problem("", "capacity is %d; last reply leaves %d", b.Header.Capacity, report.Header.Capacity)
+	}
+	return report, nil
+}
+
+// Repair rewrites the state of every stale thread in the report to the state derived from its replies. Damaged replies
+// cannot be repaired, unless the file has parity threads: lost threads and threads holding damaged replies are then
+// rebuilt from parity using RebuildThread. Else, nothing is repaired and the damaged threads are reported in the
+// returned error: their headers are kept, and committing the other threads would hash the damaged content.
+func Repair(file *drfs.File, report *Report) error {
+	var ctx = context.Background()
+	var parity = len(file.Index().Parity) > 0
+	var damaged, rebuild []int
+	var stale []ThreadReport
+	for _, r := range report.Threads {
+		if parity && (r.damaged || r.lost) {
+			rebuild = append(rebuild, r.Header.Number)
+			continue
+		}
+		if r.damaged || r.lost {
+			damaged = append(damaged, r.Header.Number)
+			continue
+		}
+		if r.Stale() {
+			stale = append(stale, r)
+		}
+	}
+	if len(damaged) > 0 {
+		return fmt.Errorf("threads %v hold damaged replies", damaged)
+	}
+
+	var altered = make([]*drfs.Thread, len(stale))
+	for i, r := range stale {
+		r.Thread.Header = r.Header
+		altered[i] = r.Thread
+	}
+	err := commitHeaders(ctx, file, altered)
+	if err != nil {
+		return err
+	}
+
+	// threads are reported in order of number, so data threads are rebuilt before parity threads.
+	for _, number := range rebuild {
+		err = RebuildThread(file, number)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commitHeaders commits the state of the altered threads; to the manifest or, for files without one, to their
+// ThreadHeaders.
+func commitHeaders(ctx context.Context, file *drfs.File, altered []*drfs.Thread) error {
+	if file.Index().Header.Manifest {
+		err := file.SyncCtx(ctx)
+		if err != nil {
+			return fmt.Errorf("commit manifest: %w", err)
+		}
+		return nil
+	}
+
+	for _, t := range altered {
+		err := t.WriteHeader(ctx)
+		if err != nil {
+			return fmt.Errorf("write header of thread %d: %w", t.Header.Number, err)
+		}
+	}
+	return nil
+}
+
+// deletedReplies are the deleted replies of a thread, in order.
+type deletedReplies struct {
+	ids      []string
+	position map[string]int // number of live replies preceding each deleted reply.
+}
+
+// listReplies lists the replies of a thread in order, and its deleted replies.
+func listReplies(ctx context.Context, file *drfs.File, b *drfs.Thread) ([]*drive.Reply, deletedReplies, error) {
+	client, err := file.Service().Take(ctx, int(b.Header.Length/drfs.MaxPages)+2)
+	if err != nil {
+		return nil, deletedReplies{}, err
+	}
+
+	var live []*drive.Reply
+	var deleted = deletedReplies{position: make(map[string]int)}
+	err = client.RepliesService().
+		List(b.FileID, b.CommentID).
+		IncludeDeleted(true).
+		Fields("*").
+		PageSize(drfs.MaxPages).
+		Pages(ctx, func(list *drive.ReplyList) error {
+			for _, reply := range list.Replies {
+				if reply.Deleted {
+					deleted.ids = append(deleted.ids, reply.Id)
+					deleted.position[reply.Id] = len(live)
+					continue
+				}
+				live = append(live, reply)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, deletedReplies{}, fmt.Errorf("list replies of thread %d: %w", b.Header.Number, err)
+	}
+	return live, deleted, nil
+}
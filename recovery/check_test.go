@@ -0,0 +1,139 @@
+package recovery_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+	"github.com/kaiserkarel/drfs/fake"
+	"github.com/kaiserkarel/drfs/recovery"
+)
+
+func TestCheck(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+
+	file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: 3})
+	require.NoError(t, err)
+	_, err = file.WriteCtx(context.Background(), make([]byte, 7*drfs.EffectiveReplySize+10))
+	require.NoError(t, err)
+
+	report, err := recovery.Check(file)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), report.Problems())
+
+	// commit a stale state of the first thread.
+	thread := file.Index().Buckets[0]
+	tail := thread.Header.Tail
+	thread.Header.Tail = "stale"
+	require.NoError(t, file.Sync())
+
+	stat, err := file.Fstat()
+	require.NoError(t, err)
+	reopened, err := drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+	require.NoError(t, err)
+
+	report, err = recovery.Check(reopened)
+	require.NoError(t, err)
+	require.Len(t, report.Problems(), 1)
+	assert.Equal(t, 0, report.Problems()[0].Thread)
+	assert.Equal(t, tail, report.Threads[0].Header.Tail)
+
+	require.NoError(t, recovery.Repair(reopened, report))
+
+	reopened, err = drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+	require.NoError(t, err)
+	report, err = recovery.Check(reopened)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), report.Problems())
+
+	got := make([]byte, 7*drfs.EffectiveReplySize+10)
+	_, err = reopened.ReadAt(got, 0)
+	require.NoError(t, err)
+}
+
+func TestCheckDamagedReply(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+
+	file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: 2})
+	require.NoError(t, err)
+	_, err = file.WriteCtx(context.Background(), make([]byte, 3*drfs.EffectiveReplySize))
+	require.NoError(t, err)
+
+	// strip the padding of the first reply of the second thread, as Drive strips leading and trailing spaces.
+	client, err := service.Take(context.Background(), 1)
+	require.NoError(t, err)
+	thread := file.Index().Buckets[1]
+	list, err := client.RepliesService().List(thread.FileID, thread.CommentID).Fields("*").Do()
+	require.NoError(t, err)
+	reply := list.Replies[0]
+	_, err = client.RepliesService().
+		Update(thread.FileID, thread.CommentID, reply.Id, &drive.Reply{Content: strings.Trim(reply.Content, "1")}).
+		Do()
+	require.NoError(t, err)
+
+	report, err := recovery.Check(file)
+	require.NoError(t, err)
+	require.Equal(t, []recovery.Problem{{Thread: 1, Reply: reply.Id, Message: "padding is damaged"}}, report.Problems())
+
+	assert.Error(t, recovery.Repair(file, report))
+}
+
+func TestCheckDeletedReply(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+
+	file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: 2})
+	require.NoError(t, err)
+	_, err = file.WriteCtx(context.Background(), make([]byte, 3*drfs.EffectiveReplySize+10))
+	require.NoError(t, err)
+	require.NoError(t, file.Sync())
+
+	// a rollback leaves a deleted reply followed by the reply written in its place, which is expected.
+	thread := file.Index().Buckets[1]
+	require.NoError(t, thread.Put(context.Background(), []byte("rolled back")))
+	require.NoError(t, thread.Rollback(context.Background(), service, thread.FileID))
+	_, err = file.WriteCtx(context.Background(), make([]byte, 2*drfs.EffectiveReplySize))
+	require.NoError(t, err)
+	report, err := recovery.Check(file)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), report.Problems())
+
+	// deleting a committed reply shifts the replies after it, which is damage.
+	client, err := service.Take(context.Background(), 1)
+	require.NoError(t, err)
+	thread = file.Index().Buckets[0]
+	list, err := client.RepliesService().List(thread.FileID, thread.CommentID).Do()
+	require.NoError(t, err)
+	require.Len(t, list.Replies, 3)
+	require.NoError(t, client.RepliesService().Delete(thread.FileID, thread.CommentID, list.Replies[0].Id).Do())
+
+	report, err = recovery.Check(file)
+	require.NoError(t, err)
+	assert.Contains(t, report.Problems(), recovery.Problem{Thread: 0, Reply: list.Replies[0].Id, Message: "reply is deleted; 2 replies follow it"})
+
+	// the thread is not repaired by rewriting its Length.
+	var header = thread.Header
+	assert.Error(t, recovery.Repair(file, report))
+	assert.Equal(t, header, thread.Header)
+	stat, err := file.Fstat()
+	require.NoError(t, err)
+	reopened, err := drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+	require.NoError(t, err)
+	assert.Equal(t, header, reopened.Index().Buckets[0].Header)
+}
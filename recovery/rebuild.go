@@ -0,0 +1,18 @@
+package recovery
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kaiserkarel/drfs"
+)
+
+// RebuildThread replaces a lost or damaged thread of a file with parity threads. The replies of the thread are
+// reconstructed from the other threads, so at most FileOptions.ParityThreads threads may be unreadable at once.
+func RebuildThread(file *drfs.File, number int) error {
+	err := file.RebuildThread(context.Background(), number)
+	if err != nil {
+		return fmt.Errorf("rebuild thread %d: %w", number, err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package recovery_test
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+	"github.com/kaiserkarel/drfs/fake"
+	"github.com/kaiserkarel/drfs/recovery"
+)
+
+func TestRepairParity(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+
+	file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: 3, ParityThreads: 2, Codec: drfs.Base85})
+	require.NoError(t, err)
+
+	var replySize = file.ReplySize()
+	var payload = strings.Repeat("lorem ipsum dolor sit amet ", 8*replySize)[:7*replySize+replySize/2]
+	_, err = file.WriteCtx(context.Background(), []byte(payload))
+	require.NoError(t, err)
+
+	// a data thread is lost and a parity thread holds a damaged reply.
+	client, err := service.Take(context.Background(), 1)
+	require.NoError(t, err)
+	lost := file.Index().Buckets[1]
+	require.NoError(t, client.CommentsService().Delete(lost.FileID, lost.CommentID).Do())
+	parity := file.Index().Parity[0]
+	_, err = client.RepliesService().Update(parity.FileID, parity.CommentID, parity.Header.Tail, &drive.Reply{Content: "damaged"}).Do()
+	require.NoError(t, err)
+
+	stat, err := file.Fstat()
+	require.NoError(t, err)
+	reopened, err := drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+	require.NoError(t, err)
+
+	report, err := recovery.Check(reopened)
+	require.NoError(t, err)
+	require.Len(t, report.Threads, 5)
+	assert.Equal(t, []string{"thread 1: thread is lost", "thread 3: reply " + parity.Header.Tail + ": padding is damaged"}, problems(report))
+
+	require.NoError(t, recovery.Repair(reopened, report))
+
+	reopened, err = drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+	require.NoError(t, err)
+	report, err = recovery.Check(reopened)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%v", report.Problems())
+
+	got, err := ioutil.ReadAll(reopened)
+	require.NoError(t, err)
+	assert.Equal(t, payload, string(got))
+
+	// trimming rebuilds the parity of the last stripe.
+	_, err = recovery.Trim(reopened, int64(4*replySize+10))
+	require.NoError(t, err)
+	reopened, err = drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), reopened.Index().Parity[0].Header.Length)
+	require.NoError(t, recovery.RebuildThread(reopened, 0))
+	got, err = ioutil.ReadAll(reopened)
+	require.NoError(t, err)
+	assert.Equal(t, payload[:4*replySize+10], string(got))
+}
+
+func problems(report *recovery.Report) []string {
+	var res []string
+	for _, p := range report.Problems() {
+		res = append(res, p.String())
+	}
+	return res
+}
@@ -0,0 +1,265 @@
+package recovery
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+)
+
+// Reindex rebuilds the index of a file which can no longer be opened, as its FileHeader or ThreadHeaders are lost or
+// corrupted. Every comment of the file is scanned: comments holding replies but no valid ThreadHeader are threads of
+// which the header is lost, and are numbered by the order in which they were created. The state of each thread is
+// rewritten from its replies.
+//
+// The fallback header is used if the FileHeader is lost. Its NumThreads may be zero to use the number of threads found,
+// less its ParityThreads; its Codec must be that of the file, as it cannot be told from the replies. Any manifest and
+// journal are deleted, as the state they record cannot be trusted; once the state of each thread is rewritten in its
+// ThreadHeader, a new manifest and journal are created from it. Threads holding damaged replies are reported as by
+// Repair. Encrypted files are opened using the Key of the fallback; the encryption of the file cannot be recovered if
+// its FileHeader is lost.
+func Reindex(service drfs.Service, file *drive.File, fallback drfs.FileHeader) (*drfs.File, error) {
+	var ctx = context.Background()
+
+	comments, err := listComments(ctx, service, file.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileheader *drfs.FileHeader
+	var fileheaderID string
+	var threads []*candidate // comments with a valid ThreadHeader.
+	var lost []*candidate    // comments with replies but no valid header.
+	var empty []*candidate   // comments without replies or a valid header.
+	var tables []*candidate  // reply tables.
+	var obsolete []string    // comments to delete: manifests, journals and surplus file headers.
+	for _, comment := range comments {
+		c := &candidate{comment: comment}
+		c.created, _ = time.Parse(time.RFC3339Nano, comment.CreatedTime)
+
+		if header, err := drfs.ThreadHeaderFromJSON(strings.NewReader(comment.Content)); err == nil {
+			c.header = *header
+			threads = append(threads, c)
+			continue
+		}
+		if header, err := drfs.TableHeaderFromJSON(strings.NewReader(comment.Content)); err == nil {
+			c.header.UUID = header.UUID
+			tables = append(tables, c)
+			continue
+		}
+		if _, err := drfs.ManifestHeaderFromJSON(strings.NewReader(comment.Content)); err == nil {
+			obsolete = append(obsolete, comment.Id)
+			continue
+		}
+		if _, err := drfs.JournalRecordFromJSON(strings.NewReader(comment.Content)); err == nil {
+			obsolete = append(obsolete, comment.Id)
+			continue
+		}
+		if header, err := drfs.FileHeaderFromJSON(strings.NewReader(comment.Content)); err == nil {
+			if fileheader != nil {
+				obsolete = append(obsolete, comment.Id)
+				continue
+			}
+			fileheader, fileheaderID = header, comment.Id
+			continue
+		}
+		if hasReplies(comment) {
+			lost = append(lost, c)
+		} else {
+			empty = append(empty, c)
+		}
+	}
+
+	if fileheader == nil {
+		fileheader = &fallback
+	}
+	if _, err := drfs.CodecByID(fileheader.Codec); err != nil {
+		return nil, err
+	}
+
+	// parity threads are numbered after the data threads.
+	var numThreads = fileheader.NumThreads + fileheader.ParityThreads
+	if fileheader.NumThreads == 0 {
+		numThreads = len(threads) + len(lost)
+	}
+
+	// threads keep their number, unless it is out of range or taken by a thread created earlier.
+	sort.SliceStable(threads, func(i, j int) bool { return threads[i].created.Before(threads[j].created) })
+	var numbered = make([]*candidate, numThreads)
+	for _, c := range threads {
+		if c.header.Number >= 0 && c.header.Number < numThreads && numbered[c.header.Number] == nil {
+			numbered[c.header.Number] = c
+			continue
+		}
+		c.header = drfs.ThreadHeader{}
+		lost = append(lost, c)
+	}
+
+	// threads of which the number is lost take the missing numbers in order of creation. Comments without replies
+	// are empty threads if numbers remain; else these are the remains of a corrupted FileHeader.
+	sort.SliceStable(lost, func(i, j int) bool { return lost[i].created.Before(lost[j].created) })
+	sort.SliceStable(empty, func(i, j int) bool { return empty[i].created.Before(empty[j].created) })
+	var unnumbered = append(lost, empty...)
+	for i := range numbered {
+		if numbered[i] != nil {
+			continue
+		}
+		if len(unnumbered) == 0 {
+			return nil, fmt.Errorf("thread %d is missing", i)
+		}
+		c := unnumbered[0]
+		unnumbered = unnumbered[1:]
+		c.header = drfs.ThreadHeader{Number: i, UUID: uuid.New()}
+		numbered[i] = c
+	}
+	for _, c := range unnumbered {
+		if hasReplies(c.comment) {
+			return nil, fmt.Errorf("found more than %d threads", numThreads)
+		}
+		obsolete = append(obsolete, c.comment.Id)
+	}
+
+	// tables of threads which were assigned a new UUID no longer belong to any thread.
+	var uuids = make(map[uuid.UUID]bool)
+	for _, c := range numbered {
+		uuids[c.header.UUID] = true
+	}
+	for _, c := range tables {
+		if !uuids[c.header.UUID] {
+			obsolete = append(obsolete, c.comment.Id)
+		}
+	}
+
+	for _, c := range numbered {
+		content := string(c.header.MustMarshall())
+		if content == c.comment.Content {
+			continue
+		}
+		err = writeComment(ctx, service, file.Id, c.comment.Id, content)
+		if err != nil {
+			return nil, fmt.Errorf("write header of thread %d: %w", c.header.Number, err)
+		}
+	}
+
+	var header = *fileheader
+	header.NumThreads = numThreads - header.ParityThreads
+	header.Manifest = false
+	err = writeComment(ctx, service, file.Id, fileheaderID, string(header.MustMarshall()))
+	if err != nil {
+		return nil, fmt.Errorf("write file header: %w", err)
+	}
+
+	for _, id := range obsolete {
+		err = deleteComment(ctx, service, file.Id, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the state of the threads is rewritten from their replies once the file can be opened.
+	f, err := drfs.OpenWithKeyCtx(ctx, file, service, fallback.Key)
+	if err != nil {
+		return nil, err
+	}
+	report, err := Check(f)
+	if err != nil {
+		return nil, err
+	}
+	err = Repair(f, report)
+	if err != nil {
+		return nil, err
+	}
+
+	// reopen the file, as writes continue at the position derived from the state of the threads.
+	f, err = drfs.OpenWithKeyCtx(ctx, file, service, fallback.Key)
+	if err != nil {
+		return nil, err
+	}
+	err = f.AddManifestCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// candidate is a comment which might be a thread.
+type candidate struct {
+	comment *drive.Comment
+	created time.Time
+	header  drfs.ThreadHeader
+}
+
+func hasReplies(comment *drive.Comment) bool {
+	for _, reply := range comment.Replies {
+		if !reply.Deleted {
+			return true
+		}
+	}
+	return false
+}
+
+func listComments(ctx context.Context, service drfs.Service, fileID string) ([]*drive.Comment, error) {
+	client, err := service.Take(ctx, 6)
+	if err != nil {
+		return nil, err
+	}
+
+	var comments []*drive.Comment
+	err = client.CommentsService().
+		List(fileID).
+		Fields("*").
+		PageSize(drfs.MaxPages).
+		Pages(ctx, func(list *drive.CommentList) error {
+			comments = append(comments, list.Comments...)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("list comments: %w", err)
+	}
+	return comments, nil
+}
+
+// writeComment updates the content of a comment, creating it if commentID is empty.
+func writeComment(ctx context.Context, service drfs.Service, fileID string, commentID string, content string) error {
+	client, err := service.Take(ctx, 1)
+	if err != nil {
+		return err
+	}
+
+	if commentID == "" {
+		_, err = client.CommentsService().
+			Create(fileID, &drive.Comment{Content: content}).
+			Fields("id").
+			Context(ctx).
+			Do()
+		return err
+	}
+	_, err = client.CommentsService().
+		Update(fileID, commentID, &drive.Comment{Content: content}).
+		Fields("id").
+		Context(ctx).
+		Do()
+	return err
+}
+
+func deleteComment(ctx context.Context, service drfs.Service, fileID string, commentID string) error {
+	client, err := service.Take(ctx, 1)
+	if err != nil {
+		return err
+	}
+
+	err = client.CommentsService().
+		Delete(fileID, commentID).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return fmt.Errorf("delete comment %s: %w", commentID, err)
+	}
+	return nil
+}
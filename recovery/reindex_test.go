@@ -0,0 +1,107 @@
+package recovery_test
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+	"github.com/kaiserkarel/drfs/fake"
+	"github.com/kaiserkarel/drfs/recovery"
+)
+
+func TestReindex(t *testing.T) {
+	var payload = strings.Repeat("lorem ipsum dolor sit amet ", 2*drfs.EffectiveReplySize)
+
+	for name, corrupt := range map[string]func(comment *drive.Comment) (string, bool){
+		"fileheader": func(comment *drive.Comment) (string, bool) {
+			_, err := drfs.FileHeaderFromJSON(strings.NewReader(comment.Content))
+			return "", err == nil
+		},
+		"threadheader": func(comment *drive.Comment) (string, bool) {
+			header, err := drfs.ThreadHeaderFromJSON(strings.NewReader(comment.Content))
+			return "garbage", err == nil && header.Number == 1
+		},
+		"threadheaders": func(comment *drive.Comment) (string, bool) {
+			// threads of which the header is lost are told apart by the order in which they were created.
+			header, err := drfs.ThreadHeaderFromJSON(strings.NewReader(comment.Content))
+			return "garbage", err == nil && header.Number >= 1
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server := fake.NewServer()
+			defer server.Close()
+
+			service, err := fake.NewService(context.Background(), server)
+			require.NoError(t, err)
+
+			file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: 4})
+			require.NoError(t, err)
+			_, err = file.WriteCtx(context.Background(), []byte(payload))
+			require.NoError(t, err)
+
+			// delete or overwrite the header.
+			client, err := service.Take(context.Background(), 1)
+			require.NoError(t, err)
+			stat, err := file.Fstat()
+			require.NoError(t, err)
+			list, err := client.CommentsService().List(stat.ID()).Fields("*").PageSize(drfs.MaxPages).Do()
+			require.NoError(t, err)
+			for _, comment := range list.Comments {
+				content, ok := corrupt(comment)
+				if !ok {
+					continue
+				}
+				if content == "" {
+					require.NoError(t, client.CommentsService().Delete(stat.ID(), comment.Id).Do())
+				} else {
+					_, err = client.CommentsService().Update(stat.ID(), comment.Id, &drive.Comment{Content: content}).Do()
+					require.NoError(t, err)
+				}
+			}
+
+			_, err = drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+			require.Error(t, err)
+
+			reindexed, err := recovery.Reindex(service, stat.Sys().(*drive.File), drfs.FileHeader{})
+			require.NoError(t, err)
+			assert.True(t, reindexed.Index().Header.Manifest, "the manifest is recreated")
+
+			got, err := ioutil.ReadAll(reindexed)
+			require.NoError(t, err)
+			assert.Equal(t, payload, string(got))
+
+			// writes continue at the end of the file.
+			_, err = reindexed.WriteCtx(context.Background(), []byte("consectetur"))
+			require.NoError(t, err)
+			reopened, err := drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+			require.NoError(t, err)
+			got, err = ioutil.ReadAll(reopened)
+			require.NoError(t, err)
+			assert.Equal(t, payload+"consectetur", string(got))
+
+			report, err := recovery.Check(reopened)
+			require.NoError(t, err)
+			assert.True(t, report.OK(), report.Problems())
+
+			var manifests, journals int
+			list, err = client.CommentsService().List(stat.ID()).Fields("*").PageSize(drfs.MaxPages).Do()
+			require.NoError(t, err)
+			for _, comment := range list.Comments {
+				if _, err := drfs.ManifestHeaderFromJSON(strings.NewReader(comment.Content)); err == nil {
+					manifests++
+				}
+				if _, err := drfs.JournalRecordFromJSON(strings.NewReader(comment.Content)); err == nil {
+					journals++
+				}
+			}
+			assert.Equal(t, 1, manifests)
+			assert.Equal(t, 1, journals)
+		})
+	}
+}
@@ -31,7 +31,10 @@ func Stats(file *drfs.File) (os.FileInfo, error) {
 
 	var length int64
 
-	for _, b := range index.Buckets {
+	for k, b := range index.Buckets {
+		// replies are decoded by the thread of the open file, which holds the key of encrypted files.
+		var dec = file.Index().Buckets[k]
+		var i int64
 		err = client.RepliesService().
 			List(s.ID(), b.CommentID).
 			Fields("*").
@@ -41,7 +44,12 @@ func Stats(file *drfs.File) (os.FileInfo, error) {
 					if reply.Deleted {
 						panic("a deleted reply!")
 					}
-					length += int64(len([]byte(reply.Content)) - 2)
+					data, err := dec.Decode(i, reply.Content)
+					if err != nil {
+						return err
+					}
+					length += int64(len(data))
+					i++
 				}
 				return nil
 			})
@@ -50,13 +58,27 @@ func Stats(file *drfs.File) (os.FileInfo, error) {
 		}
 	}
 
+	// the hash in the index only describes the content if the size matches.
+	var hash = s.Hash()
+	if length != s.StoredSize() {
+		hash = nil
+	}
+
+	// the size of the content of compressed files is only known by decompressing the file.
+	var size = length
+	if file.Index().Header.Compression != "" {
+		size = s.Size()
+	}
+
 	return &stat{
 		fileID:         s.ID(),
 		fileName:       s.Name(),
-		size:           length,
+		size:           size,
+		storedSize:     length,
 		quotaBytesUsed: s.QuotaBytesUsed(),
 		modtime:        s.ModTime(),
 		sys:            s.Sys().(*drive.File),
+		hash:           hash,
 	}, nil
 }
 
@@ -64,9 +86,11 @@ type stat struct {
 	fileID         string
 	fileName       string
 	size           int64
+	storedSize     int64
 	quotaBytesUsed int64
 	modtime        time.Time
 	sys            *drive.File
+	hash           []byte
 }
 
 func (s *stat) ID() string {
@@ -81,8 +105,12 @@ func (s *stat) Size() int64 {
 	return s.size
 }
 
+func (s *stat) StoredSize() int64 {
+	return s.storedSize
+}
+
 func (s *stat) Mode() os.FileMode {
-	return os.ModeIrregular
+	return 0644
 }
 
 func (s *stat) ModTime() time.Time {
@@ -100,3 +128,8 @@ func (s *stat) Sys() interface{} {
 func (s *stat) QuotaBytesUsed() int64 {
 	return s.quotaBytesUsed
 }
+
+// Hash returns the hash recorded in the index, which is not verified against the replies.
+func (s *stat) Hash() []byte {
+	return s.hash
+}
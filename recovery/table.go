@@ -0,0 +1,47 @@
+package recovery
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+)
+
+// RepairTables rebuilds the reply table of every thread from its replies, creating missing tables. Tables go missing
+// or stale if recording a reply fails, and files written before reply tables were introduced have none.
+func RepairTables(file *drfs.File) error {
+	for _, b := range file.Index().Threads() {
+		if b.Lost() {
+			continue // rebuilding a thread writes its table.
+		}
+		client, err := file.Service().Take(context.Background(), 1)
+		if err != nil {
+			return err
+		}
+
+		var ids []string
+		err = client.RepliesService().
+			List(b.FileID, b.CommentID).
+			Fields("*").
+			PageSize(drfs.MaxPages).
+			Pages(context.Background(), func(list *drive.ReplyList) error {
+				for _, reply := range list.Replies {
+					if !reply.Deleted {
+						ids = append(ids, reply.Id)
+					}
+				}
+				return nil
+			})
+		if err != nil {
+			return fmt.Errorf("list replies of thread %d: %w", b.Header.Number, err)
+		}
+
+		err = b.RewriteTable(context.Background(), ids)
+		if err != nil {
+			return fmt.Errorf("rewrite reply table of thread %d: %w", b.Header.Number, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package recovery_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+	"github.com/kaiserkarel/drfs/fake"
+	"github.com/kaiserkarel/drfs/recovery"
+)
+
+func TestRepairTables(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+
+	file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: 2})
+	require.NoError(t, err)
+	_, err = file.WriteCtx(context.Background(), make([]byte, 5*drfs.EffectiveReplySize))
+	require.NoError(t, err)
+	require.NoError(t, file.Sync())
+
+	// lose the table of the first thread.
+	client, err := service.Take(context.Background(), 1)
+	require.NoError(t, err)
+	thread := file.Index().Buckets[0]
+	require.NoError(t, client.CommentsService().Delete(thread.FileID, thread.TableID()).Do())
+
+	stat, err := file.Fstat()
+	require.NoError(t, err)
+	reopened, err := drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+	require.NoError(t, err)
+	require.Empty(t, reopened.Index().Buckets[0].TableID())
+
+	require.NoError(t, recovery.RepairTables(reopened))
+
+	reopened, err = drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+	require.NoError(t, err)
+	for _, b := range reopened.Index().Buckets {
+		require.NotEmpty(t, b.TableID())
+
+		list, err := client.RepliesService().List(b.FileID, b.TableID()).Fields("*").Do()
+		require.NoError(t, err)
+		require.Len(t, list.Replies, 1)
+
+		replies, err := client.RepliesService().List(b.FileID, b.CommentID).Fields("*").Do()
+		require.NoError(t, err)
+		var ids []string
+		for _, reply := range replies.Replies {
+			ids = append(ids, reply.Id)
+		}
+		assert.Equal(t, "0:"+strings.Join(ids, ","), list.Replies[0].Content)
+	}
+}
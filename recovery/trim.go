@@ -0,0 +1,191 @@
+package recovery
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+)
+
+// TrimPlan describes how Trim shortens a file to a consistent prefix of its stripe.
+type TrimPlan struct {
+	Size    int64        // size of the file after trimming.
+	Removed int64        // number of bytes stored in the replies which are deleted or truncated.
+	Threads []ThreadTrim // threads which are altered, ordered by number.
+}
+
+// ThreadTrim describes how a single thread is trimmed.
+type ThreadTrim struct {
+	Thread   *drfs.Thread
+	Header   drfs.ThreadHeader // state of the thread after trimming.
+	Delete   []string          // IDs of the replies to delete, in order.
+	Truncate string            // ID of the reply to truncate, if any.
+	Keep     int               // number of bytes kept of the truncated reply.
+
+	kept []string // IDs of the remaining replies, in order.
+	data []byte   // remaining data of the truncated reply.
+}
+
+func (t ThreadTrim) String() string {
+	s := fmt.Sprintf("thread %d: delete %d replies", t.Header.Number, len(t.Delete))
+	if t.Truncate != "" {
+		s += fmt.Sprintf(", truncate reply %s to %d bytes", t.Truncate, t.Keep)
+	}
+	return s
+}
+
+// PlanTrim plans trimming the file to the given size, without altering it. Chunk c of a file is stored as reply c / N
+// of thread c % N; the stripe is consistent up to the first chunk which is missing, damaged or partial. If the size
+// exceeds the consistent prefix, or is negative, the file is trimmed to the consistent prefix instead.
+func PlanTrim(file *drfs.File, size int64) (*TrimPlan, error) {
+	var ctx = context.Background()
+	var threads = file.Index().Buckets
+	var numThreads = int64(len(threads))
+	var replySize = int64(file.ReplySize())
+
+	var replies = make([][]*drive.Reply, numThreads)
+	var data = make([][][]byte, numThreads) // decoded replies; nil if damaged.
+	var stored int64
+	for i, b := range threads {
+		live, _, err := listReplies(ctx, file, b)
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = live
+		data[i] = make([][]byte, len(live))
+		for k, reply := range live {
+			chunk, err := b.Decode(int64(k), reply.Content)
+			if err == nil {
+				data[i][k] = chunk
+				stored += int64(len(chunk))
+			}
+		}
+	}
+
+	var prefix int64
+	for c := int64(0); ; c++ {
+		i, k := c%numThreads, c/numThreads
+		if k >= int64(len(data[i])) || data[i][k] == nil || int64(len(data[i][k])) > replySize {
+			break
+		}
+		prefix += int64(len(data[i][k]))
+		if int64(len(data[i][k])) < replySize {
+			break
+		}
+	}
+	if size < 0 || size > prefix {
+		size = prefix
+	}
+
+	// the stripe of a file of the trimmed size. The last chunk might be partial.
+	var chunks = (size + replySize - 1) / replySize
+	var last, keep = int64(-1), 0
+	if chunks > 0 {
+		last = (chunks - 1) % numThreads
+		keep = int(size - (chunks-1)*replySize)
+	}
+
+	var plan = &TrimPlan{Size: size, Removed: stored - size}
+	for i, b := range threads {
+		var length = chunks / numThreads
+		if int64(i) < chunks%numThreads {
+			length++
+		}
+
+		var trim = ThreadTrim{Thread: b, Header: b.Header}
+		for k, reply := range replies[i] {
+			if int64(k) < length {
+				trim.kept = append(trim.kept, reply.Id)
+			} else {
+				trim.Delete = append(trim.Delete, reply.Id)
+			}
+		}
+
+		trim.Header.Length, trim.Header.Tail, trim.Header.Capacity = length, "", 0
+		if length > 0 {
+			tail := data[i][length-1]
+			trim.Header.Tail = trim.kept[length-1]
+			if int64(i) == last && len(tail) > keep {
+				trim.Truncate, trim.Keep, trim.data = trim.Header.Tail, keep, tail[:keep]
+				tail = trim.data
+			}
+			trim.Header.Capacity = int(replySize) - len(tail)
+		}
+
+		if len(trim.Delete) > 0 || trim.Truncate != "" || trim.Header != b.Header {
+			plan.Threads = append(plan.Threads, trim)
+		}
+	}
+	return plan, nil
+}
+
+// Trim truncates the file to the given size, or to the longest consistent prefix of its stripe, as planned by
+// PlanTrim. Replies past that point are deleted or truncated, and the state of every altered thread is rewritten. Use
+// PlanTrim for a dry run. The parity of files with parity threads is rebuilt to match. The file should be reopened
+// after trimming.
+func Trim(file *drfs.File, size int64) (*TrimPlan, error) {
+	var ctx = context.Background()
+
+	// a batch left pending by a writer which died is recovered first, so the plan covers its replies.
+	if err := file.RecoverCtx(ctx); err != nil {
+		return nil, err
+	}
+
+	plan, err := PlanTrim(file, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var altered []*drfs.Thread
+	for _, trim := range plan.Threads {
+		b := trim.Thread
+		client, err := file.Service().Take(ctx, len(trim.Delete)+1)
+		if err != nil {
+			return nil, err
+		}
+
+		// replies are deleted from the end, so an interrupted trim leaves a prefix of the stripe.
+		for k := len(trim.Delete) - 1; k >= 0; k-- {
+			err = client.RepliesService().
+				Delete(b.FileID, b.CommentID, trim.Delete[k]).
+				Context(ctx).
+				Do()
+			if err != nil {
+				return nil, fmt.Errorf("thread %d: delete reply %s: %w", b.Header.Number, trim.Delete[k], err)
+			}
+		}
+
+		if trim.Truncate != "" {
+			_, err = client.RepliesService().
+				Update(b.FileID, b.CommentID, trim.Truncate, &drive.Reply{Content: b.Encode(trim.Header.Length-1, trim.data)}).
+				Fields("id").
+				Context(ctx).
+				Do()
+			if err != nil {
+				return nil, fmt.Errorf("thread %d: truncate reply %s: %w", b.Header.Number, trim.Truncate, err)
+			}
+		}
+
+		b.Header = trim.Header
+		altered = append(altered, b)
+
+		err = b.RewriteTable(ctx, trim.kept)
+		if err != nil {
+			return nil, fmt.Errorf("rewrite reply table of thread %d: %w", b.Header.Number, err)
+		}
+	}
+
+	err = commitHeaders(ctx, file, altered)
+	if err != nil {
+		return nil, err
+	}
+
+	// the parity of the last stripe no longer matches the truncated data, and stripes past it are gone.
+	err = file.RebuildParity(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("rebuild parity: %w", err)
+	}
+	return plan, nil
+}
@@ -0,0 +1,142 @@
+package recovery_test
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+	"github.com/kaiserkarel/drfs/fake"
+	"github.com/kaiserkarel/drfs/recovery"
+)
+
+func TestTrim(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+
+	file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: 3})
+	require.NoError(t, err)
+
+	var replySize = file.ReplySize()
+	var payload = strings.Repeat("lorem ipsum dolor sit amet ", 6*replySize)[:5*replySize+replySize/2]
+	_, err = file.WriteCtx(context.Background(), []byte(payload))
+	require.NoError(t, err)
+	require.NoError(t, file.Sync())
+
+	// a reply of which the rollback failed: chunk 6 was written to the first thread, chunk 5 is partial.
+	client, err := service.Take(context.Background(), 1)
+	require.NoError(t, err)
+	thread := file.Index().Buckets[0]
+	_, err = client.RepliesService().
+		Create(thread.FileID, thread.CommentID, &drive.Reply{Content: thread.Encode(thread.Header.Length, []byte(payload[:replySize]))}).
+		Fields("id").
+		Do()
+	require.NoError(t, err)
+
+	stat, err := file.Fstat()
+	require.NoError(t, err)
+	reopened, err := drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+	require.NoError(t, err)
+
+	plan, err := recovery.PlanTrim(reopened, -1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(payload)), plan.Size)
+	assert.Equal(t, int64(replySize), plan.Removed)
+	require.Len(t, plan.Threads, 1)
+	assert.Equal(t, 0, plan.Threads[0].Header.Number)
+	assert.Len(t, plan.Threads[0].Delete, 1)
+
+	// trim to the middle of chunk 2, stored in the third thread.
+	var size = int64(2*replySize + 10)
+	plan, err = recovery.PlanTrim(reopened, size)
+	require.NoError(t, err)
+	assert.Equal(t, size, plan.Size)
+	require.Len(t, plan.Threads, 3)
+	assert.Len(t, plan.Threads[0].Delete, 2)
+	assert.Len(t, plan.Threads[1].Delete, 1)
+	assert.Len(t, plan.Threads[2].Delete, 1)
+	assert.NotEmpty(t, plan.Threads[2].Truncate)
+	assert.Equal(t, 10, plan.Threads[2].Keep)
+
+	// planning does not alter the file.
+	got, err := ioutil.ReadAll(reopened)
+	require.NoError(t, err)
+	assert.Equal(t, payload, string(got))
+
+	_, err = recovery.Trim(reopened, size)
+	require.NoError(t, err)
+
+	reopened, err = drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+	require.NoError(t, err)
+	got, err = ioutil.ReadAll(reopened)
+	require.NoError(t, err)
+	assert.Equal(t, payload[:size], string(got))
+
+	report, err := recovery.Check(reopened)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), report.Problems())
+
+	// writes continue at the end of the trimmed file.
+	_, err = reopened.WriteCtx(context.Background(), []byte(payload[size:]))
+	require.NoError(t, err)
+	reopened, err = drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+	require.NoError(t, err)
+	got, err = ioutil.ReadAll(reopened)
+	require.NoError(t, err)
+	assert.Equal(t, payload, string(got))
+}
+
+func TestTrimCompressed(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+
+	file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: 2, Codec: drfs.Base85, Compression: drfs.Gzip})
+	require.NoError(t, err)
+
+	var payload = strings.Repeat("lorem ipsum dolor sit amet ", 2000)
+	_, err = file.WriteCtx(context.Background(), []byte(payload))
+	require.NoError(t, err)
+	require.NoError(t, file.Sync())
+	stat, err := file.Fstat()
+	require.NoError(t, err)
+	var stored = stat.StoredSize()
+
+	// a second stream, trimmed off again.
+	_, err = file.WriteCtx(context.Background(), []byte("consectetur adipiscing elit"))
+	require.NoError(t, err)
+	require.NoError(t, file.Sync())
+
+	_, err = recovery.Trim(file, stored)
+	require.NoError(t, err)
+	stat, err = file.Fstat()
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(payload)), stat.Size())
+
+	reopened, err := drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+	require.NoError(t, err)
+	stat, err = reopened.Fstat()
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(payload)), stat.Size())
+	got, err := ioutil.ReadAll(reopened)
+	require.NoError(t, err)
+	assert.Equal(t, payload, string(got))
+
+	_, err = recovery.Trim(reopened, 0)
+	require.NoError(t, err)
+	reopened, err = drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+	require.NoError(t, err)
+	stat, err = reopened.Fstat()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), stat.Size())
+}
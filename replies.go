@@ -0,0 +1,168 @@
+package drfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// replyCache holds the page of replies of a thread which was listed last, and the decoded data of the reply which was
+// read last. Page tokens are remembered, so seeking backwards does not require listing the thread from the start.
+// Replies on pages of which the token is unknown are fetched individually using the reply table.
+type replyCache struct {
+	mu sync.Mutex
+
+	tokens  map[int64]string // page tokens, by the index of the first reply of the page.
+	start   int64            // index of the first reply in replies.
+	replies []*drive.Reply
+
+	index int64 // index of the reply stored in chunk.
+	chunk []byte
+}
+
+func newReplyCache() *replyCache {
+	return &replyCache{tokens: map[int64]string{0: ""}}
+}
+
+// invalidate drops the cached replies, which might have been altered by a write. Page tokens remain valid.
+func (c *replyCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.replies = nil
+	c.chunk = nil
+}
+
+// chunkAt returns the decoded data of the reply at index i. Replies are listed in pages of MaxPages, starting at the
+// closest page of which the token is known.
+func (t *Thread) chunkAt(ctx context.Context, i int64) ([]byte, error) {
+	c := t.cache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.chunk != nil && c.index == i {
+		return c.chunk, nil
+	}
+	if t.lost {
+		return nil, fmt.Errorf("thread %d: %w", t.Header.Number, ErrThreadLost)
+	}
+
+	reply, err := t.replyAt(ctx, i)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := t.Decode(i, reply.Content)
+	if errors.Is(err, ErrChecksum) {
+		return nil, &ChecksumError{Thread: t.Header.Number, Reply: reply.Id}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decode reply %s: %w", reply.Id, err)
+	}
+	c.index, c.chunk = i, data
+	return data, nil
+}
+
+// cacheChunk stores the data of the reply at index i, as reconstructed from parity.
+func (t *Thread) cacheChunk(i int64, data []byte) {
+	c := t.cache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.index, c.chunk = i, data
+}
+
+// replyAt returns the reply at index i. The caller must hold the lock of the cache.
+func (t *Thread) replyAt(ctx context.Context, i int64) (*drive.Reply, error) {
+	c := t.cache
+	if i < 0 || i >= t.Header.Length {
+		return nil, io.EOF
+	}
+	if c.replies != nil && i >= c.start && i < c.start+int64(len(c.replies)) {
+		return c.replies[i-c.start], nil
+	}
+
+	var target = i / MaxPages * MaxPages
+	if _, ok := c.tokens[target]; !ok {
+		// listing would walk every page preceding the target, so the reply is fetched using the reply table instead.
+		if reply, ok := t.getReply(ctx, i); ok {
+			return reply, nil
+		}
+	}
+
+	var page = target
+	for {
+		if _, ok := c.tokens[page]; ok {
+			break
+		}
+		page -= MaxPages
+	}
+
+	for {
+		var list *drive.ReplyList
+		err := retry(ctx, func() error {
+			client, err := t.service.Take(ctx, 1)
+			if err != nil {
+				return err
+			}
+			list, err = client.RepliesService().
+				List(t.FileID, t.CommentID).
+				PageToken(c.tokens[page]).
+				PageSize(MaxPages).
+				Fields("*").
+				Context(ctx).
+				Do()
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list replies: %w", err)
+		}
+
+		if list.NextPageToken != "" {
+			c.tokens[page+MaxPages] = list.NextPageToken
+		}
+
+		if page == target {
+			c.start, c.replies = page, list.Replies
+			break
+		}
+		if list.NextPageToken == "" {
+			break
+		}
+		page += MaxPages
+	}
+
+	if c.replies == nil || c.start != target || i-c.start >= int64(len(c.replies)) {
+		return nil, fmt.Errorf("thread %d: reply %d is missing: %w", t.Header.Number, i, io.ErrUnexpectedEOF)
+	}
+	return c.replies[i-c.start], nil
+}
+
+// getReply fetches reply i by its ID in the reply table. Entries referring to deleted replies are ignored.
+func (t *Thread) getReply(ctx context.Context, i int64) (*drive.Reply, bool) {
+	id, ok := t.lookup(ctx, i)
+	if !ok {
+		return nil, false
+	}
+
+	var reply *drive.Reply
+	err := retry(ctx, func() error {
+		client, err := t.service.Take(ctx, 1)
+		if err != nil {
+			return err
+		}
+		reply, err = client.RepliesService().
+			Get(t.FileID, t.CommentID, id).
+			IncludeDeleted(true).
+			Fields("*").
+			Context(ctx).
+			Do()
+		return err
+	})
+	if err != nil || reply.Deleted {
+		return nil, false
+	}
+	return reply, true
+}
@@ -3,6 +3,8 @@ package drfs
 import (
 	"context"
 	"errors"
+	"io"
+	"net"
 	"time"
 
 	"google.golang.org/api/googleapi"
@@ -10,19 +12,44 @@ import (
 	"github.com/cenkalti/backoff/v4"
 )
 
-// Retry an operation using exponential backoff. If the operation returns a googleapi.Error with code 500 or 404, the
-// operation is retried as well.
+// Retry an operation using exponential backoff. The operation is retried if it returns a googleapi.Error with code 5xx,
+// 404 or a rate limit error, or a network error; other errors are permanent.
 func retry(ctx context.Context, operation backoff.Operation) error {
 	return tryUntil(operation, backoff.WithContext(backoff.NewExponentialBackOff(), ctx), checkErr)
 }
 
-// Check if an error is googleapi.Error.Code 500 or 404
+// Check if an error is permanent; anything but a 5xx, 404 or rate limit googleapi.Error, or a network error.
 func checkErr(err error) bool {
-	var apiError googleapi.Error
-	if errors.Is(err, &apiError) {
-		return apiError.Code != 500 && apiError.Code != 404 // 404 might occur because of eventual consistency
+	var apiError *googleapi.Error
+	if !errors.As(err, &apiError) {
+		return !isNetworkErr(err)
 	}
-	return false
+
+	switch {
+	case apiError.Code >= 500:
+		return false
+	case apiError.Code == 404: // 404 might occur because of eventual consistency
+		return false
+	case apiError.Code == 429:
+		return false
+	case apiError.Code == 403:
+		for _, item := range apiError.Errors {
+			if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isNetworkErr reports whether the request failed in transport, such as by a timeout or a dropped connection. Canceled
+// requests are not, although the transport reports these as well.
+func isNetworkErr(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
 }
 
 // Adapted from github.com/cenkalti/backoff/v4 to allow control over error checking.
@@ -38,6 +65,10 @@ func tryUntil(operation backoff.Operation, b backoff.BackOffContext, f func(erro
 			return nil
 		}
 
+		if permanent, ok := err.(*backoff.PermanentError); ok {
+			return permanent.Err
+		}
+
 		if f(err) {
 			return err
 		}
@@ -0,0 +1,35 @@
+package drfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/googleapi"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestCheckErr(t *testing.T) {
+	for err, permanent := range map[error]bool{
+		&googleapi.Error{Code: 500}:                                            false,
+		&googleapi.Error{Code: 404}:                                            false,
+		&googleapi.Error{Code: 429}:                                            false,
+		&googleapi.Error{Code: 400}:                                            true,
+		&url.Error{Op: "Post", URL: "https://drive", Err: timeoutError{}}:      false,
+		&url.Error{Op: "Post", URL: "https://drive", Err: io.ErrUnexpectedEOF}: false,
+		&url.Error{Op: "Post", URL: "https://drive", Err: context.Canceled}:    true,
+		fmt.Errorf("reply exceeded max size: %d", 1):                           true,
+		errors.New("decode reply"):                                             true,
+	} {
+		assert.Equal(t, permanent, checkErr(err), err.Error())
+	}
+}
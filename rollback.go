@@ -3,26 +3,36 @@ package drfs
 import (
 	"context"
 	"fmt"
-
-	"google.golang.org/api/drive/v3"
 )
 
 // ErrNoRollback is returned if rollback is not possible or if it fails. Failing rollbacks are a catastrophic failure.
 // The best bet is to save the current and previous bucket states; wait a day for rate limits to regenerate and attempt
-// to restore the index and trim buckets.
+// to restore the index using recovery.Reindex and trim buckets using recovery.Trim.
 var ErrNoRollback = fmt.Errorf("rollback not possible")
 
-// RollbackCtx returns a bucket from state new to old by deleting and updating replies. At most there should be a length difference
-// of 1 between the buckets. (There is no API for searching reply by number, thus deleting between two arbitrary replies
-// is expensive).
+// RollbackCtx returns a bucket from state new to old by deleting and updating replies, and writes old to the
+// ThreadHeader of the comment. At most there should be a length difference of 1 between the buckets. RollbackCtx
+// assumes the Raw codec; use RollbackCodecCtx for threads of other codecs.
 func RollbackCtx(ctx context.Context, s Service, fileID string, commentID string, old ThreadHeader, new ThreadHeader) error {
+	err := RollbackCodecCtx(ctx, s, fileID, commentID, rawCodec{}, old, new)
+	if err != nil {
+		return err
+	}
+	return updateHeader(ctx, s, fileID, commentID, old)
+}
+
+// RollbackCodecCtx returns a bucket from state new to old by deleting and updating replies. At most there should be a length difference
+// of 1 between the buckets. (There is no API for searching reply by number, thus deleting between two arbitrary replies
+// is expensive). The codec is used to re-encode a reply from which appended data is removed. The ThreadHeader is left as
+// is; see Thread.Rollback.
+func RollbackCodecCtx(ctx context.Context, s Service, fileID string, commentID string, codec Codec, old ThreadHeader, new ThreadHeader) error {
 	if new.Length-old.Length > 1 {
 		panic("headers should differ by max length 1")
 	}
 
 	// remove a created reply
 	if old.Tail != new.Tail {
-		service, err := s.Take(ctx, 2)
+		service, err := s.Take(ctx, 1)
 		if err != nil {
 			return err
 		}
@@ -32,15 +42,6 @@ func RollbackCtx(ctx context.Context, s Service, fileID string, commentID string
 			Fields("*").
 			Context(ctx).
 			Do()
-		if err != nil {
-			return err
-		}
-
-		_, err = service.CommentsService().
-			Update(fileID, commentID, &drive.Comment{Content: string(old.MustMarshall())}).
-			Fields("*").
-			Context(ctx).
-			Do()
 		return err
 	}
 
@@ -48,13 +49,13 @@ func RollbackCtx(ctx context.Context, s Service, fileID string, commentID string
 		panic("capacity difference < 0 for append rollback")
 	}
 
-	service, err := s.Take(ctx, 3)
+	service, err := s.Take(ctx, 2)
 	if err != nil {
 		return err
 	}
 
 	// update an appended piece of data
-	end := old.Capacity - new.Capacity
+	appended := old.Capacity - new.Capacity
 	reply, err := service.RepliesService().
 		Get(fileID, commentID, old.Tail).
 		Context(ctx).
@@ -64,17 +65,14 @@ func RollbackCtx(ctx context.Context, s Service, fileID string, commentID string
 		return err
 	}
 
-	reply.Content = reply.Content[:end]
-	_, err = service.RepliesService().
-		Update(fileID, commentID, old.Tail, reply).
-		Fields("*").
-		Context(ctx).
-		Do()
+	data, err := decodeReply(codec, reply.Content)
 	if err != nil {
 		return err
 	}
-	_, err = service.CommentsService().
-		Update(fileID, commentID, &drive.Comment{Content: string(old.MustMarshall())}).
+
+	reply.Content = encodeReply(codec, data[:len(data)-appended])
+	_, err = service.RepliesService().
+		Update(fileID, commentID, old.Tail, reply).
 		Fields("*").
 		Context(ctx).
 		Do()
@@ -0,0 +1,196 @@
+package drfs_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+	"github.com/kaiserkarel/drfs/fake"
+	"github.com/kaiserkarel/drfs/recovery"
+)
+
+func newFile(t *testing.T, numThreads int, rules ...*fake.Rule) (*fake.Server, *fake.Injector, *drfs.File) {
+	server := fake.NewServer()
+	injector := fake.NewInjector(server.Client().Transport, rules...)
+	service, err := fake.NewServiceWithTransport(context.Background(), server, injector)
+	require.NoError(t, err)
+
+	file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: numThreads})
+	require.NoError(t, err)
+	return server, injector, file
+}
+
+func randomPayload(n int) []byte {
+	payload := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(payload)
+	for i := range payload {
+		payload[i] = 'a' + payload[i]%26
+	}
+	return payload
+}
+
+// assertConsistent checks that the headers stored in Drive match the local index, and that the replies match the
+// headers.
+func assertConsistent(t *testing.T, file *drfs.File, size int) {
+	stat, err := file.Fstat()
+	require.NoError(t, err)
+	assert.Equal(t, int64(size), stat.Size(), "index size should match written bytes")
+
+	index, err := drfs.IndexFromFile(context.Background(), file.Service(), stat.Sys().(*drive.File))
+	require.NoError(t, err)
+	require.Len(t, index.Buckets, len(file.Index().Buckets))
+	for i, bucket := range index.Buckets {
+		assert.Equal(t, file.Index().Buckets[i].Header, bucket.Header, "stored header should match local header")
+	}
+
+	rStat, err := recovery.Stats(file)
+	require.NoError(t, err)
+	assert.Equal(t, stat.Size(), rStat.Size(), "index size should match recovery size")
+}
+
+func TestThreadRollbackPut(t *testing.T) {
+	server, _, file := newFile(t, 1)
+	defer server.Close()
+
+	thread := file.Index().Buckets[0]
+	old := thread.Header
+
+	require.NoError(t, thread.Put(context.Background(), []byte("hello")))
+	require.NoError(t, thread.Rollback(context.Background(), file.Service(), thread.FileID))
+	assert.Equal(t, old, thread.Header)
+	assert.Equal(t, drfs.ErrNoRollback, thread.Rollback(context.Background(), file.Service(), thread.FileID))
+
+	assertConsistent(t, file, 0)
+}
+
+func TestThreadRollbackUpdate(t *testing.T) {
+	server, _, file := newFile(t, 1)
+	defer server.Close()
+
+	thread := file.Index().Buckets[0]
+	require.NoError(t, thread.Put(context.Background(), []byte("hello")))
+	require.NoError(t, file.Sync())
+	old := thread.Header
+
+	require.NoError(t, thread.Update(context.Background(), []byte(" world")))
+	require.NoError(t, thread.Rollback(context.Background(), file.Service(), thread.FileID))
+	assert.Equal(t, old, thread.Header)
+
+	assertConsistent(t, file, len("hello"))
+
+	buf := make([]byte, len("hello"))
+	_, err := io.ReadFull(file, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+}
+
+func TestRollbackCtx(t *testing.T) {
+	// files without a codec in their FileHeader are Raw, as RollbackCtx assumes.
+	server, _, file := newLegacyFile(t, 1)
+	defer server.Close()
+
+	thread := file.Index().Buckets[0]
+	require.NoError(t, thread.Put(context.Background(), []byte("hello")))
+	old := thread.Header
+	require.NoError(t, thread.Update(context.Background(), []byte(" world")))
+
+	require.NoError(t, drfs.RollbackCtx(context.Background(), file.Service(), thread.FileID, thread.CommentID, old, thread.Header))
+
+	stat, err := file.Fstat()
+	require.NoError(t, err)
+	reopened, err := drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), file.Service())
+	require.NoError(t, err)
+	assert.Equal(t, old, reopened.Index().Buckets[0].Header, "RollbackCtx should write the old ThreadHeader")
+
+	content, err := ioutil.ReadAll(reopened)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(content))
+}
+
+func TestThreadFailedPutLeavesHeader(t *testing.T) {
+	// only files without a manifest write the ThreadHeader after every reply.
+	server, _, file := newLegacyFile(t, 1, &fake.Rule{
+		Match: fake.Match(http.MethodPatch, "comments"),
+		Fault: fake.BadRequest,
+		Calls: []int{2},
+	})
+	defer server.Close()
+
+	thread := file.Index().Buckets[0]
+	require.NoError(t, thread.Put(context.Background(), []byte("hello")))
+	old := thread.Header
+
+	// the reply is created, but the header update fails permanently.
+	require.Error(t, thread.Update(context.Background(), []byte(" world")))
+	require.NoError(t, thread.Rollback(context.Background(), file.Service(), thread.FileID))
+	assert.Equal(t, old, thread.Header)
+
+	assertConsistent(t, file, len("hello"))
+}
+
+func TestWriteBatchRollback(t *testing.T) {
+	server, _, file := newFile(t, 4, &fake.Rule{
+		Match: fake.Match(http.MethodPost, "replies"),
+		Fault: fake.BadRequest,
+		Calls: []int{3},
+	})
+	defer server.Close()
+
+	payload := randomPayload(4 * drfs.EffectiveReplySize)
+
+	n, err := file.WriteBatch(context.Background(), payload)
+	require.Error(t, err)
+	assert.Less(t, n, len(payload))
+	assert.Equal(t, 0, n%drfs.EffectiveReplySize, "only complete segments should remain written")
+	assertConsistent(t, file, n)
+
+	_, err = file.WriteCtx(context.Background(), payload[n:])
+	require.NoError(t, err)
+	assertConsistent(t, file, len(payload))
+
+	buf := make([]byte, len(payload))
+	_, err = io.ReadFull(file, buf)
+	require.NoError(t, err)
+	assert.Equal(t, string(payload), string(buf))
+}
+
+func TestWriteTransientFaults(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test waiting for backoffs")
+	}
+
+	server, injector, file := newFile(t, 4,
+		&fake.Rule{Match: fake.Match(http.MethodPost, "replies"), Fault: fake.Partial(fake.InternalError), Calls: []int{2}},
+		&fake.Rule{Match: fake.Match(http.MethodPost, "replies"), Fault: fake.RateLimitExceeded, Calls: []int{4}},
+		&fake.Rule{Match: fake.Match(http.MethodPatch, "replies"), Fault: fake.Partial(fake.Timeout), Calls: []int{1}},
+		&fake.Rule{Match: fake.Match(http.MethodGet, "replies"), Fault: fake.NotFound, Calls: []int{1}},
+		&fake.Rule{Match: fake.Match(http.MethodPatch, "replies"), Fault: fake.Partial(fake.InternalError), Calls: []int{3}},
+		&fake.Rule{Match: fake.Match(http.MethodPatch, "replies"), Fault: fake.Timeout, Calls: []int{5}},
+	)
+	defer server.Close()
+
+	payload := randomPayload(3*4*drfs.EffectiveReplySize + 100)
+	writes := []int{100, 5000, len(payload) - 5100}
+
+	var written int
+	for _, w := range writes {
+		n, err := file.WriteCtx(context.Background(), payload[written:written+w])
+		require.NoError(t, err)
+		written += n
+	}
+	assertConsistent(t, file, len(payload))
+	assert.Equal(t, 6, injector.Injected())
+
+	buf := make([]byte, len(payload))
+	_, err := io.ReadFull(file, buf)
+	require.NoError(t, err)
+	assert.Equal(t, string(payload), string(buf))
+}
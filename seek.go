@@ -0,0 +1,31 @@
+package drfs
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// Seek sets the offset for the next Read, interpreted according to whence, mimicking os.File.Seek. Seek does not
+// affect Write, which always appends.
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		size, err := f.length(context.Background())
+		if err != nil {
+			return 0, err
+		}
+		offset += size
+	default:
+		return 0, errors.New("drfs: invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("drfs: negative position")
+	}
+	f.offset = offset
+	return offset, nil
+}
@@ -0,0 +1,185 @@
+package drfs_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+	"github.com/kaiserkarel/drfs/fake"
+)
+
+// writeFile creates a file with the payload, and opens it again so reads do not depend on state from writing.
+func writeFile(t *testing.T, server *fake.Server, options drfs.FileOptions, payload []byte) *drfs.File {
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+
+	file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), options)
+	require.NoError(t, err)
+	_, err = file.WriteCtx(context.Background(), payload)
+	require.NoError(t, err)
+
+	stat, err := file.Fstat()
+	require.NoError(t, err)
+	reopened, err := drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+	require.NoError(t, err)
+	return reopened
+}
+
+func TestReadAt(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	payload := randomPayload(7*drfs.EffectiveReplySize + 123)
+	file := writeFile(t, server, drfs.FileOptions{NumThreads: 3}, payload)
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		off := rng.Int63n(int64(len(payload)))
+		buf := make([]byte, rng.Intn(3*drfs.EffectiveReplySize))
+
+		n, err := file.ReadAt(buf, off)
+		if off+int64(len(buf)) > int64(len(payload)) {
+			assert.Equal(t, io.EOF, err)
+		} else {
+			require.NoError(t, err)
+		}
+		assert.Equal(t, payload[off:off+int64(n)], buf[:n], "ReadAt(%d, %d)", len(buf), off)
+	}
+
+	n, err := file.ReadAt(make([]byte, 1), int64(len(payload)))
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReadSmallBuffers(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	payload := randomPayload(4*drfs.EffectiveReplySize + 7)
+	file := writeFile(t, server, drfs.FileOptions{NumThreads: 2}, payload)
+
+	var read bytes.Buffer
+	buf := make([]byte, 1000)
+	for {
+		n, err := file.Read(buf)
+		read.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+	assert.Equal(t, payload, read.Bytes())
+}
+
+func TestSeek(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	payload := randomPayload(3*drfs.EffectiveReplySize + 10)
+	file := writeFile(t, server, drfs.FileOptions{NumThreads: 2}, payload)
+
+	pos, err := file.Seek(-20, io.SeekEnd)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(payload)-20), pos)
+
+	buf := make([]byte, 10)
+	_, err = io.ReadFull(file, buf)
+	require.NoError(t, err)
+	assert.Equal(t, payload[len(payload)-20:len(payload)-10], buf)
+
+	pos, err = file.Seek(-int64(drfs.EffectiveReplySize), io.SeekCurrent)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(payload)-10-drfs.EffectiveReplySize), pos)
+	_, err = io.ReadFull(file, buf)
+	require.NoError(t, err)
+	assert.Equal(t, payload[pos:pos+10], buf)
+
+	_, err = file.Seek(-1, io.SeekStart)
+	assert.Error(t, err)
+
+	_, err = file.Seek(int64(len(payload)), io.SeekStart)
+	require.NoError(t, err)
+	_, err = file.Read(buf)
+	assert.Equal(t, io.EOF, err)
+}
+
+// TestReadAtPages reads a thread with more replies than fit in a single page, backwards.
+func TestReadAtPages(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes many replies")
+	}
+
+	server := fake.NewServer()
+	defer server.Close()
+
+	payload := randomPayload((drfs.MaxPages*2+10)*drfs.EffectiveReplySize + 5)
+	file := writeFile(t, server, drfs.FileOptions{NumThreads: 1}, payload)
+
+	buf := make([]byte, 10)
+	for _, off := range []int64{int64(len(payload)) - 10, 150 * drfs.EffectiveReplySize, 5, 101 * drfs.EffectiveReplySize} {
+		_, err := file.ReadAt(buf, off)
+		require.NoError(t, err)
+		assert.Equal(t, payload[off:off+10], buf, "ReadAt(%d)", off)
+	}
+}
+
+func TestReadZip(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	var archive bytes.Buffer
+	w := zip.NewWriter(&archive)
+	contents := map[string][]byte{
+		"a.txt": randomPayload(10000),
+		"b.bin": binaryPayload(5000),
+	}
+	for name, content := range contents {
+		f, err := w.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	file := writeFile(t, server, drfs.FileOptions{NumThreads: 2, Codec: drfs.Base85}, archive.Bytes())
+
+	r, err := zip.NewReader(file, int64(archive.Len()))
+	require.NoError(t, err)
+	require.Len(t, r.File, len(contents))
+	for _, f := range r.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(rc)
+		require.NoError(t, err)
+		assert.Equal(t, contents[f.Name], content, f.Name)
+		rc.Close()
+	}
+}
+
+func TestReadRange(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	payload := randomPayload(2*drfs.EffectiveReplySize + 100)
+	file := writeFile(t, server, drfs.FileOptions{NumThreads: 2}, payload)
+
+	req := httptest.NewRequest(http.MethodGet, "/file", nil)
+	req.Header.Set("Range", "bytes=4000-4199")
+	rec := httptest.NewRecorder()
+	http.ServeContent(rec, req, "file", time.Time{}, file)
+
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, payload[4000:4200], rec.Body.Bytes())
+}
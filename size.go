@@ -11,5 +11,5 @@ func (f *File) size() int64 {
 
 // size computes the amount of bytes in the bucket using the header information.
 func (t *Thread) size() int64 {
-	return t.Header.Length*EffectiveReplySize - int64(t.Header.Capacity)
+	return t.Header.Length*int64(t.replySize) - int64(t.Header.Capacity)
 }
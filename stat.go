@@ -13,6 +13,14 @@ type FileInfo interface {
 	ID() string
 	QuotaBytesUsed() int64
 
+	// Hash returns the SHA-256 of the content of the file, or nil if the file holds no hash of its current content.
+	// The hash of compressed files covers the stored data.
+	Hash() []byte
+
+	// StoredSize returns the number of bytes of data stored in the replies of the file. It is less than Size for
+	// compressed files.
+	StoredSize() int64
+
 	os.FileInfo
 }
 
@@ -30,22 +38,37 @@ func (f *File) Fstat() (FileInfo, error) {
 
 	f.file = refresh
 
+	size, err := f.length(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
 	return &stat{
-		fileID:   f.file.Id,
-		fileName: f.file.Name,
-		size:     f.size(),
-		modtime:  f.modTime(),
-		sys:      f.file,
+		fileID:     f.file.Id,
+		fileName:   f.file.Name,
+		size:       size,
+		storedSize: f.size(),
+		modtime:    f.modTime(),
+		sys:        f.file,
+		hash:       hashOf(f.index.Header, f.size()),
 	}, nil
 }
 
 // Stat returns file stats, mimicking the os API
 func (f *File) Stat() (os.FileInfo, error) {
+	size, err := f.length(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
 	return &stat{
-		fileID:  f.file.Name,
-		size:    f.size(),
-		modtime: f.modTime(),
-		sys:     f.file,
+		fileID:     f.file.Id,
+		fileName:   f.file.Name,
+		size:       size,
+		storedSize: f.size(),
+		modtime:    f.modTime(),
+		sys:        f.file,
+		hash:       hashOf(f.index.Header, f.size()),
 	}, nil
 }
 
@@ -53,9 +76,11 @@ type stat struct {
 	fileID         string
 	fileName       string
 	size           int64
+	storedSize     int64
 	quotaBytesUsed int64
 	modtime        time.Time
 	sys            *drive.File
+	hash           []byte
 }
 
 func (s *stat) ID() string {
@@ -70,8 +95,9 @@ func (s *stat) Size() int64 {
 	return s.size
 }
 
+// Mode reports a regular file. Drive files have no permission bits, so files are reported readable and writable.
 func (s *stat) Mode() os.FileMode {
-	return os.ModeIrregular
+	return 0644
 }
 
 func (s *stat) ModTime() time.Time {
@@ -89,3 +115,11 @@ func (s *stat) Sys() interface{} {
 func (s *stat) QuotaBytesUsed() int64 {
 	return s.quotaBytesUsed
 }
+
+func (s *stat) Hash() []byte {
+	return s.hash
+}
+
+func (s *stat) StoredSize() int64 {
+	return s.storedSize
+}
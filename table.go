@@ -0,0 +1,364 @@
+package drfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"google.golang.org/api/drive/v3"
+)
+
+// TableEntries is the number of reply IDs stored per reply of a reply table.
+const TableEntries = 256
+
+// TableHeader is the content of the comment holding the reply table of a thread. The replies of the comment map reply
+// ordinals to reply IDs of the thread: reply k is prefixed by "k:", followed by the IDs of replies k*TableEntries up to
+// (k+1)*TableEntries separated by commas. The table allows reading a single reply without listing the thread.
+type TableHeader struct {
+	Thread int       `json:"rt"`
+	UUID   uuid.UUID `json:"u"`
+}
+
+func (t TableHeader) MustMarshall() []byte {
+	p, err := json.Marshal(t)
+	if err != nil {
+		panic(fmt.Errorf("marshaling tableheader failed: %w", err))
+	}
+	return p
+}
+
+func TableHeaderFromJSON(p io.Reader) (*TableHeader, error) {
+	dec := json.NewDecoder(p)
+	dec.DisallowUnknownFields()
+
+	header := &TableHeader{}
+	err := dec.Decode(header)
+	return header, err
+}
+
+// replyTable is the local copy of the reply table of a thread. It is only an optimization: entries are validated when
+// used, and a table which cannot be maintained is left for recovery.RepairTables.
+//
+// The IDs of new replies are buffered, and written by File.commit once they fill a reply of the table, or by
+// File.Sync. A table thus costs an API call per TableEntries replies, rather than one per reply.
+type replyTable struct {
+	mu        sync.Mutex
+	commentID string
+	loaded    bool
+	broken    bool       // the table contains a reply out of position, so it is not extended.
+	replies   []string   // IDs of the replies of the table.
+	entries   [][]string // reply IDs of the thread, per reply of the table.
+	from      int64      // position in the thread of the first pending ID.
+	pending   []string   // IDs of replies of the thread which are not yet written to the table.
+}
+
+// TableID returns the ID of the comment holding the reply table of the thread, if it has one.
+func (t *Thread) TableID() string {
+	t.table.mu.Lock()
+	defer t.table.mu.Unlock()
+	return t.table.commentID
+}
+
+// create the comment holding an empty table. The caller must hold the lock of the table.
+func (r *replyTable) create(ctx context.Context, s Service, fileID string, header TableHeader) error {
+	err := retry(ctx, func() error {
+		client, err := s.Take(ctx, 1)
+		if err != nil {
+			return err
+		}
+		comment, err := client.CommentsService().
+			Create(fileID, &drive.Comment{Content: string(header.MustMarshall())}).
+			Fields("id").
+			Context(ctx).
+			Do()
+		if err != nil {
+			return err
+		}
+		r.commentID = comment.Id
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("create reply table: %w", err)
+	}
+	r.replies, r.entries, r.broken, r.loaded = nil, nil, false, true
+	return nil
+}
+
+// load lists the replies of the table. The caller must hold the lock of the table.
+func (r *replyTable) load(ctx context.Context, s Service, fileID string) error {
+	if r.loaded {
+		return nil
+	}
+
+	var replies []string
+	var entries [][]string
+	var broken bool
+	err := retry(ctx, func() error {
+		replies, entries, broken = nil, nil, false
+		client, err := s.Take(ctx, 1)
+		if err != nil {
+			return err
+		}
+		return client.RepliesService().
+			List(fileID, r.commentID).
+			PageSize(MaxPages).
+			Fields("*").
+			Pages(ctx, func(list *drive.ReplyList) error {
+				for _, reply := range list.Replies {
+					parts := strings.SplitN(reply.Content, ":", 2)
+					if broken || len(parts) != 2 || parts[0] != strconv.Itoa(len(replies)) {
+						// only the replies preceding one out of position are used.
+						broken = true
+						continue
+					}
+					replies = append(replies, reply.Id)
+					entries = append(entries, strings.Split(parts[1], ","))
+				}
+				return nil
+			})
+	})
+	if err != nil {
+		return fmt.Errorf("list reply table: %w", err)
+	}
+
+	r.replies, r.entries, r.broken, r.loaded = replies, entries, broken, true
+	return nil
+}
+
+// lookup returns the ID of reply i of the thread, if the table has an entry for it.
+func (t *Thread) lookup(ctx context.Context, i int64) (string, bool) {
+	r := t.table
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if i >= r.from && i < r.from+int64(len(r.pending)) {
+		return r.pending[i-r.from], true
+	}
+	if r.commentID == "" || r.load(ctx, t.service, t.FileID) != nil {
+		return "", false
+	}
+
+	k, j := int(i/TableEntries), int(i%TableEntries)
+	if k >= len(r.entries) || j >= len(r.entries[k]) {
+		return "", false
+	}
+	return r.entries[k][j], true
+}
+
+// record buffers the ID of reply i of the thread, discarding the pending IDs of later replies.
+func (r *replyTable) record(i int64, id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch end := r.from + int64(len(r.pending)); {
+	case len(r.pending) > 0 && i >= r.from && i <= end:
+		r.pending = append(r.pending[:i-r.from], id)
+	case len(r.pending) > 0 && i > end:
+		// the IDs in between are unknown, and a table never has gaps.
+	default:
+		r.from, r.pending = i, []string{id}
+	}
+}
+
+// forget discards the pending IDs of reply i and later, as these replies were rolled back.
+func (r *replyTable) forget(i int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if i < r.from {
+		i = r.from
+	}
+	if i-r.from < int64(len(r.pending)) {
+		r.pending = r.pending[:i-r.from]
+	}
+}
+
+// flushTable writes the pending IDs of the thread to its table. Unless all is set, only table replies which are full
+// are written, and the remaining IDs are kept pending. The table of a thread is created when the ID of its first reply
+// is written; IDs are only written if all previous entries are present, so a table never has gaps. IDs which cannot
+// be written are discarded.
+func (t *Thread) flushTable(ctx context.Context, all bool) error {
+	r := t.table
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var end = r.from + int64(len(r.pending))
+	if len(r.pending) == 0 || (!all && end-end%TableEntries <= r.from) {
+		return nil
+	}
+
+	if r.commentID == "" {
+		if r.from != 0 {
+			r.pending = nil
+			return nil
+		}
+		header := TableHeader{Thread: t.Header.Number, UUID: t.Header.UUID}
+		if err := r.create(ctx, t.service, t.FileID, header); err != nil {
+			return err
+		}
+	}
+
+	if err := r.load(ctx, t.service, t.FileID); err != nil {
+		return err
+	}
+
+	var recorded int64
+	for _, entries := range r.entries {
+		recorded += int64(len(entries))
+	}
+	if r.broken || r.from > recorded {
+		r.pending = nil
+		return nil
+	}
+
+	for len(r.pending) > 0 {
+		k := int(r.from / TableEntries)
+		lower := int64(k) * TableEntries
+		upper := lower + TableEntries
+		if upper > end {
+			if !all {
+				break
+			}
+			upper = end
+		}
+
+		var entries []string
+		if k < len(r.entries) {
+			if int64(len(r.entries[k])) < r.from-lower {
+				r.pending = nil
+				return nil
+			}
+			entries = append(entries, r.entries[k][:r.from-lower]...)
+		}
+		entries = append(entries, r.pending[:upper-r.from]...)
+
+		err := r.put(ctx, t.service, t.FileID, k, entries)
+		if err != nil {
+			// the state of the table is unknown; it is listed again on next use.
+			r.loaded = false
+			return err
+		}
+		r.pending = r.pending[upper-r.from:]
+		r.from = upper
+	}
+	return nil
+}
+
+// put writes the entries as table reply k, which is either an existing reply or the next. The caller must hold the
+// lock of the table.
+func (r *replyTable) put(ctx context.Context, s Service, fileID string, k int, entries []string) error {
+	content := strconv.Itoa(k) + ":" + strings.Join(entries, ",")
+
+	var created *drive.Reply
+	err := retry(ctx, func() error {
+		client, err := s.Take(ctx, 1)
+		if err != nil {
+			return err
+		}
+
+		if k < len(r.replies) {
+			_, err = client.RepliesService().
+				Update(fileID, r.commentID, r.replies[k], &drive.Reply{Content: content}).
+				Fields("id").
+				Context(ctx).
+				Do()
+			return err
+		}
+
+		created, err = client.RepliesService().
+			Create(fileID, r.commentID, &drive.Reply{Content: content}).
+			Fields("id").
+			Context(ctx).
+			Do()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("update reply table: %w", err)
+	}
+
+	if created != nil {
+		r.replies = append(r.replies, created.Id)
+		r.entries = append(r.entries, entries)
+	} else {
+		r.entries[k] = entries
+	}
+	return nil
+}
+
+// RewriteTable replaces the reply table of the thread by the reply IDs, creating the table if the thread has none.
+// Surplus replies of the table are deleted.
+func (t *Thread) RewriteTable(ctx context.Context, ids []string) error {
+	r := t.table
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pending = nil
+	if r.commentID == "" {
+		header := TableHeader{Thread: t.Header.Number, UUID: t.Header.UUID}
+		if err := r.create(ctx, t.service, t.FileID, header); err != nil {
+			return err
+		}
+	}
+
+	r.loaded = false
+	if err := r.load(ctx, t.service, t.FileID); err != nil {
+		return err
+	}
+
+	if r.broken {
+		// replies out of position cannot be told apart from valid ones; start over.
+		commentID := r.commentID
+		err := retry(ctx, func() error {
+			client, err := t.service.Take(ctx, 1)
+			if err != nil {
+				return err
+			}
+			return client.CommentsService().Delete(t.FileID, commentID).Context(ctx).Do()
+		})
+		if err != nil {
+			return fmt.Errorf("delete reply table: %w", err)
+		}
+
+		r.commentID = ""
+		header := TableHeader{Thread: t.Header.Number, UUID: t.Header.UUID}
+		if err := r.create(ctx, t.service, t.FileID, header); err != nil {
+			return err
+		}
+	}
+
+	var k int
+	for ; k*TableEntries < len(ids); k++ {
+		upper := (k + 1) * TableEntries
+		if upper > len(ids) {
+			upper = len(ids)
+		}
+		entries := append([]string{}, ids[k*TableEntries:upper]...)
+		if err := r.put(ctx, t.service, t.FileID, k, entries); err != nil {
+			r.loaded = false
+			return err
+		}
+	}
+
+	for len(r.replies) > k {
+		last := r.replies[len(r.replies)-1]
+		err := retry(ctx, func() error {
+			client, err := t.service.Take(ctx, 1)
+			if err != nil {
+				return err
+			}
+			return client.RepliesService().Delete(t.FileID, r.commentID, last).Context(ctx).Do()
+		})
+		if err != nil {
+			r.loaded = false
+			return fmt.Errorf("delete reply table entry: %w", err)
+		}
+		r.replies = r.replies[:len(r.replies)-1]
+		r.entries = r.entries[:len(r.entries)-1]
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package drfs_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+	"github.com/kaiserkarel/drfs/fake"
+)
+
+// replyCounter counts the requests listing and getting replies.
+type replyCounter struct {
+	transport http.RoundTripper
+
+	mu    sync.Mutex
+	lists int
+	gets  int
+}
+
+func (c *replyCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	if req.Method == http.MethodGet && strings.HasSuffix(req.URL.Path, "/replies") {
+		c.lists++
+	} else if req.Method == http.MethodGet && strings.Contains(req.URL.Path, "/replies/") {
+		c.gets++
+	}
+	c.mu.Unlock()
+	return c.transport.RoundTrip(req)
+}
+
+func (c *replyCounter) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lists, c.gets = 0, 0
+}
+
+func TestReplyTable(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	counter := &replyCounter{transport: server.Client().Transport}
+	service, err := fake.NewServiceWithTransport(context.Background(), server, counter)
+	require.NoError(t, err)
+
+	file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: 1})
+	require.NoError(t, err)
+
+	payload := randomPayload((drfs.MaxPages+10)*drfs.EffectiveReplySize + 5)
+	_, err = file.WriteCtx(context.Background(), payload)
+	require.NoError(t, err)
+	require.NoError(t, file.Sync())
+
+	stat, err := file.Fstat()
+	require.NoError(t, err)
+	reopened, err := drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+	require.NoError(t, err)
+	assert.NotEmpty(t, reopened.Index().Buckets[0].TableID(), "thread should have a reply table")
+
+	counter.reset()
+	off := int64(drfs.MaxPages+5) * drfs.EffectiveReplySize
+	buf := make([]byte, 100)
+	_, err = reopened.ReadAt(buf, off)
+	require.NoError(t, err)
+	assert.Equal(t, payload[off:off+100], buf)
+
+	// a single list of the table and a single get of the reply, instead of listing every page of the thread.
+	assert.Equal(t, 1, counter.lists, "lists")
+	assert.Equal(t, 1, counter.gets, "gets")
+}
+
+func TestReplyTableRollback(t *testing.T) {
+	server, _, file := newFile(t, 1)
+	defer server.Close()
+
+	thread := file.Index().Buckets[0]
+	require.NoError(t, thread.Put(context.Background(), []byte("hello")))
+	require.NoError(t, thread.Rollback(context.Background(), file.Service(), thread.FileID))
+	require.NoError(t, thread.Put(context.Background(), []byte("world")))
+	require.NoError(t, file.Sync())
+
+	client, err := file.Service().Take(context.Background(), 1)
+	require.NoError(t, err)
+	list, err := client.RepliesService().List(thread.FileID, thread.TableID()).Fields("*").Do()
+	require.NoError(t, err)
+	require.Len(t, list.Replies, 1)
+	assert.Equal(t, "0:"+thread.Header.Tail, list.Replies[0].Content, "the table should refer to the reply replacing the rolled back one")
+}
+
+func TestReplyTableFlush(t *testing.T) {
+	server, _, file := newFile(t, 1)
+	defer server.Close()
+
+	payload := randomPayload((drfs.TableEntries + 5) * drfs.EffectiveReplySize)
+	_, err := file.WriteCtx(context.Background(), payload)
+	require.NoError(t, err)
+
+	thread := file.Index().Buckets[0]
+	client, err := file.Service().Take(context.Background(), 2)
+	require.NoError(t, err)
+
+	// writes only write the table once a reply of the table is full.
+	list, err := client.RepliesService().List(thread.FileID, thread.TableID()).Fields("*").Do()
+	require.NoError(t, err)
+	require.Len(t, list.Replies, 1)
+	assert.Len(t, strings.Split(list.Replies[0].Content, ","), drfs.TableEntries)
+
+	require.NoError(t, file.Sync())
+	list, err = client.RepliesService().List(thread.FileID, thread.TableID()).Fields("*").Do()
+	require.NoError(t, err)
+	require.Len(t, list.Replies, 2)
+	assert.Len(t, strings.Split(list.Replies[1].Content, ","), 5)
+}
+
+func TestReplyTableFlushFails(t *testing.T) {
+	// the comments of the file header, manifest, journal and thread precede the table.
+	server, _, file := newFile(t, 1, &fake.Rule{
+		Match: fake.Match(http.MethodPost, "comments"),
+		Fault: fake.BadRequest,
+		Calls: []int{5},
+	})
+	defer server.Close()
+
+	_, err := file.WriteCtx(context.Background(), []byte("hello"))
+	require.NoError(t, err)
+	require.Error(t, file.Sync(), "failing to write the table should fail Sync")
+
+	// the entries are kept pending, and written by the next Sync.
+	require.NoError(t, file.Sync())
+	thread := file.Index().Buckets[0]
+	client, err := file.Service().Take(context.Background(), 1)
+	require.NoError(t, err)
+	list, err := client.RepliesService().List(thread.FileID, thread.TableID()).Fields("*").Do()
+	require.NoError(t, err)
+	require.Len(t, list.Replies, 1)
+	assert.Equal(t, "0:"+thread.Header.Tail, list.Replies[0].Content)
+}
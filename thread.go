@@ -6,10 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"time"
 
 	"google.golang.org/api/drive/v3"
 
+	"github.com/cenkalti/backoff/v4"
 	"github.com/google/uuid"
 )
 
@@ -50,18 +52,75 @@ type Thread struct {
 	CommentID string       `json:"c"`
 	Header    ThreadHeader `json:"h"`
 
-	service  Service
-	cursor   int // current read position within reply
-	ri       int // index of current reply being read.
-	replies  *drive.ReplyList
-	oldState *ThreadHeader
-	modTime  time.Time
+	service   Service
+	codec     Codec
+	replySize int   // number of bytes stored per reply.
+	cursor    int64 // read position within the data of the thread.
+	cache     *replyCache
+	table     *replyTable
+	sealer    *sealer // encrypts the data of replies, for encrypted files.
+
+	// deferHeader is set for files with a manifest, which commits the state of every thread once per batch instead
+	// of writing the ThreadHeader after every reply.
+	deferHeader bool
+	lost        bool // whether the comment of the thread is missing; see File.RebuildThread.
+	oldState    *ThreadHeader
+	modTime     time.Time
+}
+
+// useCodec sets the codec used to encode the replies of the thread, and the number of bytes stored per reply. Replies
+// read using the previous codec are dropped.
+func (t *Thread) useCodec(c Codec, size int) {
+	t.codec = c
+	t.replySize = size
+	t.cache = newReplyCache()
+}
+
+// codecAt returns the codec of reply i of the thread. Encrypted files encrypt each reply using its position.
+func (t *Thread) codecAt(i int64) Codec {
+	if t.sealer == nil {
+		return t.codec
+	}
+	return sealedCodec{Codec: t.codec, sealer: t.sealer, thread: t.Header.Number, index: i}
+}
+
+// Encode frames p as the content of reply i of the thread.
+func (t *Thread) Encode(i int64, p []byte) string {
+	return encodeReply(t.codecAt(i), p)
+}
+
+// Decode returns the data stored in the content of reply i of the thread.
+func (t *Thread) Decode(i int64, content string) ([]byte, error) {
+	return decodeReply(t.codecAt(i), content)
+}
+
+// Lost reports whether the comment of the thread is missing. The data of lost threads is reconstructed from parity.
+func (t *Thread) Lost() bool {
+	return t.lost
 }
 
 func (t *Thread) Capacity() int {
 	return t.Header.Capacity
 }
 
+// Replies returns the number of replies holding the data of the thread.
+func (t *Thread) Replies() int64 {
+	return t.Header.Length
+}
+
+// Size returns the number of bytes of data stored in the replies of the thread.
+func (t *Thread) Size() int64 {
+	if t.Header.Length == 0 {
+		return 0
+	}
+	return t.Header.Length*int64(t.replySize) - int64(t.Header.Capacity)
+}
+
+// ModTime returns when the thread was last written.
+func (t *Thread) ModTime() time.Time {
+	return t.modTime
+}
+
 func (t *Thread) Update(ctx context.Context, p []byte) error {
 	if t.Header.Capacity == 0 {
 		return errors.New("no capacity")
@@ -76,123 +135,116 @@ func (t *Thread) Update(ctx context.Context, p []byte) error {
 	payload := string(p[:min(t.Header.Capacity, len(p))])
 	var header *ThreadHeader
 	err := retry(ctx, func() error {
+		if header != nil {
+			// the reply has been updated; only updating the header failed.
+			return updateHeader(ctx, t.service, t.FileID, t.CommentID, *header)
+		}
 		newHeader, err := AppendToReply(ctx, t.service, t.FileID, *t, payload)
 		header = newHeader
-		if err != nil {
-			return err
-		}
-		return nil
+		return err
 	})
-	if header != nil {
-		t.Header = *header
-		t.oldState = &old
-	}
+	t.commit(old, header)
 	return err
 }
 
 func (t *Thread) Put(ctx context.Context, p []byte) error {
 	old := t.Header
-	data := string(p[:min(EffectiveReplySize, len(p))])
-	payload := padding + data + padding
+	payload := t.Encode(t.Header.Length, p[:min(t.replySize, len(p))])
 
 	var header *ThreadHeader
+	var attempted bool
 	err := retry(ctx, func() error {
+		if header != nil {
+			// the reply has been created; only updating the header failed.
+			return updateHeader(ctx, t.service, t.FileID, t.CommentID, *header)
+		}
+
+		if attempted {
+			// a failed attempt might still have created the reply, in which case it is adopted instead of creating
+			// a duplicate.
+			newHeader, err := AdoptReply(ctx, t.service, t.FileID, *t, payload)
+			if err != nil || newHeader != nil {
+				header = newHeader
+				return err
+			}
+		}
+
+		attempted = true
 		newHeader, err := CreateReply(ctx, t.service, t.FileID, *t, &drive.Reply{Content: payload})
 		header = newHeader
 		return err
 	})
-	if header != nil {
-		t.Header = *header
-		t.oldState = &old
-	}
+	t.commit(old, header)
 	return err
 }
 
+// commit the header resulting from a write. If the write did not alter the thread, there is nothing to roll back.
+func (t *Thread) commit(old ThreadHeader, header *ThreadHeader) {
+	if header == nil {
+		t.oldState = nil
+		return
+	}
+	t.Header = *header
+	t.oldState = &old
+	t.modTime = time.Now()
+	t.cache.invalidate()
+}
+
 // Rollback to the previous state. This is quite a desperate operation which may leave the file
 // in an inconsistent state if API calls fail.
 func (t *Thread) Rollback(ctx context.Context, service Service, fileID string) error {
 	if t.oldState == nil {
 		return ErrNoRollback
 	}
-	return RollbackCtx(ctx, service, fileID, t.CommentID, *t.oldState, t.Header)
-}
 
-// Read at most maxReplySize bytes into buffer p. Starts reading at the first reply of a thread, incrementing the reply every
-// maxReplySize bytes. ReadCtx expects replies to be always filled. Replies are fetched in bulk and cached locally.
-func (t *Thread) ReadCtx(ctx context.Context, p []byte) (int, error) {
-	// Initial fetch
-	if t.replies == nil {
-		err := retry(ctx, func() error {
-			client, err := t.service.Take(ctx, 1)
-			if err != nil {
-				return err
-			}
-			t.replies, err = client.RepliesService().
-				List(t.FileID, t.CommentID).
-				Context(ctx).
-				Fields("*").
-				Do()
-			return err
-		})
-		if err != nil {
-			return 0, err
-		}
+	err := RollbackCodecCtx(ctx, service, fileID, t.CommentID, t.codecAt(t.oldState.Length-1), *t.oldState, t.Header)
+	if err != nil {
+		return err
 	}
-
-	// fetch new bulk of replies
-	if t.ri > len(t.replies.Replies) {
-		err := retry(ctx, func() error {
-			client, err := t.service.Take(ctx, 1)
-			if err != nil {
-				return err
-			}
-			replies, err := client.RepliesService().
-				List(t.FileID, t.CommentID).
-				PageToken(t.replies.NextPageToken).
-				Fields("id", "content").
-				Context(ctx).
-				Do()
-
-			if err != nil {
-				return err
-			}
-			t.replies = replies
-			t.ri = 0
-			return nil
-		})
+	if !t.deferHeader {
+		err = updateHeader(ctx, service, fileID, t.CommentID, *t.oldState)
 		if err != nil {
-			return 0, err
+			return err
 		}
 	}
+	t.Header = *t.oldState
+	t.oldState = nil
+	t.cache.invalidate()
+	t.table.forget(t.Header.Length)
+	return nil
+}
+
+// ReadCtx reads the data of the thread sequentially, at most up to the end of the current reply. Replies are fetched in
+// pages and cached locally.
+func (t *Thread) ReadCtx(ctx context.Context, p []byte) (int, error) {
+	n, err := t.ReadAtCtx(ctx, p, t.cursor)
+	t.cursor += int64(n)
+	return n, err
+}
 
-	if len(t.replies.Replies) == 0 || t.ri == len(t.replies.Replies) {
+// ReadAtCtx reads data of the thread starting at offset off, at most up to the end of the reply containing off. Every
+// reply but the tail stores exactly replySize bytes.
+func (t *Thread) ReadAtCtx(ctx context.Context, p []byte, off int64) (int, error) {
+	if off >= t.size() {
 		return 0, io.EOF
 	}
 
-	reply := t.replies.Replies[t.ri]
-
-	content := []byte(reply.Content)[1 : len(reply.Content)-1]
-	copy(p, content[t.cursor:])
-	read := min(len(content[t.cursor:]), len(p))
-
-	t.cursor += read
-	if t.cursor == EffectiveReplySize {
-		t.cursor = 0
-		t.ri++
+	var size = int64(t.replySize)
+	chunk, err := t.chunkAt(ctx, off/size)
+	if err != nil {
+		return 0, err
 	}
-	return read, nil
-}
 
-func (t *Thread) Reply() drive.Reply {
-	var i = t.ri
-	if t.ri >= len(t.replies.Replies) {
-		i -= 1
+	var within = int(off % size)
+	if within >= len(chunk) {
+		return 0, fmt.Errorf("thread %d: reply %d has length %d; expected %d: %w", t.Header.Number, off/size, len(chunk), within+1, io.ErrUnexpectedEOF)
 	}
-	r := t.replies.Replies[i]
-	return *r
+	return copy(p, chunk[within:]), nil
 }
 
-// Create a new reply and update the ThreadHeader. The new ThreadHeader is returned.
+// Create a new reply and update the ThreadHeader. The new ThreadHeader is returned. If the reply was created, but
+// updating the ThreadHeader failed, the new ThreadHeader is returned alongside the error. For files with a manifest, the
+// ThreadHeader is committed by the File instead.
 //
 // This function does not actually alter the reply or bucket, making it possible to retry this with exponential backoff.
 func CreateReply(ctx context.Context, s Service, fileID string, bucket Thread, reply *drive.Reply) (*ThreadHeader, error) {
@@ -210,9 +262,19 @@ func CreateReply(ctx context.Context, s Service, fileID string, bucket Thread, r
 		return nil, fmt.Errorf("create reply: %w", err)
 	}
 
-	bucket.Header.Capacity = MaxReplySize - len(reply.Content)
+	data, err := bucket.Decode(bucket.Header.Length, reply.Content)
+	if err != nil {
+		return nil, backoff.Permanent(err)
+	}
+
+	bucket.table.record(bucket.Header.Length, r.Id)
+
+	bucket.Header.Capacity = bucket.replySize - len(data)
 	bucket.Header.Tail = r.Id
 	bucket.Header.Length++
+	if bucket.deferHeader {
+		return &bucket.Header, nil
+	}
 
 	_, err = service.CommentsService().
 		Update(fileID, bucket.CommentID, &drive.Comment{Content: string(bucket.Header.MustMarshall())}).
@@ -221,13 +283,73 @@ func CreateReply(ctx context.Context, s Service, fileID string, bucket Thread, r
 		Do()
 
 	if err != nil {
-		return nil, fmt.Errorf("update comment: %w", err)
+		return &bucket.Header, fmt.Errorf("update comment: %w", err)
 	}
 	return &bucket.Header, nil
 }
 
-// AppendToReply adds the content to the buckets tail. The caller should ensure that the content of the new
-// reply does not exceed EffectiveReplySize.
+// AdoptReply checks whether a reply with the given content exists directly after the buckets tail, which happens if
+// Drive created the reply but the response was lost. If so, the ThreadHeader is updated to include the reply and
+// returned. If no such reply exists, nil is returned.
+func AdoptReply(ctx context.Context, s Service, fileID string, bucket Thread, content string) (*ThreadHeader, error) {
+	var pages = int(bucket.Header.Length/MaxPages) + 2
+	service, err := s.Take(ctx, pages)
+	if err != nil {
+		return nil, err
+	}
+
+	var adopted *drive.Reply
+	var index int64
+	err = service.RepliesService().
+		List(fileID, bucket.CommentID).
+		PageSize(MaxPages).
+		Fields("*").
+		Pages(ctx, func(list *drive.ReplyList) error {
+			for _, reply := range list.Replies {
+				if index == bucket.Header.Length {
+					adopted = reply
+				}
+				index++
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("list replies: %w", err)
+	}
+
+	if adopted == nil {
+		return nil, nil
+	}
+
+	if index > bucket.Header.Length+1 || adopted.Content != content {
+		err = fmt.Errorf("thread %d has %d replies; expected at most %d", bucket.Header.Number, index, bucket.Header.Length+1)
+		return nil, backoff.Permanent(err)
+	}
+
+	data, err := bucket.Decode(bucket.Header.Length, content)
+	if err != nil {
+		return nil, backoff.Permanent(err)
+	}
+
+	bucket.table.record(bucket.Header.Length, adopted.Id)
+
+	bucket.Header.Capacity = bucket.replySize - len(data)
+	bucket.Header.Tail = adopted.Id
+	bucket.Header.Length++
+	if bucket.deferHeader {
+		return &bucket.Header, nil
+	}
+
+	err = updateHeader(ctx, s, fileID, bucket.CommentID, bucket.Header)
+	if err != nil {
+		return &bucket.Header, err
+	}
+	return &bucket.Header, nil
+}
+
+// AppendToReply adds the content to the buckets tail. The caller should ensure that the data of the new reply does
+// not exceed the number of bytes stored per reply. If the reply was updated, but updating the ThreadHeader failed, the
+// new ThreadHeader is returned alongside the error.
 func AppendToReply(ctx context.Context, s Service, fileID string, bucket Thread, content string) (*ThreadHeader, error) {
 	service, err := s.Take(ctx, 3)
 	if err != nil {
@@ -243,35 +365,80 @@ func AppendToReply(ctx context.Context, s Service, fileID string, bucket Thread,
 		return nil, fmt.Errorf("get reply: %w", err)
 	}
 
-	reply.Content = reply.Content[:len(reply.Content)-1] + content + padding
+	data, err := bucket.Decode(bucket.Header.Length-1, reply.Content)
+	if err != nil {
+		return nil, backoff.Permanent(fmt.Errorf("decode reply: %w", err))
+	}
+
+	var length = bucket.replySize - bucket.Header.Capacity
+	var appended = length + len(content)
+
+	switch {
+	case len(data) == appended && strings.HasSuffix(string(data), content):
+		// a previous attempt updated the reply, but the response was lost.
+	case len(data) == length:
+		// TODO remove this once certain no 1 of errors are present
+		if appended > bucket.replySize {
+			return nil, backoff.Permanent(fmt.Errorf("reply exceeded max size: %d", appended))
+		}
+
+		reply.Content = bucket.Encode(bucket.Header.Length-1, append(data, content...))
+
+		_, err = service.RepliesService().
+			Update(fileID, bucket.CommentID, bucket.Header.Tail, reply).
+			Fields("*").
+			Context(ctx).
+			Do()
 
-	// TODO remove this once certain no 1 of errors are present
-	if len(reply.Content) > MaxReplySize {
-		return nil, fmt.Errorf("reply exceeded max size: %d", len(reply.Content))
+		if err != nil {
+			return nil, fmt.Errorf("update reply: %w", err)
+		}
+	default:
+		err = fmt.Errorf("tail of thread %d has length %d; expected %d", bucket.Header.Number, len(data), length)
+		return nil, backoff.Permanent(err)
 	}
 
-	bucket.Header.Capacity = MaxReplySize - len(reply.Content)
+	bucket.Header.Capacity = bucket.replySize - appended
+	if bucket.deferHeader {
+		return &bucket.Header, nil
+	}
 
-	_, err = service.RepliesService().
-		Update(fileID, bucket.CommentID, bucket.Header.Tail, reply).
+	_, err = service.CommentsService().
+		Update(fileID, bucket.CommentID, &drive.Comment{Content: string(bucket.Header.MustMarshall())}).
 		Fields("*").
 		Context(ctx).
 		Do()
 
 	if err != nil {
-		return nil, fmt.Errorf("update reply: %w", err)
+		return &bucket.Header, fmt.Errorf("update comment: %w", err)
+	}
+	return &bucket.Header, nil
+}
+
+// WriteHeader writes the ThreadHeader to the comment of the thread. Files with a manifest commit the state of threads
+// using File.Sync instead.
+func (t *Thread) WriteHeader(ctx context.Context) error {
+	return retry(ctx, func() error {
+		return updateHeader(ctx, t.service, t.FileID, t.CommentID, t.Header)
+	})
+}
+
+// updateHeader writes the ThreadHeader to the comment of the thread.
+func updateHeader(ctx context.Context, s Service, fileID string, commentID string, header ThreadHeader) error {
+	service, err := s.Take(ctx, 1)
+	if err != nil {
+		return err
 	}
 
 	_, err = service.CommentsService().
-		Update(fileID, bucket.CommentID, &drive.Comment{Content: string(bucket.Header.MustMarshall())}).
+		Update(fileID, commentID, &drive.Comment{Content: string(header.MustMarshall())}).
 		Fields("*").
 		Context(ctx).
 		Do()
-
 	if err != nil {
-		return &bucket.Header, fmt.Errorf("update comment: %w", err)
+		return fmt.Errorf("update comment: %w", err)
 	}
-	return &bucket.Header, nil
+	return nil
 }
 
 func min(a, b int) int {
@@ -280,19 +447,3 @@ func min(a, b int) int {
 	}
 	return b
 }
-
-// byLengthThenNumber defines a sorting interface for first sorting on shortest length; then by ascending bucket number.
-type byLengthThenNumber []*Thread
-
-func (s byLengthThenNumber) Len() int {
-	return len(s)
-}
-func (s byLengthThenNumber) Swap(i, j int) {
-	s[i], s[j] = s[j], s[i]
-}
-func (s byLengthThenNumber) Less(i, j int) bool {
-	if s[i].Header.Length == s[j].Header.Length {
-		return s[i].Header.Number < s[j].Header.Number
-	}
-	return s[i].Header.Length < s[j].Header.Length
-}
@@ -0,0 +1,43 @@
+package drfs_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/drive/v3"
+
+	"github.com/kaiserkarel/drfs"
+	"github.com/kaiserkarel/drfs/fake"
+)
+
+func TestThreadStats(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+
+	service, err := fake.NewService(context.Background(), server)
+	require.NoError(t, err)
+
+	file, err := drfs.CreateFileCtx(context.Background(), service, t.Name(), drfs.FileOptions{NumThreads: 3, Codec: drfs.Base85})
+	require.NoError(t, err)
+	var payload = []byte(strings.Repeat("lorem ipsum dolor sit amet ", 2000))
+	_, err = file.WriteCtx(context.Background(), payload)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	stat, err := file.Fstat()
+	require.NoError(t, err)
+	reopened, err := drfs.OpenCtx(context.Background(), stat.Sys().(*drive.File), service)
+	require.NoError(t, err)
+
+	var size, replies int64
+	for _, thread := range reopened.Index().Buckets {
+		size += thread.Size()
+		replies += thread.Replies()
+		assert.False(t, thread.ModTime().IsZero())
+	}
+	assert.Equal(t, int64(len(payload)), size)
+	assert.Greater(t, replies, int64(len(reopened.Index().Buckets)))
+}
@@ -1,10 +1,15 @@
 package drfs
 
 import (
+	"context"
 	"time"
 )
 
 func (f *File) modTime() time.Time {
+	if f.index.Header.ModTime != nil {
+		return *f.index.Header.ModTime
+	}
+
 	var mod time.Time
 	for _, b := range f.index.Buckets {
 		if b.modTime.After(mod) {
@@ -13,3 +18,18 @@ func (f *File) modTime() time.Time {
 	}
 	return mod
 }
+
+// parseTime parses a timestamp of the Drive API, returning the zero time if absent.
+func parseTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339, s)
+	return t
+}
+
+// SetModTimeCtx sets the modification time reported by Stat. Once the content of the file changes, the time of the
+// last write is reported again.
+func (f *File) SetModTimeCtx(ctx context.Context, mtime time.Time) error {
+	var header = f.index.Header
+	mtime = mtime.UTC()
+	header.ModTime = &mtime
+	return f.writeHeader(ctx, header)
+}
@@ -1,6 +1,7 @@
 package drfs
 
 import (
+	"container/ring"
 	"context"
 	"fmt"
 	"math"
@@ -17,14 +18,23 @@ func (f *File) Write(p []byte) (int, error) {
 	return f.WriteCtx(context.Background(), p)
 }
 
-// Write using the provided context for API calls.
+// Write using the provided context for API calls. The data of compressed files is compressed first, and written once
+// the compression flushes it or by Sync.
 func (f *File) WriteCtx(ctx context.Context, p []byte) (int, error) {
+	if f.compression != nil {
+		return f.writeCompressed(ctx, p)
+	}
+	return f.writeStored(ctx, p)
+}
+
+// writeStored writes the data as stored in the threads.
+func (f *File) writeStored(ctx context.Context, p []byte) (int, error) {
 	var n int
 	for n <= len(p) {
 		if len(p[n:]) == 0 {
 			return n, nil
 		}
-		a, err := f.WriteBatch(context.TODO(), p[n:])
+		a, err := f.WriteBatch(ctx, p[n:])
 		n += a
 		if err != nil || a == 0 {
 			return n, err
@@ -33,16 +43,20 @@ func (f *File) WriteCtx(ctx context.Context, p []byte) (int, error) {
 	return n, nil
 }
 
-// WriteBatch writes up to FileOptions.NumThreads * EffectiveReplySize bytes to the drfs file.
+// WriteBatch writes up to FileOptions.NumThreads * ReplySize bytes to the drfs file. The data is stored as is, so the
+// data written to compressed files must be compressed.
 func (f *File) WriteBatch(ctx context.Context, p []byte) (int, error) {
 	var numbuckets = len(f.index.Buckets)
 	var errs = make([]error, numbuckets)
 	var written = make([]int, numbuckets)
+	var threads = make([]*Thread, numbuckets)
+	var positions = make([]*ring.Ring, numbuckets)
 	var incompleteWrite = newAtomicCheck()
 	grp := &sync.WaitGroup{}
 
 	var put = func(thread *Thread, buf []byte, i int) {
 		grp.Add(1)
+		threads[i] = thread
 
 		go func() {
 			var err error
@@ -55,28 +69,58 @@ func (f *File) WriteBatch(ctx context.Context, p []byte) (int, error) {
 
 			errs[i] = err
 			written[i] = len(buf)
-			if thread.Header.Capacity > 0 {
+			if err == nil && thread.Header.Capacity > 0 {
 				incompleteWrite.set()
 			}
 			grp.Done()
 		}()
 	}
 
+	if err := f.RecoverCtx(ctx); err != nil {
+		return 0, err
+	}
+
+	if f.erasure != nil {
+		for _, t := range f.index.Threads() {
+			if t.lost {
+				return 0, fmt.Errorf("thread %d: %w; rebuild it before writing", t.Header.Number, ErrThreadLost)
+			}
+		}
+	}
+
 	last := f.writers.Peek()
 	var offset int
 	var skip int
 	if last.Capacity() > 0 {
 		skip = 1
 		offset = min(len(p), last.Capacity())
-		f.writers.Next()
-		put(last, p[:offset], 0)
 	}
 
 	var remaining = p[offset:]
-	var segments = slice(remaining, EffectiveReplySize)
+	var segments = slice(remaining, f.replySize)
+	if len(segments) > numbuckets-skip {
+		segments = segments[:numbuckets-skip]
+	}
+
+	// record the batch before writing any reply, so it can be recovered if the writer dies.
+	var size = f.size()
+	var batch = offset
+	if len(segments) > 0 {
+		batch += segments[len(segments)-1].upper
+	}
+	if err := f.beginBatch(ctx, size, size+int64(batch)); err != nil {
+		return 0, err
+	}
 
-	for i := skip; i < numbuckets && i < len(segments); i++ {
+	if skip == 1 {
+		positions[0] = f.writers.Ring
+		f.writers.Next()
+		put(last, p[:offset], 0)
+	}
+
+	for i := skip; i-skip < len(segments); i++ {
 		payload := remaining[segments[i-skip].lower:segments[i-skip].upper]
+		positions[i] = f.writers.Ring
 		put(f.writers.Get(), payload, i)
 	}
 	grp.Wait()
@@ -85,32 +129,89 @@ func (f *File) WriteBatch(ctx context.Context, p []byte) (int, error) {
 		f.writers.Ring = f.writers.Prev()
 	}
 
-	for k, err := range errs {
-		err := err
-		if err == nil {
-			continue
+	var first = len(errs) // the first failed write.
+	var err error
+	for k := range errs {
+		if errs[k] != nil {
+			first, err = k, errs[k]
+			break
 		}
+	}
+
+	if err != nil {
 		// rollback all writes from this error
 		// rollback ring to write that first errored
-		f.writers.Ring = f.writers.Move(-k)
-		bucket := f.writers.Get()
-
-		grp, _ := errgroup.WithContext(context.TODO())
-		for i := k; i < len(errs); i++ {
-			written[i] = 0
-			grp.Go(func() error {
-				errRB := bucket.Rollback(context.TODO(), f.service, f.file.Id)
-				if err != nil {
-					return fmt.Errorf("unable to write: %w [rollback status: %s]", err, errRB)
-				}
-				return nil
-			})
+		f.writers.Ring = positions[first]
+		errRB := f.rollback(ctx, threads[first:], written[first:])
+		if errRB != nil {
+			return sum(written), fmt.Errorf("unable to write: %w [rollback status: %s]", err, errRB) // an error here is a catastrophic failure.
+		}
+	}
+
+	// undo rolls back the remaining writes of the batch, including its parity, and restores the manifest.
+	var parity *parityWrite
+	var undo = func(cause error) error {
+		if first > 0 {
+			f.writers.Ring = positions[0]
+		}
+		var errRB error
+		if parity != nil {
+			errRB = parity.rollback(ctx)
 		}
-		err = grp.Wait()
-		return sum(written), err // an error here is a catastrophic failure.
+		if errRB == nil {
+			errRB = f.rollback(ctx, threads[:first], written[:first])
+		}
+		if errRB == nil {
+			errRB = f.commit(ctx)
+		}
+		if errRB != nil {
+			return fmt.Errorf("unable to write: %w [rollback status: %s]", cause, errRB) // an error here is a catastrophic failure.
+		}
+		return cause
 	}
 
-	return sum(written), nil
+	// the parity of the written stripes is updated before committing, so committed data is always covered.
+	if f.erasure != nil && sum(written) > 0 {
+		var errParity error
+		parity, errParity = f.writeParity(ctx, size, p[:sum(written)])
+		if errParity != nil {
+			err = undo(fmt.Errorf("unable to write parity: %w", errParity))
+			return sum(written), err
+		}
+	}
+
+	// the state of the threads is committed once per batch. If committing fails, the remaining writes are rolled back
+	// as well, and the manifest restored.
+	errCommit := f.commit(ctx)
+	if errCommit != nil {
+		err = undo(errCommit)
+		return sum(written), err
+	}
+
+	f.hashWritten(p[:sum(written)], size)
+
+	// the batch is committed; failing to write the reply tables leaves their entries pending.
+	errTables := f.flushTables(ctx, false)
+	if errTables != nil {
+		return sum(written), fmt.Errorf("unable to write reply tables: %w", errTables)
+	}
+	return sum(written), err
+}
+
+// rollback the writes of a batch to the threads. Threads which were not written are nil.
+func (f *File) rollback(ctx context.Context, threads []*Thread, written []int) error {
+	grp, ctx := errgroup.WithContext(ctx)
+	for i, thread := range threads {
+		thread := thread
+		written[i] = 0
+		if thread == nil || thread.oldState == nil {
+			continue // the write did not alter the thread.
+		}
+		grp.Go(func() error {
+			return thread.Rollback(ctx, f.service, f.file.Id)
+		})
+	}
+	return grp.Wait()
 }
 
 func slice(p []byte, size int) []bounds {